@@ -0,0 +1,118 @@
+package ap
+
+import (
+	"bytes"
+	"time"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKey is an SSH public key that an actor registered for logging in to the SSH server.
+type AuthorizedKey struct {
+	// Key is the public key in the OpenSSH authorized_keys format, without the comment.
+	Key     string    `jsonld:"key"`
+	Comment string    `jsonld:"comment,omitempty"`
+	Added   time.Time `jsonld:"added,omitempty"`
+	Expires time.Time `jsonld:"expires,omitempty"`
+}
+
+// AuthorizedKeys is a list of SSH public keys.
+type AuthorizedKeys []AuthorizedKey
+
+// UnmarshalJSON decodes the list of SSH public keys.
+func (a *AuthorizedKeys) UnmarshalJSON(data []byte) error {
+	return unmarshalList(data, (*[]AuthorizedKey)(a))
+}
+
+// PublicKey parses the SSH public key.
+func (a AuthorizedKey) PublicKey() (ssh.PublicKey, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(a.Key))
+	return pub, err
+}
+
+// Fingerprint returns the SHA256 fingerprint of the SSH public key.
+func (a AuthorizedKey) Fingerprint() string {
+	pub, err := a.PublicKey()
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(pub)
+}
+
+// Expired returns true if the key has an expiration time, and it has passed.
+func (a AuthorizedKey) Expired(when time.Time) bool {
+	return !a.Expires.IsZero() && a.Expires.Before(when)
+}
+
+// Authorizes returns true if the pub key is in the list of keys, and it hasn't expired.
+func (a AuthorizedKeys) Authorizes(pub ssh.PublicKey) bool {
+	now := time.Now().UTC()
+	for _, k := range a {
+		if k.Expired(now) {
+			continue
+		}
+		if key, err := k.PublicKey(); err == nil && bytes.Equal(key.Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAuthorizedKey parses the line in the OpenSSH authorized_keys format, and adds it to the list
+// of SSH public keys of the actor. If the comment is empty, we use the one from the line.
+func AddAuthorizedKey(metaSaver storage.MetadataStorage, actor vocab.IRI, line []byte, comment string, expires time.Time) (*AuthorizedKey, error) {
+	pub, lineComment, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid SSH public key")
+	}
+	if comment == "" {
+		comment = lineComment
+	}
+
+	m := new(Metadata)
+	_ = metaSaver.LoadMetadata(actor, m)
+
+	fingerprint := ssh.FingerprintSHA256(pub)
+	for _, k := range m.AuthorizedKeys {
+		if k.Fingerprint() == fingerprint {
+			return nil, errors.Conflictf("SSH public key %s already exists for actor %s", fingerprint, actor)
+		}
+	}
+	key := AuthorizedKey{
+		Key:     string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub))),
+		Comment: comment,
+		Added:   time.Now().UTC().Truncate(time.Second),
+		Expires: expires,
+	}
+	m.AuthorizedKeys = append(m.AuthorizedKeys, key)
+	if err = metaSaver.SaveMetadata(actor, m); err != nil {
+		return nil, errors.Annotatef(err, "failed saving metadata for actor: %s", actor)
+	}
+	return &key, nil
+}
+
+// RemoveAuthorizedKey removes the SSH public key with the received fingerprint from the list of keys of the actor.
+func RemoveAuthorizedKey(metaSaver storage.MetadataStorage, actor vocab.IRI, fingerprint string) error {
+	m := new(Metadata)
+	if err := metaSaver.LoadMetadata(actor, m); err != nil {
+		return errors.Annotatef(err, "unable to load metadata for actor: %s", actor)
+	}
+
+	keys := make(AuthorizedKeys, 0, len(m.AuthorizedKeys))
+	for _, k := range m.AuthorizedKeys {
+		if k.Fingerprint() != fingerprint {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(m.AuthorizedKeys) {
+		return errors.NotFoundf("SSH public key %s not found for actor %s", fingerprint, actor)
+	}
+	m.AuthorizedKeys = keys
+	if err := metaSaver.SaveMetadata(actor, m); err != nil {
+		return errors.Annotatef(err, "failed saving metadata for actor: %s", actor)
+	}
+	return nil
+}
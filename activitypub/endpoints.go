@@ -0,0 +1,35 @@
+package ap
+
+import (
+	vocab "github.com/go-ap/activitypub"
+)
+
+// ArchiveEndpoint is the name of the endpoint where the actors can request an archive of their data.
+const ArchiveEndpoint = "archive"
+
+// ArchiveIRI returns the IRI of the archive endpoint of the actor.
+func ArchiveIRI(actor vocab.Item) vocab.IRI {
+	return actor.GetLink().AddPath(ArchiveEndpoint)
+}
+
+// AddEndpoint adds the endpoint with the received name to the "endpoints" property of the actor in doc.
+// The vocab.Endpoints type has only the properties from the ActivityPub specification,
+// so we need to operate on the JSON document for adding others.
+func AddEndpoint(doc []byte, name string, iri vocab.IRI) ([]byte, error) {
+	document, err := DecodeActorDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	document.SetEndpoint(name, iri)
+	return document.Encode()
+}
+
+// SetEndpoint adds the endpoint with the received name to the "endpoints" property of the actor.
+func (d ActorDocument) SetEndpoint(name string, iri vocab.IRI) {
+	endpoints, ok := d["endpoints"].(map[string]any)
+	if !ok {
+		endpoints = make(map[string]any)
+	}
+	endpoints[name] = iri.String()
+	d["endpoints"] = endpoints
+}
@@ -0,0 +1,104 @@
+package ap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAddEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"without endpoints", `{"id":"https://example.com/actors/1","type":"Person"}`},
+		{"with endpoints", `{"id":"https://example.com/actors/1","type":"Person","endpoints":{"proxyUrl":"https://example.com/proxyUrl"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := AddEndpoint([]byte(tt.doc), ArchiveEndpoint, "https://example.com/actors/1/archive")
+			if err != nil {
+				t.Fatalf("AddEndpoint() error = %s", err)
+			}
+			doc := struct {
+				Endpoints map[string]string `json:"endpoints"`
+			}{}
+			if err = json.Unmarshal(raw, &doc); err != nil {
+				t.Fatalf("invalid JSON document: %s", err)
+			}
+			if got := doc.Endpoints[ArchiveEndpoint]; got != "https://example.com/actors/1/archive" {
+				t.Errorf("AddEndpoint() archive endpoint = %q", got)
+			}
+		})
+	}
+}
+
+func TestActorDocument(t *testing.T) {
+	raw := `{"id":"https://example.com/actors/1","type":"Person","publicKey":{"id":"https://example.com/actors/1#main","owner":"https://example.com/actors/1","publicKeyPem":"PEM"},"summary":"<b>&</b>"}`
+	document, err := DecodeActorDocument([]byte(raw))
+	if err != nil {
+		t.Fatalf("DecodeActorDocument() error = %s", err)
+	}
+	act := document.Actor()
+	if act == nil {
+		t.Fatalf("Actor() returned nil for an actor document")
+	}
+	if act.ID != "https://example.com/actors/1" || act.PublicKey.ID != "https://example.com/actors/1#main" || act.PublicKey.PublicKeyPem != "PEM" {
+		t.Errorf("Actor() = %+v", act)
+	}
+
+	document.SetEndpoint(ArchiveEndpoint, "https://example.com/actors/1/archive")
+	document.SetManuallyApprovesFollowers(true)
+	out, err := document.Encode()
+	if err != nil {
+		t.Fatalf("Encode() error = %s", err)
+	}
+	doc := struct {
+		Summary                   string            `json:"summary"`
+		Endpoints                 map[string]string `json:"endpoints"`
+		ManuallyApprovesFollowers bool              `json:"manuallyApprovesFollowers"`
+	}{}
+	if err = json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("invalid JSON document: %s", err)
+	}
+	if doc.Summary != "<b>&</b>" || doc.Endpoints[ArchiveEndpoint] == "" || !doc.ManuallyApprovesFollowers {
+		t.Errorf("Encode() = %s", out)
+	}
+
+	note, _ := DecodeActorDocument([]byte(`{"id":"https://example.com/objects/1","type":"Note"}`))
+	if note.Actor() != nil {
+		t.Errorf("Actor() returned an actor for a Note document")
+	}
+}
+
+func TestEditActivityObject(t *testing.T) {
+	raw := `{"type":"Update","actor":"https://example.com/actors/1","object":{"id":"https://example.com/actors/1","type":"Person"}}`
+	out, err := EditActivityObject([]byte(raw), func(object ActorDocument) error {
+		object.SetManuallyApprovesFollowers(true)
+		object.SetManuallyApprovesFollowers(true)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EditActivityObject() error = %s", err)
+	}
+	doc := struct {
+		Object struct {
+			Context                   any  `json:"@context"`
+			ManuallyApprovesFollowers bool `json:"manuallyApprovesFollowers"`
+		} `json:"object"`
+	}{}
+	if err = json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("invalid JSON document: %s", err)
+	}
+	if !doc.Object.ManuallyApprovesFollowers {
+		t.Errorf("the property has not been added to the object: %s", out)
+	}
+	if _, ok := doc.Object.Context.(map[string]any); !ok {
+		t.Errorf("the context term has been added more than once: %s", out)
+	}
+
+	if _, err = EditActivityObject([]byte(`{"type":"Like","object":"https://example.com/objects/1"}`), func(ActorDocument) error {
+		return nil
+	}); err == nil {
+		t.Errorf("EditActivityObject() expected an error for an activity without an embedded object")
+	}
+}
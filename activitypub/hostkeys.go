@@ -0,0 +1,72 @@
+package ap
+
+import (
+	"crypto"
+	"encoding/pem"
+	"time"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyTypes are the types of SSH host keys that get generated for the server.
+var HostKeyTypes = []KeyType{KeyTypeED25519, KeyTypeRSA}
+
+// SaveHostKeys stores the key pairs as the SSH host keys in the metadata of the service actor,
+// replacing the existing host keys of the same type.
+func SaveHostKeys(metaSaver storage.MetadataStorage, service vocab.IRI, pairs ...KeyPair) error {
+	m := new(Metadata)
+	_ = metaSaver.LoadMetadata(service, m)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, pair := range pairs {
+		_, prv, err := EncodeKeyPair(pair)
+		if err != nil {
+			return err
+		}
+		key := KeyMetadata{Type: pair.Type, PrivateKey: pem.EncodeToMemory(&prv), Created: now}
+
+		replaced := false
+		for i, k := range m.HostKeys {
+			if k.Type == pair.Type {
+				m.HostKeys[i] = key
+				replaced = true
+			}
+		}
+		if !replaced {
+			m.HostKeys = append(m.HostKeys, key)
+		}
+	}
+	if err := metaSaver.SaveMetadata(service, m); err != nil {
+		return errors.Annotatef(err, "failed saving host keys for service: %s", service)
+	}
+	return nil
+}
+
+// LoadHostKeys loads the SSH host keys from the metadata of the service actor.
+func LoadHostKeys(metaLoader storage.MetadataStorage, service vocab.IRI) ([]KeyPair, error) {
+	m := new(Metadata)
+	if err := metaLoader.LoadMetadata(service, m); err != nil {
+		return nil, err
+	}
+	pairs := make([]KeyPair, 0, len(m.HostKeys))
+	for _, k := range m.HostKeys {
+		pair, err := k.KeyPair()
+		if err != nil {
+			return pairs, errors.Annotatef(err, "invalid %s host key", k.Type)
+		}
+		pairs = append(pairs, *pair)
+	}
+	return pairs, nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of the public key, in the format used by OpenSSH.
+func Fingerprint(pub crypto.PublicKey) string {
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(sshPub)
+}
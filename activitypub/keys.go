@@ -1,14 +1,17 @@
 package ap
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"time"
 
 	"git.sr.ht/~mariusor/storage-all"
 	vocab "github.com/go-ap/activitypub"
@@ -26,8 +29,8 @@ const (
 	KeyTypeRSA     KeyType = "RSA"
 )
 
-func AddKeyToItem(metaSaver storage.MetadataStorage, it vocab.Item, pair KeyPair) error {
-	if err := vocab.OnActor(it, AddKeyToPerson(metaSaver, pair)); err != nil {
+func AddKeyToItem(metaSaver storage.MetadataStorage, it vocab.Item, pairs ...KeyPair) error {
+	if err := vocab.OnActor(it, AddKeyToPerson(metaSaver, pairs...)); err != nil {
 		return errors.Annotatef(err, "failed to process actor: %s", it.GetID())
 	}
 	return nil
@@ -42,38 +45,307 @@ type KeyPair struct {
 type Metadata struct {
 	Pw         []byte `jsonld:"pw,omitempty"`
 	PrivateKey []byte `jsonld:"key,omitempty"`
+	// KeyCreated is the time when the main key of the actor has been generated.
+	KeyCreated time.Time `jsonld:"keyCreated,omitempty"`
+	// Keys holds the additional keys of the actor, which get published as FEP-521a assertion methods,
+	// and the history of the keys that have been retired by a rotation.
+	Keys Keys `jsonld:"keys,omitempty"`
+	// HostKeys holds the SSH host keys of the server, and it's set only for the service actor.
+	HostKeys Keys `jsonld:"hostKeys,omitempty"`
+	// AuthorizedKeys holds the SSH public keys that the actor can use to log in to the SSH server.
+	AuthorizedKeys AuthorizedKeys `jsonld:"authorizedKeys,omitempty"`
+	// Role is the level of access the actor has for the administrative commands.
+	Role Role `jsonld:"role,omitempty"`
+	// ManuallyApprovesFollowers is published in the representation of the actor, to signal to other servers
+	// that the follow requests are not accepted automatically.
+	ManuallyApprovesFollowers bool `jsonld:"manuallyApprovesFollowers,omitempty"`
+	// Suspended is the time when the actor has been suspended by a moderator, it's zero for active actors.
+	Suspended time.Time `jsonld:"suspended,omitempty"`
+	// SuspendedUntil is the time when a temporary suspension expires, it's zero for the permanent ones.
+	SuspendedUntil time.Time `jsonld:"suspendedUntil,omitempty"`
+	// SuspendReason is the reason the moderator gave for the suspension.
+	SuspendReason string `jsonld:"suspendReason,omitempty"`
+	// AlsoKnownAs holds the previous IRIs of the actor, which get published after the instance has been rehomed.
+	AlsoKnownAs vocab.IRIs `jsonld:"alsoKnownAs,omitempty"`
+	// MovedTo is the new IRI of an actor that has been moved, published by the representation of its old IRI.
+	MovedTo vocab.IRI `jsonld:"movedTo,omitempty"`
+	// Authorizations holds the codes of the OAuth2 authorizations issued to the actor, as the storage
+	// can load them only by their code.
+	Authorizations Codes `jsonld:"authorizations,omitempty"`
+	// AccessTokens holds the OAuth2 access tokens issued to the actor.
+	AccessTokens Codes `jsonld:"accessTokens,omitempty"`
 }
 
-func AddKeyToPerson(metaSaver storage.MetadataStorage, pair KeyPair) func(act *vocab.Actor) error {
+// IsSuspended returns true if the actor is suspended at the "when" time.
+func (m Metadata) IsSuspended(when time.Time) bool {
+	if m.Suspended.IsZero() {
+		return false
+	}
+	return m.SuspendedUntil.IsZero() || when.Before(m.SuspendedUntil)
+}
+
+// LiftSuspension clears the suspension of the actor.
+func (m *Metadata) LiftSuspension() {
+	m.Suspended = time.Time{}
+	m.SuspendedUntil = time.Time{}
+	m.SuspendReason = ""
+}
+
+// Codes is a list of OAuth2 authorization codes, or access tokens.
+type Codes []string
+
+// UnmarshalJSON decodes the list of codes.
+func (c *Codes) UnmarshalJSON(data []byte) error {
+	return unmarshalList(data, (*[]string)(c))
+}
+
+// Keys is a list of actor keys.
+type Keys []KeyMetadata
+
+// UnmarshalJSON decodes the list of keys.
+func (k *Keys) UnmarshalJSON(data []byte) error {
+	return unmarshalList(data, (*[]KeyMetadata)(k))
+}
+
+// unmarshalList decodes a list of elements, taking into account that the JSON-LD encoder compacts
+// lists with a single element to the element itself.
+func unmarshalList[T any](data []byte, list *[]T) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && (data[0] == '{' || data[0] == '"') {
+		var el T
+		if err := jsonld.Unmarshal(data, &el); err != nil {
+			return err
+		}
+		*list = []T{el}
+		return nil
+	}
+	return jsonld.Unmarshal(data, list)
+}
+
+// KeyMetadata represents an additional key of an actor, or a retired one.
+type KeyMetadata struct {
+	ID         vocab.IRI `jsonld:"id,omitempty"`
+	Type       KeyType   `jsonld:"type"`
+	PrivateKey []byte    `jsonld:"key,omitempty"`
+	// PublicKey holds the PEM encoded public key of retired keys, for which we don't keep the private key.
+	PublicKey []byte    `jsonld:"pub,omitempty"`
+	Created   time.Time `jsonld:"created,omitempty"`
+	// Expires is set when the key gets retired, and until then it can still be used for verifying signatures.
+	Expires time.Time `jsonld:"expires,omitempty"`
+}
+
+// KeyPair decodes the PEM encoded private key of the metadata.
+func (k KeyMetadata) KeyPair() (*KeyPair, error) {
+	return KeyPairFromPrivateBytes(k.PrivateKey)
+}
+
+// Public returns the public key of the metadata.
+func (k KeyMetadata) Public() (crypto.PublicKey, error) {
+	if len(k.PublicKey) > 0 {
+		return PublicKeyFromPEM(string(k.PublicKey))
+	}
+	pair, err := k.KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return pair.Public, nil
+}
+
+// Retired returns true if the key has been replaced by a rotation.
+func (k KeyMetadata) Retired() bool {
+	return !k.Expires.IsZero()
+}
+
+// Expired returns true if the key has been retired, and its overlap window has passed.
+func (k KeyMetadata) Expired(when time.Time) bool {
+	return k.Retired() && k.Expires.Before(when)
+}
+
+// AssertionKey returns the ID and the private key of the most recent Ed25519 key of the actor,
+// which is used for generating integrity proofs and RFC9421 signatures.
+func (m Metadata) AssertionKey() (vocab.IRI, ed25519.PrivateKey, bool) {
+	for i := len(m.Keys) - 1; i >= 0; i-- {
+		k := m.Keys[i]
+		if k.Type != KeyTypeED25519 || k.Retired() {
+			continue
+		}
+		pair, err := k.KeyPair()
+		if err != nil {
+			continue
+		}
+		if prv, ok := pair.Private.(ed25519.PrivateKey); ok {
+			return k.ID, prv, true
+		}
+	}
+	return "", nil, false
+}
+
+// VerificationKey returns the public key with the keyIRI ID from the additional and the retired keys
+// of the actor, as long as it's not expired.
+func (m Metadata) VerificationKey(keyIRI vocab.IRI) (crypto.PublicKey, error) {
+	now := time.Now().UTC()
+	for _, k := range m.Keys {
+		if k.ID != keyIRI {
+			continue
+		}
+		if k.Expired(now) {
+			return nil, errors.Newf("key %s expired at %s", keyIRI, k.Expires)
+		}
+		return k.Public()
+	}
+	return nil, errors.NotFoundf("key %s not found", keyIRI)
+}
+
+// KeyID returns the IRI of an additional key of the actor, which is derived from the fingerprint of its public key.
+func KeyID(actor vocab.IRI, pub crypto.PublicKey) vocab.IRI {
+	enc, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(enc)
+	return vocab.IRI(fmt.Sprintf("%s#key-%x", actor, sum[:4]))
+}
+
+func keyTypeOf(pub crypto.PublicKey) KeyType {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA
+	case *ecdsa.PublicKey:
+		return KeyTypeECDSA
+	case ed25519.PublicKey:
+		return KeyTypeED25519
+	}
+	return ""
+}
+
+// mainKeyIndex returns the index of the key pair that gets published as the actor's publicKey.
+// We prefer RSA keys, as they are the only ones understood by all the fediverse software.
+func mainKeyIndex(pairs []KeyPair) int {
+	for i, pair := range pairs {
+		if pair.Type == KeyTypeRSA {
+			return i
+		}
+	}
+	return 0
+}
+
+// AddKeyToPerson replaces the keys of the actor with the received key pairs, keeping only the retired keys
+// that haven't expired yet.
+// The first RSA key pair, or in its absence the first key pair, gets published as the actor's publicKey,
+// the rest are stored in the actor's metadata.
+func AddKeyToPerson(metaSaver storage.MetadataStorage, pairs ...KeyPair) func(act *vocab.Actor) error {
 	return func(act *vocab.Actor) error {
 		if !vocab.ActorTypes.Match(act.Type) {
 			return nil
 		}
-
 		m := new(Metadata)
 		_ = metaSaver.LoadMetadata(act.ID, m)
-		pubB, prvB, err := EncodeKeyPair(pair)
-		if err != nil {
-			return err
+
+		// The retired keys that are still inside their overlap window need to stay verifiable.
+		now := time.Now().UTC()
+		retired := make([]KeyMetadata, 0, len(m.Keys))
+		for _, k := range m.Keys {
+			if k.Retired() && !k.Expired(now) {
+				retired = append(retired, k)
+			}
 		}
+		m.Keys = retired
+		return setKeys(metaSaver, act, m, vocab.IRI(fmt.Sprintf("%s#main", act.ID)), pairs)
+	}
+}
 
-		m.PrivateKey = pem.EncodeToMemory(&prvB)
-		if err := metaSaver.SaveMetadata(act.ID, m); err != nil {
-			return errors.Annotatef(err, "failed saving metadata for actor: %s", act.ID)
+// RotateKeys replaces the keys of the actor with the received key pairs, similarly to AddKeyToPerson,
+// but the current keys are kept in the actor's metadata without their private part, and they are still
+// published, and can be used for verifying signatures, until the overlap window passes.
+func RotateKeys(metaSaver storage.MetadataStorage, overlap time.Duration, pairs ...KeyPair) func(act *vocab.Actor) error {
+	return func(act *vocab.Actor) error {
+		if !vocab.ActorTypes.Match(act.Type) {
+			return nil
 		}
-		act.PublicKey.ID = vocab.IRI(fmt.Sprintf("%s#main", act.ID))
-		act.PublicKey.Owner = act.ID
-		act.PublicKey.PublicKeyPem = string(pem.EncodeToMemory(&pubB))
+		if len(pairs) == 0 {
+			return errors.Newf("no keys to add to actor: %s", act.ID)
+		}
+		m := new(Metadata)
+		_ = metaSaver.LoadMetadata(act.ID, m)
 
-		if st, ok := metaSaver.(storage.FullStorage); ok {
-			if _, err := st.Save(act); err != nil {
-				return errors.Annotatef(err, "failed to save actor: %s", act.ID)
+		expires := time.Now().UTC().Truncate(time.Second).Add(overlap)
+		history := make([]KeyMetadata, 0, len(m.Keys)+1)
+		if pub, err := PublicKeyFromPEM(act.PublicKey.PublicKeyPem); err == nil {
+			history = append(history, KeyMetadata{
+				ID:        act.PublicKey.ID,
+				Type:      keyTypeOf(pub),
+				PublicKey: []byte(act.PublicKey.PublicKeyPem),
+				Created:   m.KeyCreated,
+				Expires:   expires,
+			})
+		}
+		for _, k := range m.Keys {
+			if !k.Retired() {
+				pub, err := k.Public()
+				if err != nil {
+					continue
+				}
+				pubEnc, err := x509.MarshalPKIXPublicKey(pub)
+				if err != nil {
+					continue
+				}
+				k.PublicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubEnc})
+				k.PrivateKey = nil
+				k.Expires = expires
 			}
+			history = append(history, k)
 		}
-		return nil
+		m.Keys = history
+
+		// The new main key needs a different ID than the old one, otherwise remote servers
+		// that have it cached can't tell that they need to fetch the actor again.
+		return setKeys(metaSaver, act, m, KeyID(act.ID, pairs[mainKeyIndex(pairs)].Public), pairs)
 	}
 }
 
+// setKeys publishes the main key of the pairs as the actor's publicKey with the mainID IRI,
+// appends the others to the existing keys in the metadata, and then it saves both.
+func setKeys(metaSaver storage.MetadataStorage, act *vocab.Actor, m *Metadata, mainID vocab.IRI, pairs []KeyPair) error {
+	if len(pairs) == 0 {
+		return errors.Newf("no keys to add to actor: %s", act.ID)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	main := mainKeyIndex(pairs)
+	pubB, prvB, err := EncodeKeyPair(pairs[main])
+	if err != nil {
+		return err
+	}
+
+	m.PrivateKey = pem.EncodeToMemory(&prvB)
+	m.KeyCreated = now
+	for i, pair := range pairs {
+		if i == main {
+			continue
+		}
+		_, prv, err := EncodeKeyPair(pair)
+		if err != nil {
+			return err
+		}
+		m.Keys = append(m.Keys, KeyMetadata{
+			ID:         KeyID(act.ID, pair.Public),
+			Type:       pair.Type,
+			PrivateKey: pem.EncodeToMemory(&prv),
+			Created:    now,
+		})
+	}
+	if err := metaSaver.SaveMetadata(act.ID, m); err != nil {
+		return errors.Annotatef(err, "failed saving metadata for actor: %s", act.ID)
+	}
+	act.PublicKey.ID = mainID
+	act.PublicKey.Owner = act.ID
+	act.PublicKey.PublicKeyPem = string(pem.EncodeToMemory(&pubB))
+
+	if st, ok := metaSaver.(storage.FullStorage); ok {
+		if _, err := st.Save(act); err != nil {
+			return errors.Annotatef(err, "failed to save actor: %s", act.ID)
+		}
+	}
+	return nil
+}
+
 func SaveMetadataForItems(metaLoader storage.MetadataStorage, buf []byte) error {
 	metadata := make(map[vocab.IRI]Metadata)
 	err := jsonld.Unmarshal(buf, &metadata)
@@ -137,14 +409,42 @@ func KeyPairFromPrivateBytes(prvBytes []byte) (*KeyPair, error) {
 	return pair, nil
 }
 
-func KeyGenerator(metaSaver storage.MetadataStorage, typ KeyType) func(act *vocab.Actor) error {
+func KeyGenerator(metaSaver storage.MetadataStorage, types ...KeyType) func(act *vocab.Actor) error {
 	return func(act *vocab.Actor) error {
-		pair, err := GenerateKeyPair(typ)
+		pairs, err := GenerateKeyPairs(types...)
 		if err != nil {
 			return err
 		}
-		return AddKeyToPerson(metaSaver, *pair)(act)
+		return AddKeyToPerson(metaSaver, pairs...)(act)
+	}
+}
+
+// WithAssertionKey appends a newly generated Ed25519 key pair to the received ones, if they don't contain one already.
+// We need it for generating FEP-8b32 integrity proofs and for RFC9421 signatures for servers that support them.
+func WithAssertionKey(pairs ...KeyPair) ([]KeyPair, error) {
+	for _, pair := range pairs {
+		if pair.Type == KeyTypeED25519 {
+			return pairs, nil
+		}
+	}
+	pair, err := GenerateKeyPair(KeyTypeED25519)
+	if err != nil {
+		return pairs, err
 	}
+	return append(pairs, *pair), nil
+}
+
+// GenerateKeyPairs generates a key pair for each of the received key types.
+func GenerateKeyPairs(types ...KeyType) ([]KeyPair, error) {
+	pairs := make([]KeyPair, 0, len(types))
+	for _, typ := range types {
+		pair, err := GenerateKeyPair(typ)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, *pair)
+	}
+	return pairs, nil
 }
 
 func GenerateKeyPair(typ KeyType) (*KeyPair, error) {
@@ -195,3 +495,15 @@ func EncodeKeyPair(p KeyPair) (pem.Block, pem.Block, error) {
 	}
 	return pub, prv, nil
 }
+
+// PublicKeyFromPEM decodes the PEM encoded public key of an actor, as found in its publicKey.publicKeyPem property.
+func PublicKeyFromPEM(pubPem string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pubPem))
+	if block == nil {
+		return nil, errors.Newf("unable to decode PEM payload for public key")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
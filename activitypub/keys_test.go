@@ -0,0 +1,227 @@
+package ap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/jsonld"
+	"golang.org/x/crypto/ssh"
+)
+
+type memMetadata map[vocab.IRI][]byte
+
+func (m memMetadata) LoadMetadata(iri vocab.IRI, meta any) error {
+	return jsonld.Unmarshal(m[iri], meta)
+}
+
+func (m memMetadata) SaveMetadata(iri vocab.IRI, meta any) error {
+	raw, err := jsonld.Marshal(meta)
+	m[iri] = raw
+	return err
+}
+
+func TestRotateKeys(t *testing.T) {
+	store := memMetadata{}
+	act := &vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+
+	if err := KeyGenerator(store, KeyTypeRSA, KeyTypeED25519)(act); err != nil {
+		t.Fatalf("KeyGenerator() error = %s", err)
+	}
+	oldMain := act.PublicKey.ID
+	m := new(Metadata)
+	_ = store.LoadMetadata(act.ID, m)
+	oldAssertionID, _, ok := m.AssertionKey()
+	if !ok {
+		t.Fatalf("AssertionKey() no Ed25519 key was generated")
+	}
+	if methods := AssertionMethods(act, m); len(methods) != 1 {
+		t.Errorf("AssertionMethods() count = %d, want 1", len(methods))
+	}
+
+	pairs, _ := GenerateKeyPairs(KeyTypeRSA, KeyTypeED25519)
+	if err := RotateKeys(store, time.Hour, pairs...)(act); err != nil {
+		t.Fatalf("RotateKeys() error = %s", err)
+	}
+	if act.PublicKey.ID == oldMain {
+		t.Errorf("RotateKeys() main key ID was not changed: %s", act.PublicKey.ID)
+	}
+
+	m = new(Metadata)
+	_ = store.LoadMetadata(act.ID, m)
+	newAssertionID, _, _ := m.AssertionKey()
+	if newAssertionID == oldAssertionID {
+		t.Errorf("AssertionKey() = %s, expected the new key", newAssertionID)
+	}
+	for _, keyIRI := range []vocab.IRI{oldMain, oldAssertionID} {
+		if _, err := m.VerificationKey(keyIRI); err != nil {
+			t.Errorf("VerificationKey(%s) error = %s, expected retired key to be verifiable", keyIRI, err)
+		}
+	}
+	for _, k := range m.Keys {
+		if k.Retired() && len(k.PrivateKey) > 0 {
+			t.Errorf("retired key %s still holds its private key", k.ID)
+		}
+	}
+	methods := AssertionMethods(act, m)
+	if len(methods) != 3 {
+		t.Errorf("AssertionMethods() count = %d, want 3", len(methods))
+	}
+	published := false
+	for _, method := range methods {
+		if method.ID != oldMain {
+			continue
+		}
+		pub, err := DecodeMultibaseKey(method.PublicKeyMultibase)
+		if err != nil || keyTypeOf(pub) != KeyTypeRSA {
+			t.Errorf("DecodeMultibaseKey(%s) = %T, %v, expected the retired RSA key", method.ID, pub, err)
+		}
+		published = true
+	}
+	if !published {
+		t.Errorf("AssertionMethods() the retired main key %s is not published", oldMain)
+	}
+
+	if err := RotateKeys(store, -time.Hour, pairs...)(act); err != nil {
+		t.Fatalf("RotateKeys() error = %s", err)
+	}
+	m = new(Metadata)
+	_ = store.LoadMetadata(act.ID, m)
+	if _, err := m.VerificationKey(newAssertionID); err == nil {
+		t.Errorf("VerificationKey(%s) expected expired key to fail", newAssertionID)
+	}
+}
+
+func TestAddKeyToPerson_keepsRetiredKeys(t *testing.T) {
+	store := memMetadata{}
+	act := &vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+
+	pairs, _ := GenerateKeyPairs(KeyTypeRSA)
+	if err := AddKeyToPerson(store, pairs...)(act); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+	retired := act.PublicKey.ID
+	pairs, _ = GenerateKeyPairs(KeyTypeRSA)
+	if err := RotateKeys(store, time.Hour, pairs...)(act); err != nil {
+		t.Fatalf("RotateKeys() error = %s", err)
+	}
+	pairs, _ = GenerateKeyPairs(KeyTypeRSA, KeyTypeED25519)
+	if err := AddKeyToPerson(store, pairs...)(act); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+
+	m := new(Metadata)
+	_ = store.LoadMetadata(act.ID, m)
+	if _, err := m.VerificationKey(retired); err != nil {
+		t.Errorf("VerificationKey(%s) error = %s, expected the retired key to be kept", retired, err)
+	}
+	active := 0
+	for _, k := range m.Keys {
+		if !k.Retired() {
+			active++
+		}
+	}
+	if active != 1 {
+		t.Errorf("AddKeyToPerson() active additional keys = %d, want 1", active)
+	}
+}
+
+func TestSaveHostKeys(t *testing.T) {
+	store := memMetadata{}
+	service := vocab.IRI("https://example.com")
+
+	pairs, _ := GenerateKeyPairs(HostKeyTypes...)
+	if err := SaveHostKeys(store, service, pairs...); err != nil {
+		t.Fatalf("SaveHostKeys() error = %s", err)
+	}
+	ed, _ := GenerateKeyPair(KeyTypeED25519)
+	if err := SaveHostKeys(store, service, *ed); err != nil {
+		t.Fatalf("SaveHostKeys() error = %s", err)
+	}
+
+	loaded, err := LoadHostKeys(store, service)
+	if err != nil {
+		t.Fatalf("LoadHostKeys() error = %s", err)
+	}
+	if len(loaded) != len(HostKeyTypes) {
+		t.Fatalf("LoadHostKeys() count = %d, want %d", len(loaded), len(HostKeyTypes))
+	}
+	for _, pair := range loaded {
+		want := pairs[1].Public
+		if pair.Type == KeyTypeED25519 {
+			want = ed.Public
+		}
+		if Fingerprint(pair.Public) != Fingerprint(want) {
+			t.Errorf("LoadHostKeys() %s key = %s, want %s", pair.Type, Fingerprint(pair.Public), Fingerprint(want))
+		}
+	}
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	store := memMetadata{}
+	actor := vocab.IRI("https://example.com/actors/jdoe")
+
+	pair, _ := GenerateKeyPair(KeyTypeED25519)
+	pub, _ := ssh.NewPublicKey(pair.Public)
+	line := append(bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub)), []byte(" jdoe@laptop")...)
+
+	key, err := AddAuthorizedKey(store, actor, line, "", time.Time{})
+	if err != nil {
+		t.Fatalf("AddAuthorizedKey() error = %s", err)
+	}
+	if key.Comment != "jdoe@laptop" {
+		t.Errorf("AddAuthorizedKey() comment = %q, want %q", key.Comment, "jdoe@laptop")
+	}
+	if _, err = AddAuthorizedKey(store, actor, line, "", time.Time{}); err == nil {
+		t.Errorf("AddAuthorizedKey() with a duplicate key should have failed")
+	}
+
+	other, _ := GenerateKeyPair(KeyTypeED25519)
+	otherPub, _ := ssh.NewPublicKey(other.Public)
+	if _, err = AddAuthorizedKey(store, actor, ssh.MarshalAuthorizedKey(otherPub), "expired", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("AddAuthorizedKey() error = %s", err)
+	}
+
+	m := new(Metadata)
+	_ = store.LoadMetadata(actor, m)
+	if !m.AuthorizedKeys.Authorizes(pub) {
+		t.Errorf("Authorizes() = false for registered key")
+	}
+	if m.AuthorizedKeys.Authorizes(otherPub) {
+		t.Errorf("Authorizes() = true for expired key")
+	}
+
+	if err = RemoveAuthorizedKey(store, actor, key.Fingerprint()); err != nil {
+		t.Fatalf("RemoveAuthorizedKey() error = %s", err)
+	}
+	m = new(Metadata)
+	_ = store.LoadMetadata(actor, m)
+	if m.AuthorizedKeys.Authorizes(pub) {
+		t.Errorf("Authorizes() = true for removed key")
+	}
+	if err = RemoveAuthorizedKey(store, actor, key.Fingerprint()); err == nil {
+		t.Errorf("RemoveAuthorizedKey() for a missing key should have failed")
+	}
+}
+
+func TestMetadata_IsSuspended(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name string
+		m    Metadata
+		want bool
+	}{
+		{"active", Metadata{}, false},
+		{"permanent", Metadata{Suspended: now.Add(-time.Hour)}, true},
+		{"temporary", Metadata{Suspended: now.Add(-time.Hour), SuspendedUntil: now.Add(time.Hour)}, true},
+		{"expired", Metadata{Suspended: now.Add(-2 * time.Hour), SuspendedUntil: now.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.IsSuspended(now); got != tt.want {
+				t.Errorf("IsSuspended() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package ap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const (
+	MultikeyType    = "Multikey"
+	MultikeyContext = vocab.IRI("https://w3id.org/security/multikey/v1")
+)
+
+var (
+	// ed25519PubMulticodec is the varint encoded "ed25519-pub" multicodec prefix.
+	ed25519PubMulticodec = []byte{0xed, 0x01}
+	// rsaPubMulticodec is the varint encoded "rsa-pub" multicodec prefix, which is followed by
+	// the PKCS#1 DER encoding of the key.
+	rsaPubMulticodec = []byte{0x85, 0x24}
+)
+
+// Multikey represents a FEP-521a verification method, as published in the assertionMethod property of an actor.
+//
+// https://codeberg.org/fediverse/fep/src/branch/main/fep/521a/fep-521a.md
+type Multikey struct {
+	ID                 vocab.IRI `json:"id"`
+	Type               string    `json:"type"`
+	Controller         vocab.IRI `json:"controller"`
+	PublicKeyMultibase string    `json:"publicKeyMultibase"`
+}
+
+// EncodeMultibaseKey encodes a public key in the base58-btc multibase format used by Multikey verification methods.
+// Only Ed25519 and RSA keys are supported.
+func EncodeMultibaseKey(pub crypto.PublicKey) (string, error) {
+	var raw []byte
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		raw = append(append(raw, ed25519PubMulticodec...), k...)
+	case *rsa.PublicKey:
+		raw = append(append(raw, rsaPubMulticodec...), x509.MarshalPKCS1PublicKey(k)...)
+	default:
+		return "", errors.Newf("unsupported public key type %T for Multikey", pub)
+	}
+	return "z" + base58Encode(raw), nil
+}
+
+// DecodeMultibaseKey decodes the publicKeyMultibase value of a Multikey verification method.
+func DecodeMultibaseKey(s string) (crypto.PublicKey, error) {
+	if len(s) == 0 || s[0] != 'z' {
+		return nil, errors.Newf("unsupported multibase encoding for public key")
+	}
+	raw, err := base58Decode(s[1:])
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(raw, ed25519PubMulticodec) && len(raw) == len(ed25519PubMulticodec)+ed25519.PublicKeySize:
+		return ed25519.PublicKey(raw[len(ed25519PubMulticodec):]), nil
+	case bytes.HasPrefix(raw, rsaPubMulticodec):
+		return x509.ParsePKCS1PublicKey(raw[len(rsaPubMulticodec):])
+	}
+	return nil, errors.Newf("unsupported multicodec for public key")
+}
+
+// AssertionMethods returns the Multikey verification methods of the actor: its main key, if it's an Ed25519 one,
+// and all the additional keys stored in its metadata, including the retired ones that haven't expired yet.
+// The retired keys are the only place where other servers can still find a rotated RSA main key
+// during the overlap window, as the actor's publicKey can hold only the current one.
+func AssertionMethods(act *vocab.Actor, m *Metadata) []Multikey {
+	if act == nil {
+		return nil
+	}
+	methods := make([]Multikey, 0)
+	// An RSA main key is already published as the actor's publicKey
+	if pub, err := PublicKeyFromPEM(act.PublicKey.PublicKeyPem); err == nil && keyTypeOf(pub) == KeyTypeED25519 {
+		if enc, err := EncodeMultibaseKey(pub); err == nil {
+			methods = append(methods, Multikey{ID: act.PublicKey.ID, Type: MultikeyType, Controller: act.ID, PublicKeyMultibase: enc})
+		}
+	}
+	if m == nil {
+		return methods
+	}
+	now := time.Now().UTC()
+	for _, k := range m.Keys {
+		if k.Expired(now) {
+			continue
+		}
+		pub, err := k.Public()
+		if err != nil {
+			continue
+		}
+		enc, err := EncodeMultibaseKey(pub)
+		if err != nil {
+			continue
+		}
+		methods = append(methods, Multikey{ID: k.ID, Type: MultikeyType, Controller: act.ID, PublicKeyMultibase: enc})
+	}
+	return methods
+}
+
+// FindAssertionMethod looks for the keyIRI verification method in the assertionMethod property
+// of the raw JSON document of an actor, and returns the public key it represents.
+func FindAssertionMethod(doc []byte, keyIRI vocab.IRI) (crypto.PublicKey, error) {
+	raw := struct {
+		AssertionMethod json.RawMessage `json:"assertionMethod"`
+	}{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, err
+	}
+
+	methods := make([]Multikey, 0)
+	if err := json.Unmarshal(raw.AssertionMethod, &methods); err != nil {
+		// The property can also contain a single object
+		m := Multikey{}
+		if err := json.Unmarshal(raw.AssertionMethod, &m); err != nil {
+			return nil, errors.NotFoundf("verification method %s not found", keyIRI)
+		}
+		methods = append(methods, m)
+	}
+	for _, m := range methods {
+		if m.Type == MultikeyType && m.ID == keyIRI {
+			return DecodeMultibaseKey(m.PublicKeyMultibase)
+		}
+	}
+	return nil, errors.NotFoundf("verification method %s not found", keyIRI)
+}
+
+// AddAssertionMethods sets the assertionMethod property of the actor JSON document to the received methods.
+func AddAssertionMethods(doc []byte, methods []Multikey) ([]byte, error) {
+	document, err := DecodeActorDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	document.SetAssertionMethods(methods)
+	return document.Encode()
+}
+
+// SetAssertionMethods sets the assertionMethod property of the actor to the received methods.
+func (d ActorDocument) SetAssertionMethods(methods []Multikey) {
+	d["@context"] = appendContext(d["@context"], MultikeyContext)
+	d["assertionMethod"] = methods
+}
@@ -0,0 +1,423 @@
+package ap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const (
+	ProofTypeDataIntegrity  = "DataIntegrityProof"
+	CryptosuiteEdDSAJCS2022 = "eddsa-jcs-2022"
+	ProofPurposeAssertion   = "assertionMethod"
+
+	DataIntegrityContext = vocab.IRI("https://w3id.org/security/data-integrity/v1")
+)
+
+var (
+	ErrMissingProof     = errors.Newf("document does not contain an integrity proof")
+	ErrExistingProof    = errors.Newf("document already contains an integrity proof")
+	ErrUnsupportedProof = errors.Newf("document does not contain a supported integrity proof")
+)
+
+// UnverifiableProof returns true if the error returned by VerifyProof means that we are not able to verify the proof,
+// because we don't support its type, or we can't load its verification method, and not that the proof is invalid.
+func UnverifiableProof(err error) bool {
+	return err == ErrMissingProof || err == ErrUnsupportedProof || errors.IsNotFound(err)
+}
+
+// Proof represents a FEP-8b32 Data Integrity proof using the eddsa-jcs-2022 cryptosuite.
+//
+// https://codeberg.org/fediverse/fep/src/branch/main/fep/8b32/fep-8b32.md
+type Proof struct {
+	Context            any       `json:"@context,omitempty"`
+	Type               string    `json:"type"`
+	Cryptosuite        string    `json:"cryptosuite"`
+	VerificationMethod vocab.IRI `json:"verificationMethod"`
+	ProofPurpose       string    `json:"proofPurpose"`
+	Created            string    `json:"created,omitempty"`
+	ProofValue         string    `json:"proofValue,omitempty"`
+}
+
+// AddProof signs the raw JSON document "doc" with the Ed25519 "key", and returns the document with the resulting
+// integrity proof appended in its "proof" property.
+// The "verificationMethod" needs to be an IRI that can be dereferenced to the public key corresponding to "key".
+func AddProof(doc []byte, key ed25519.PrivateKey, verificationMethod vocab.IRI) ([]byte, error) {
+	document, err := decodeDocument(doc)
+	if err != nil {
+		return doc, err
+	}
+	if _, ok := document["proof"]; ok {
+		return doc, ErrExistingProof
+	}
+	if ctx, ok := document["@context"]; ok {
+		document["@context"] = appendContext(ctx, DataIntegrityContext)
+	}
+
+	p := Proof{
+		Type:               ProofTypeDataIntegrity,
+		Cryptosuite:        CryptosuiteEdDSAJCS2022,
+		VerificationMethod: verificationMethod,
+		ProofPurpose:       ProofPurposeAssertion,
+		Created:            time.Now().UTC().Truncate(time.Second).Format(time.RFC3339),
+	}
+	hash, err := proofHash(document, p)
+	if err != nil {
+		return doc, err
+	}
+	p.ProofValue = "z" + base58Encode(ed25519.Sign(key, hash))
+
+	document["proof"] = p
+	return encodeDocument(document)
+}
+
+// VerifyProof validates the eddsa-jcs-2022 integrity proof contained in the raw JSON document "doc".
+// The keyFn function is used to dereference the proof's verification method to a public key.
+//
+// If the document does not contain a proof, it returns ErrMissingProof.
+func VerifyProof(doc []byte, keyFn func(vocab.IRI) (crypto.PublicKey, error)) (*Proof, error) {
+	document, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := extractProof(document)
+	if err != nil {
+		return nil, err
+	}
+	if p.Type != ProofTypeDataIntegrity || p.Cryptosuite != CryptosuiteEdDSAJCS2022 {
+		return p, ErrUnsupportedProof
+	}
+	if p.ProofPurpose != ProofPurposeAssertion {
+		return p, errors.Newf("invalid proof purpose %s", p.ProofPurpose)
+	}
+	if len(p.ProofValue) == 0 || p.ProofValue[0] != 'z' {
+		return p, errors.Newf("invalid proof value encoding")
+	}
+	sig, err := base58Decode(p.ProofValue[1:])
+	if err != nil {
+		return p, errors.Annotatef(err, "invalid proof value")
+	}
+
+	if keyFn == nil {
+		return p, errors.Newf("unable to load verification method %s", p.VerificationMethod)
+	}
+	maybeKey, err := keyFn(p.VerificationMethod)
+	if err != nil {
+		return p, errors.NewNotFound(err, "unable to load verification method %s", p.VerificationMethod)
+	}
+	pub, ok := maybeKey.(ed25519.PublicKey)
+	if !ok {
+		return p, errors.Newf("invalid verification method key type %T", maybeKey)
+	}
+
+	delete(document, "proof")
+	value := p.ProofValue
+	p.ProofValue = ""
+	hash, err := proofHash(document, *p)
+	p.ProofValue = value
+	if err != nil {
+		return p, err
+	}
+	if !ed25519.Verify(pub, hash, sig) {
+		return p, errors.Newf("integrity proof verification failed")
+	}
+	return p, nil
+}
+
+// Controller returns the IRI of the actor that controls the proof's verification method.
+func (p Proof) Controller() vocab.IRI {
+	return KeyControllerIRI(p.VerificationMethod)
+}
+
+// KeyControllerIRI returns the IRI of the document that contains the key identified by keyIRI,
+// which for keys using a fragment identifier is usually the actor that owns it.
+// Keys that are documents of their own need to be dereferenced, and their controller
+// loaded with KeyController.
+func KeyControllerIRI(keyIRI vocab.IRI) vocab.IRI {
+	u, err := keyIRI.URL()
+	if err != nil {
+		return keyIRI
+	}
+	u.Fragment = ""
+	return vocab.IRI(u.String())
+}
+
+// KeyController returns the controller of the key from its JSON document: the "controller" property
+// for Multikey verification methods, or the "owner" property for the publicKey ones.
+func KeyController(doc []byte) (vocab.IRI, error) {
+	raw := struct {
+		Controller vocab.IRI `json:"controller"`
+		Owner      vocab.IRI `json:"owner"`
+	}{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return "", errors.Annotatef(err, "invalid key document")
+	}
+	if raw.Controller != "" {
+		return raw.Controller, nil
+	}
+	if raw.Owner != "" {
+		return raw.Owner, nil
+	}
+	return "", errors.NotFoundf("the key document doesn't have a controller")
+}
+
+func proofHash(document map[string]any, p Proof) ([]byte, error) {
+	p.Context = nil
+	if ctx, ok := document["@context"]; ok {
+		p.Context = ctx
+	}
+	rawConfig, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeDocument(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+	canonicalConfig, err := CanonicalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	canonicalDoc, err := CanonicalJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	configHash := sha256.Sum256(canonicalConfig)
+	docHash := sha256.Sum256(canonicalDoc)
+	return append(configHash[:], docHash[:]...), nil
+}
+
+func extractProof(document map[string]any) (*Proof, error) {
+	maybeProof, ok := document["proof"]
+	if !ok || maybeProof == nil {
+		return nil, ErrMissingProof
+	}
+	proofs, ok := maybeProof.([]any)
+	if !ok {
+		proofs = []any{maybeProof}
+	}
+	for _, raw := range proofs {
+		buf, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+		p := new(Proof)
+		if err = json.Unmarshal(buf, p); err != nil {
+			continue
+		}
+		// When the document contains a proof set, we verify only the first one that
+		// we know how to handle.
+		if p.Cryptosuite == CryptosuiteEdDSAJCS2022 {
+			return p, nil
+		}
+	}
+	return nil, ErrUnsupportedProof
+}
+
+func appendContext(ctx any, iri vocab.IRI) any {
+	switch c := ctx.(type) {
+	case string:
+		if c == iri.String() {
+			return c
+		}
+		return []any{c, iri.String()}
+	case []any:
+		if slices.Contains(c, any(iri.String())) {
+			return c
+		}
+		return append(c, iri.String())
+	}
+	return ctx
+}
+
+func decodeDocument(doc []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&document); err != nil {
+		return nil, errors.Annotatef(err, "unable to decode JSON document")
+	}
+	return document, nil
+}
+
+func encodeDocument(document map[string]any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(document); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// CanonicalJSON serializes the decoded JSON value "v" using the JSON Canonicalization Scheme.
+//
+// https://www.rfc-editor.org/rfc/rfc8785
+func CanonicalJSON(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return err
+		}
+		s, err := canonicalNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case float64:
+		s, err := canonicalNumber(val)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case string:
+		writeCanonicalString(buf, val)
+	case []any:
+		buf.WriteByte('[')
+		for i, el := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		// JCS requires the properties to be sorted by their UTF-16 code units
+		slices.SortFunc(keys, func(a, b string) int {
+			return slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, val[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return errors.Newf("unsupported JSON value %T", v)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xF])
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats a number the same way the ECMAScript Number.prototype.toString() does.
+func canonicalNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", errors.Newf("invalid JSON number %v", f)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := math.Abs(f); abs >= 1e21 || abs < 1e-6 {
+		mantissa, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+		return mantissa + "e" + exp[:1] + strings.TrimLeft(exp[1:], "0"), nil
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	out := make([]byte, 0, len(b)*138/100+1)
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	slices.Reverse(out)
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range []byte(s) {
+		idx := strings.IndexByte(base58Alphabet, c)
+		if idx < 0 {
+			return nil, errors.Newf("invalid base58 character %q", c)
+		}
+		x.Mul(x, base)
+		x.Add(x, big.NewInt(int64(idx)))
+	}
+	leading := 0
+	for leading < len(s) && s[leading] == base58Alphabet[0] {
+		leading++
+	}
+	return append(make([]byte, leading), x.Bytes()...), nil
+}
@@ -0,0 +1,165 @@
+package ap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "sorted keys",
+			in:   `{"b": 1, "a": "x", "c": [true, null]}`,
+			want: `{"a":"x","b":1,"c":[true,null]}`,
+		},
+		{
+			name: "numbers",
+			in:   `{"a": 1.0, "b": 1e21, "c": 0.0000001, "d": -0.5}`,
+			want: `{"a":1,"b":1e+21,"c":1e-7,"d":-0.5}`,
+		},
+		{
+			name: "strings",
+			in:   `{"a": "<é>\n\u001f"}`,
+			want: "{\"a\":\"<é>\\n\\u001f\"}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeDocument([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("unable to decode test document: %s", err)
+			}
+			got, err := CanonicalJSON(doc)
+			if err != nil {
+				t.Fatalf("CanonicalJSON() error = %s", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("CanonicalJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddProof(t *testing.T) {
+	pub, prv, _ := ed25519.GenerateKey(rand.Reader)
+	keyIRI := vocab.IRI("https://example.com/actors/jdoe#main")
+	keyFn := func(iri vocab.IRI) (crypto.PublicKey, error) {
+		return pub, nil
+	}
+
+	doc := []byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Create","actor":"https://example.com/actors/jdoe","object":{"type":"Note","content":"<p>Hello</p>"}}`)
+	signed, err := AddProof(doc, prv, keyIRI)
+	if err != nil {
+		t.Fatalf("AddProof() error = %s", err)
+	}
+
+	p, err := VerifyProof(signed, keyFn)
+	if err != nil {
+		t.Fatalf("VerifyProof() error = %s", err)
+	}
+	if !p.VerificationMethod.Equal(keyIRI) {
+		t.Errorf("VerifyProof() verification method = %s, want %s", p.VerificationMethod, keyIRI)
+	}
+	if !p.Controller().Equal("https://example.com/actors/jdoe") {
+		t.Errorf("Controller() = %s, want %s", p.Controller(), "https://example.com/actors/jdoe")
+	}
+
+	if _, err = AddProof(signed, prv, keyIRI); err != ErrExistingProof {
+		t.Errorf("AddProof() on a signed document error = %v, want %s", err, ErrExistingProof)
+	}
+	if _, err = VerifyProof(doc, keyFn); err != ErrMissingProof {
+		t.Errorf("VerifyProof() on unsigned document error = %v, want %s", err, ErrMissingProof)
+	}
+
+	tampered := bytes.Replace(signed, []byte("Hello"), []byte("Goodbye"), 1)
+	if _, err = VerifyProof(tampered, keyFn); err == nil || UnverifiableProof(err) {
+		t.Errorf("VerifyProof() on tampered document should have failed, got %v", err)
+	}
+
+	missingKey := func(iri vocab.IRI) (crypto.PublicKey, error) {
+		return nil, errors.Newf("unable to load %s", iri)
+	}
+	if _, err = VerifyProof(signed, missingKey); !UnverifiableProof(err) {
+		t.Errorf("VerifyProof() with a missing key error = %v, should be unverifiable", err)
+	}
+	foreign := []byte(`{"type":"Create","actor":"https://example.com/actors/jdoe","proof":{"type":"DataIntegrityProof","cryptosuite":"ecdsa-rdfc-2019","proofValue":"z1"}}`)
+	if _, err = VerifyProof(foreign, keyFn); err != ErrUnsupportedProof {
+		t.Errorf("VerifyProof() with an unsupported proof error = %v, want %s", err, ErrUnsupportedProof)
+	}
+}
+
+func TestKeyController(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want vocab.IRI
+	}{
+		{"multikey", `{"id":"https://example.com/actors/jdoe/keys/1","type":"Multikey","controller":"https://example.com/actors/jdoe"}`, "https://example.com/actors/jdoe"},
+		{"public key", `{"id":"https://example.com/actors/jdoe/keys/1","owner":"https://example.com/actors/jdoe"}`, "https://example.com/actors/jdoe"},
+		{"missing", `{"id":"https://example.com/actors/jdoe/keys/1"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := KeyController([]byte(tt.doc))
+			if (err != nil) != (tt.want == "") {
+				t.Fatalf("KeyController() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("KeyController() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBase58(t *testing.T) {
+	for _, in := range [][]byte{{}, {0, 0, 1}, []byte("hello world"), {0xff, 0xfe}} {
+		dec, err := base58Decode(base58Encode(in))
+		if err != nil {
+			t.Errorf("base58Decode() error = %s", err)
+		}
+		if !bytes.Equal(dec, in) {
+			t.Errorf("base58 round trip = %v, want %v", dec, in)
+		}
+	}
+	if got := base58Encode([]byte("hello world")); got != "StV1DL6CwTryKyV" {
+		t.Errorf("base58Encode() = %s, want %s", got, "StV1DL6CwTryKyV")
+	}
+}
+
+func TestMultibaseKey(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	enc, err := EncodeMultibaseKey(pub)
+	if err != nil {
+		t.Fatalf("EncodeMultibaseKey() error = %s", err)
+	}
+	if enc[:4] != "z6Mk" {
+		t.Errorf("EncodeMultibaseKey() = %s, expected z6Mk prefix", enc)
+	}
+
+	keyIRI := vocab.IRI("https://example.com/actors/jdoe#key-1")
+	doc, err := AddAssertionMethods([]byte(`{"@context":"https://www.w3.org/ns/activitystreams","id":"https://example.com/actors/jdoe","type":"Person"}`),
+		[]Multikey{{ID: keyIRI, Type: MultikeyType, Controller: "https://example.com/actors/jdoe", PublicKeyMultibase: enc}})
+	if err != nil {
+		t.Fatalf("AddAssertionMethods() error = %s", err)
+	}
+	got, err := FindAssertionMethod(doc, keyIRI)
+	if err != nil {
+		t.Fatalf("FindAssertionMethod() error = %s", err)
+	}
+	if !pub.Equal(got) {
+		t.Errorf("FindAssertionMethod() = %v, want %v", got, pub)
+	}
+	if _, err = FindAssertionMethod(doc, "https://example.com/actors/jdoe#main"); err == nil {
+		t.Errorf("FindAssertionMethod() for missing key should have failed")
+	}
+}
@@ -0,0 +1,121 @@
+package ap
+
+import (
+	"reflect"
+	"slices"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// ManuallyApprovesFollowersTerm is the JSON-LD definition of the manuallyApprovesFollowers property,
+// which is part of the ActivityStreams namespace, but missing from its context document.
+var ManuallyApprovesFollowersTerm = map[string]any{"manuallyApprovesFollowers": "as:manuallyApprovesFollowers"}
+
+// AlsoKnownAsTerm and MovedToTerm are the JSON-LD definitions of the alsoKnownAs and movedTo properties,
+// which are used by the other servers for verifying that an actor has moved.
+var (
+	AlsoKnownAsTerm = map[string]any{"alsoKnownAs": map[string]any{"@id": "as:alsoKnownAs", "@type": "@id"}}
+	MovedToTerm     = map[string]any{"movedTo": map[string]any{"@id": "as:movedTo", "@type": "@id"}}
+)
+
+// ActorDocument is the decoded JSON document of an actor, to which we add the properties that
+// the vocab.Actor type doesn't have a place for.
+type ActorDocument map[string]any
+
+// DecodeActorDocument decodes the raw JSON document, so we can add properties to it.
+func DecodeActorDocument(doc []byte) (ActorDocument, error) {
+	document, err := decodeDocument(doc)
+	return ActorDocument(document), err
+}
+
+// Actor returns an actor with the ID, type and public key from the document, which is all we need for
+// deciding which properties to add, or nil if the document doesn't represent an actor.
+func (d ActorDocument) Actor() *vocab.Actor {
+	typ, _ := d["type"].(string)
+	id, _ := d["id"].(string)
+	if id == "" || !vocab.ActorTypes.Match(vocab.ActivityVocabularyType(typ)) {
+		return nil
+	}
+	act := &vocab.Actor{ID: vocab.IRI(id), Type: vocab.ActivityVocabularyType(typ)}
+	if key, ok := d["publicKey"].(map[string]any); ok {
+		keyID, _ := key["id"].(string)
+		owner, _ := key["owner"].(string)
+		pem, _ := key["publicKeyPem"].(string)
+		act.PublicKey = vocab.PublicKey{ID: vocab.IRI(keyID), Owner: vocab.IRI(owner), PublicKeyPem: pem}
+	}
+	return act
+}
+
+// EditActivityObject decodes the raw JSON document of an activity, and calls fn with the document of its object,
+// if it has been embedded. It returns the encoded activity, with the changes that fn made to its object.
+func EditActivityObject(doc []byte, fn func(ActorDocument) error) ([]byte, error) {
+	document, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := document["object"].(map[string]any)
+	if !ok {
+		return nil, errors.Newf("the activity doesn't have an embedded object")
+	}
+	if err = fn(object); err != nil {
+		return nil, err
+	}
+	return encodeDocument(document)
+}
+
+// Encode returns the raw JSON document.
+func (d ActorDocument) Encode() ([]byte, error) {
+	return encodeDocument(d)
+}
+
+// SetManuallyApprovesFollowers sets the manuallyApprovesFollowers property of the actor.
+func (d ActorDocument) SetManuallyApprovesFollowers(value bool) {
+	d["@context"] = appendContextTerm(d["@context"], ManuallyApprovesFollowersTerm)
+	d["manuallyApprovesFollowers"] = value
+}
+
+// SetAlsoKnownAs sets the alsoKnownAs property of the actor to the received IRIs.
+func (d ActorDocument) SetAlsoKnownAs(iris vocab.IRIs) {
+	aka := make([]any, 0, len(iris))
+	for _, iri := range iris {
+		aka = append(aka, iri.String())
+	}
+	d["@context"] = appendContextTerm(d["@context"], AlsoKnownAsTerm)
+	d["alsoKnownAs"] = aka
+}
+
+// SetMovedTo sets the movedTo property of the actor to the received IRI.
+func (d ActorDocument) SetMovedTo(iri vocab.IRI) {
+	d["@context"] = appendContextTerm(d["@context"], MovedToTerm)
+	d["movedTo"] = iri.String()
+}
+
+// AddManuallyApprovesFollowers sets the manuallyApprovesFollowers property of the actor JSON document.
+func AddManuallyApprovesFollowers(doc []byte, value bool) ([]byte, error) {
+	document, err := DecodeActorDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	document.SetManuallyApprovesFollowers(value)
+	return document.Encode()
+}
+
+func appendContextTerm(ctx any, term map[string]any) any {
+	isTerm := func(v any) bool {
+		return reflect.DeepEqual(v, any(term))
+	}
+	switch c := ctx.(type) {
+	case nil:
+		return term
+	case []any:
+		if slices.ContainsFunc(c, isTerm) {
+			return c
+		}
+		return append(c, term)
+	}
+	if isTerm(ctx) {
+		return ctx
+	}
+	return []any{ctx, term}
+}
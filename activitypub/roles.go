@@ -0,0 +1,32 @@
+package ap
+
+import (
+	"slices"
+
+	"github.com/go-ap/errors"
+)
+
+// Role represents the level of access an actor has for the administrative commands.
+type Role string
+
+const (
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleUser      Role = "user"
+)
+
+// Roles contains the valid roles, ordered from the least to the most privileged.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+// ValidRole checks if r is one of the known roles.
+func ValidRole(r Role) error {
+	if !slices.Contains(Roles, r) {
+		return errors.Newf("invalid role %q, expected one of %v", r, Roles)
+	}
+	return nil
+}
+
+// Includes returns true if the r role has at least the privileges of the other role.
+func (r Role) Includes(other Role) bool {
+	return slices.Index(Roles, r) >= slices.Index(Roles, other)
+}
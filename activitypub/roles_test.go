@@ -0,0 +1,23 @@
+package ap
+
+import "testing"
+
+func TestRole_Includes(t *testing.T) {
+	tests := []struct {
+		role  Role
+		other Role
+		want  bool
+	}{
+		{RoleAdmin, RoleUser, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{Role("root"), RoleUser, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.other); got != tt.want {
+			t.Errorf("%s.Includes(%s) = %t, want %t", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package fedbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+
+	"git.sr.ht/~mariusor/lw"
+	m "git.sr.ht/~mariusor/servermux"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-chi/chi/v5"
+)
+
+// initAdminServer initializes the server for the JSON admin API, which listens only on the internal socket.
+// The access to the API is controlled by the file permissions of the socket,
+// and all the operations are executed with the privileges of the service actor.
+func initAdminServer(app *FedBOX) (m.Server, error) {
+	if app.Conf.Env.IsTest() {
+		return nil, nil
+	}
+	r := chi.NewRouter()
+	r.Group(app.AdminRoutes())
+
+	srv, err := adminSocketServer(app.Conf.InternalSocketPath(), r)
+	if err != nil {
+		return nil, err
+	}
+	app.Logger.WithContext(lw.Ctx{"socket": app.Conf.InternalSocketPath()}).Debugf("Accepting admin API requests")
+	return srv, nil
+}
+
+// adminSocketMode is the file mode of the admin API socket, which allows connections only from the user
+// running the server.
+const adminSocketMode os.FileMode = 0o600
+
+// adminSocketServer creates the server listening on the socket at path, which is accessible only
+// to the owner of the process.
+func adminSocketServer(path string, h http.Handler) (m.Server, error) {
+	_ = os.RemoveAll(path)
+
+	// The socket gets created with the permissions allowed by the umask of the process,
+	// so we restrict it while listening, to not leave it open to others until we change its mode.
+	oldMask := syscall.Umask(0o177)
+	srv, err := m.HttpServer(m.Handler(h), m.OnSocket(path))
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, adminSocketMode); err != nil {
+		return nil, errors.Annotatef(err, "unable to change the permissions of the admin socket %s", path)
+	}
+	return srv, nil
+}
+
+func (f *FedBOX) AdminRoutes() func(chi.Router) {
+	return func(r chi.Router) {
+		r.Use(lw.Middlewares(f.Logger)...)
+
+		r.Get("/status", f.adminStatus)
+		r.Post("/maintenance", f.adminMaintenance)
+		r.Post("/read-only", f.adminReadOnly)
+		r.Post("/debug", f.adminDebug)
+		r.Post("/reload", f.adminReload)
+		r.Post("/stop", f.adminStop)
+
+		r.Get("/actors", f.adminListActors)
+		r.Get("/objects", f.adminLoadObject)
+		r.With(f.refuseWhenReadOnly).Delete("/objects", f.adminDeleteObjects)
+		r.Get("/oauth/clients", f.adminListClients)
+		r.With(f.refuseWhenReadOnly).Delete("/oauth/clients/{id}", f.adminDeleteClient)
+		r.With(f.refuseWhenReadOnly).Post("/oauth/tokens", f.adminAddToken)
+
+		// The rest of the SSH command tree is available through the generic command endpoint
+		r.Post("/command", f.adminCommand)
+
+		r.NotFound(errors.NotFound.ServeHTTP)
+		r.MethodNotAllowed(errors.HandleError(errors.MethodNotAllowedf("method not allowed")).ServeHTTP)
+	}
+}
+
+// refuseWhenReadOnly stops the requests that modify the storage while the server is in read-only mode.
+func (f *FedBOX) refuseWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.readOnlyMode.Load() {
+			errors.HandleError(errors.Conflictf("server is in read-only mode")).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(raw)
+}
+
+func writeItem(w http.ResponseWriter, it vocab.Item) {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/activity+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(raw)
+}
+
+func (f *FedBOX) adminStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+func (f *FedBOX) adminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if _, err := f.toggleMaintenance(); err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+func (f *FedBOX) adminReadOnly(w http.ResponseWriter, r *http.Request) {
+	isReadOnly := f.toggleReadOnly()
+	f.Logger.WithContext(lw.Ctx{"readOnly": isReadOnly}).Debugf("toggle read-only mode")
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+func (f *FedBOX) adminDebug(w http.ResponseWriter, r *http.Request) {
+	f.toggleDebug()
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+func (f *FedBOX) adminReload(w http.ResponseWriter, r *http.Request) {
+	if err := f.reload(); err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.Status())
+}
+
+func (f *FedBOX) adminStop(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusAccepted, f.Status())
+	// We go through the signal handler, so the server can finish answering this request
+	go func() {
+		_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}()
+}
+
+func (f *FedBOX) adminListActors(w http.ResponseWriter, r *http.Request) {
+	types := vocab.ActorTypes
+	if typ := r.URL.Query().Get("type"); typ != "" {
+		types = vocab.ActivityVocabularyTypes{vocab.ActivityVocabularyType(typ)}
+	}
+	actors, err := f.Storage.Load(ap.SearchActorsIRI(vocab.IRI(f.Conf.BaseURL), ap.ByType(types...)))
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeItem(w, actors)
+}
+
+func (f *FedBOX) adminLoadObject(w http.ResponseWriter, r *http.Request) {
+	iri := vocab.IRI(r.URL.Query().Get("iri"))
+	if iri == "" {
+		errors.HandleError(errors.BadRequestf("missing iri parameter")).ServeHTTP(w, r)
+		return
+	}
+	it, err := f.Storage.Load(iri)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeItem(w, it)
+}
+
+func (f *FedBOX) adminDeleteObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	iris := make(vocab.IRIs, 0)
+	for _, iri := range q["iri"] {
+		iris = append(iris, vocab.IRI(iri))
+	}
+	if len(iris) == 0 {
+		errors.HandleError(errors.BadRequestf("missing iri parameter")).ServeHTTP(w, r)
+		return
+	}
+
+	ctl := f.commandBase(nil, nil, nil, nil)
+	err := ctl.DeleteObjects(q.Get("reason"), q["inReplyTo"], iris...)
+	_ = ctl.Audit("pub delete", q["iri"], err)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type adminClient struct {
+	ID          string `json:"id"`
+	RedirectURI string `json:"redirectUri"`
+}
+
+func (f *FedBOX) adminListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := f.Storage.ListClients()
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	result := make([]adminClient, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, adminClient{ID: c.GetId(), RedirectURI: c.GetRedirectUri()})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (f *FedBOX) adminDeleteClient(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	ctl := f.commandBase(nil, nil, nil, nil)
+	err := ctl.DeleteClient(id)
+	_ = ctl.Audit("oauth client del", []string{id}, err)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type adminTokenRequest struct {
+	Client string `json:"client"`
+	Actor  string `json:"actor"`
+}
+
+func (f *FedBOX) adminAddToken(w http.ResponseWriter, r *http.Request) {
+	req := adminTokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.HandleError(errors.NewBadRequest(err, "invalid token request")).ServeHTTP(w, r)
+		return
+	}
+	if req.Actor == "" {
+		errors.HandleError(errors.BadRequestf("missing actor")).ServeHTTP(w, r)
+		return
+	}
+	if req.Client == "" {
+		req.Client = string(f.Service.GetLink())
+	}
+
+	ctl := f.commandBase(nil, nil, nil, nil)
+	tok, err := ctl.GenAuthToken(req.Client, req.Actor, nil)
+	_ = ctl.Audit("oauth token add", []string{"--client=" + req.Client, req.Actor}, err)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"token": tok})
+}
+
+type adminCommandRequest struct {
+	Args []string `json:"args"`
+}
+
+// adminCommandFrame is a piece of the streamed response of the command endpoint. The last frame has Done set,
+// and it contains the outcome of the command.
+type adminCommandFrame struct {
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// adminCommand runs a command from the SSH command tree, with the privileges of the service actor.
+// The request body contains the JSON encoded command, followed by the standard input of the command, which is
+// read while the output is streamed back as JSON lines, so the commands can be interactive.
+func (f *FedBOX) adminCommand(w http.ResponseWriter, r *http.Request) {
+	req := adminCommandRequest{}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		errors.HandleError(errors.NewBadRequest(err, "invalid command request")).ServeHTTP(w, r)
+		return
+	}
+	if len(req.Args) == 0 {
+		errors.HandleError(errors.BadRequestf("missing command")).ServeHTTP(w, r)
+		return
+	}
+	if f.maintenanceMode.Load() {
+		// The storage is closed while in maintenance mode, the clients can access it directly
+		errors.HandleError(errors.ServiceUnavailablef("server is in maintenance mode")).ServeHTTP(w, r)
+		return
+	}
+
+	// We need to keep reading the input of the command after we started writing its output
+	_ = http.NewResponseController(w).EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &commandStream{w: w, enc: json.NewEncoder(w)}
+	stdin := lineReader{r: bufio.NewReader(io.MultiReader(dec.Buffered(), r.Body))}
+	ctl := f.commandBase(&f.Service, stdin, stream.writer(false), stream.writer(true))
+
+	done := adminCommandFrame{Done: true, Status: http.StatusOK}
+	if err := f.execCommand(ctl, AppName, req.Args); err != nil {
+		done.Error = err.Error()
+		done.Status = errors.HttpStatus(err)
+	}
+	stream.write(done)
+}
+
+// commandStream writes the output of a command as JSON lines, flushing them as soon as they are written.
+type commandStream struct {
+	sync.Mutex
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func (s *commandStream) write(frame adminCommandFrame) {
+	s.Lock()
+	defer s.Unlock()
+	_ = s.enc.Encode(frame)
+	_ = http.NewResponseController(s.w).Flush()
+}
+
+func (s *commandStream) writer(stderr bool) io.Writer {
+	return commandStreamWriter{s: s, stderr: stderr}
+}
+
+type commandStreamWriter struct {
+	s      *commandStream
+	stderr bool
+}
+
+func (w commandStreamWriter) Write(p []byte) (int, error) {
+	frame := adminCommandFrame{Stdout: string(p)}
+	if w.stderr {
+		frame = adminCommandFrame{Stderr: string(p)}
+	}
+	w.s.write(frame)
+	return len(p), nil
+}
+
+// lineReader returns at most one line of the input for every read, so the commands that read their input
+// line by line, using their own buffers, don't consume the lines meant for the following prompts.
+type lineReader struct {
+	r *bufio.Reader
+}
+
+func (l lineReader) Read(p []byte) (int, error) {
+	if l.r.Buffered() == 0 {
+		if _, err := l.r.Peek(1); err != nil {
+			return 0, err
+		}
+	}
+	buf, _ := l.r.Peek(l.r.Buffered())
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 && i+1 < len(p) {
+		p = p[:i+1]
+	}
+	return l.r.Read(p)
+}
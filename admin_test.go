@@ -0,0 +1,94 @@
+package fedbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+)
+
+func TestAdminSocketServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fedbox.sock")
+	// A stale file left from a previous run gets replaced
+	if err := os.WriteFile(path, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adminSocketServer(path, http.NotFoundHandler()); err != nil {
+		t.Fatalf("adminSocketServer() error = %s", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unable to stat the socket: %s", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		t.Errorf("%s is not a socket: %s", path, fi.Mode())
+	}
+	if perm := fi.Mode().Perm(); perm != adminSocketMode {
+		t.Errorf("socket mode = %o, want %o", perm, adminSocketMode)
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	r := lineReader{r: bufio.NewReader(strings.NewReader("first\nsecond\nlast"))}
+	want := []string{"first\n", "second\n", "last"}
+	for _, w := range want {
+		buf := make([]byte, 64)
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %s", err)
+		}
+		if got := string(buf[:n]); got != w {
+			t.Errorf("Read() = %q, want %q", got, w)
+		}
+	}
+	if _, err := r.Read(make([]byte, 64)); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF", err)
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	f := &FedBOX{Base: &Base{
+		Conf:    config.Options{Env: env.TEST},
+		Logger:  lw.Dev(),
+		Service: vocab.Actor{ID: "https://example.com"},
+	}}
+	f.status = f.Status
+	srv := httptest.NewServer(http.HandlerFunc(f.adminCommand))
+	defer srv.Close()
+
+	cl := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", srv.Listener.Addr().String())
+		},
+	}}
+
+	out := bytes.Buffer{}
+	ctl := &Base{in: strings.NewReader(""), out: &out, err: &out}
+	if err := ctl.runRemote(cl, parseSSHCommand(t, "status", "--output", "json")); err != nil {
+		t.Fatalf("runRemote() error = %s", err)
+	}
+	if !strings.Contains(out.String(), `"name": "`+AppName+`"`) {
+		t.Errorf("runRemote() output = %s, expected the status of the server", out.String())
+	}
+
+	out.Reset()
+	err := ctl.runRemote(cl, parseSSHCommand(t, "pub", "add", "--type", "Invalid"))
+	if err == nil {
+		t.Fatalf("runRemote() expected the invalid command to fail")
+	}
+	if !strings.Contains(out.String(), err.Error()) {
+		t.Errorf("runRemote() output = %q, expected it to contain the error %q", out.String(), err)
+	}
+}
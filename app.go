@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
 	m "git.sr.ht/~mariusor/servermux"
@@ -40,6 +41,15 @@ type FedBOX struct {
 
 	maintenanceMode atomic.Bool
 	shuttingDown    atomic.Bool
+	// readOnlyMode keeps the storage open, but refuses any request that would modify it.
+	readOnlyMode atomic.Bool
+	// writeStorage is the storage the server uses for its own writes, which are refused in read-only mode.
+	writeStorage storage.FullStorage
+
+	// lock is held while the server has the storage open, so CLI commands can't access it concurrently.
+	lock *storageLock
+
+	started time.Time
 
 	keyGenerator func(act *vocab.Actor) error
 }
@@ -57,12 +67,6 @@ func initHttpServer(app *FedBOX) (m.Server, error) {
 		}
 	}
 
-	// NOTE(marius): we now set-up a default socket listener
-	if !app.Conf.Env.IsTest() {
-		_ = os.RemoveAll(app.Conf.InternalSocketPath())
-		setters = append(setters, m.OnSocket(app.Conf.InternalSocketPath()))
-	}
-
 	listenHTTP := app.Conf.HTTPListen()
 	if len(listenHTTP) == 0 {
 		return nil, errors.Newf("No valid HTTP listen configurations")
@@ -102,7 +106,16 @@ func New(ctl *Base) (*FedBOX, error) {
 		return nil, errors.Newf("invalid storage")
 	}
 	conf := ctl.Conf
+
+	var lock *storageLock
+	if !conf.Env.IsTest() {
+		var err error
+		if lock, err = lockStorage(conf); err != nil {
+			return nil, errors.Annotatef(err, "unable to lock storage: %s", conf.StoragePath)
+		}
+	}
 	if err := db.Open(); err != nil {
+		_ = lock.Unlock()
 		return nil, errors.Annotatef(err, "unable to open storage: %s", conf.StoragePath)
 	}
 
@@ -115,21 +128,22 @@ func New(ctl *Base) (*FedBOX, error) {
 	if ctl.err == nil {
 		ctl.err = os.Stderr
 	}
+	ctl.suspensions = new(suspensionCache)
 	app := FedBOX{
-		Base:   ctl,
-		R:      chi.NewRouter(),
-		caches: cache.New(conf.RequestCache),
+		Base:    ctl,
+		R:       chi.NewRouter(),
+		caches:  cache.New(conf.RequestCache),
+		lock:    lock,
+		started: time.Now().UTC(),
 	}
-
-	if metaSaver, ok := db.(storage.MetadataStorage); ok {
-		keysType := ap.KeyTypeED25519
-		if conf.MastodonCompatible {
-			keysType = ap.KeyTypeRSA
-		}
-
-		ctl.Logger.Debugf("Setting actor key generator %T[%s]", metaSaver, keysType)
-		app.keyGenerator = ap.KeyGenerator(metaSaver, keysType)
+	if conf.RequestCache {
+		app.caches = withCounting(app.caches)
 	}
+	app.writeStorage = readOnlyStorage{FullStorage: db, readOnly: &app.readOnlyMode}
+
+	keyTypes := actorKeyTypes(conf)
+	ctl.Logger.Debugf("Setting actor key generator %T%v", db, keyTypes)
+	app.keyGenerator = ap.KeyGenerator(app.writeStorage, keyTypes...)
 
 	if err := ctl.LoadServiceActor(); err != nil {
 		app.Logger.WithContext(lw.Ctx{"err": err, "iri": ctl.Conf.BaseURL}).Warnf("no root service exists")
@@ -150,6 +164,14 @@ func New(ctl *Base) (*FedBOX, error) {
 	}
 	muxSetters = append(muxSetters, m.WithServer(httpSrv))
 
+	adminSrv, err := initAdminServer(&app)
+	if err != nil {
+		return nil, err
+	}
+	if adminSrv != nil {
+		muxSetters = append(muxSetters, m.WithServer(adminSrv))
+	}
+
 	sshServ, err := initSSHServer(&app)
 	if err != nil {
 		app.Logger.WithContext(lw.Ctx{"err": err}).Errorf("unable to open SSH connection")
@@ -169,10 +191,30 @@ func New(ctl *Base) (*FedBOX, error) {
 
 func (f *FedBOX) Pause() error {
 	if f.maintenanceMode.Load() {
-		// restart everything
-		f.Storage.Close()
-	} else {
-		return f.Storage.Open()
+		return f.releaseStorage()
+	}
+	return f.acquireStorage()
+}
+
+// releaseStorage closes the storage, and removes the lock, so CLI commands can access it directly
+// while in maintenance mode.
+func (f *FedBOX) releaseStorage() error {
+	f.Storage.Close()
+	return f.lock.Unlock()
+}
+
+// acquireStorage locks the storage, and opens it again. If it fails, the storage is left unlocked.
+func (f *FedBOX) acquireStorage() error {
+	if !f.Conf.Env.IsTest() {
+		lock, err := lockStorage(f.Conf)
+		if err != nil {
+			return err
+		}
+		f.lock = lock
+	}
+	if err := f.Storage.Open(); err != nil {
+		_ = f.lock.Unlock()
+		return err
 	}
 	return nil
 }
@@ -180,6 +222,7 @@ func (f *FedBOX) Pause() error {
 // Stop
 func (f *FedBOX) Stop(ctx context.Context) error {
 	f.Storage.Close()
+	_ = f.lock.Unlock()
 
 	f.shuttingDown.Store(true)
 	defer func() {
@@ -195,12 +238,87 @@ func (f *FedBOX) Stop(ctx context.Context) error {
 	return f.server.Stop(ctx)
 }
 
+// toggleMaintenance switches the maintenance mode of the server, and returns the new value.
+// When entering the maintenance mode, we stop serving requests before closing the storage, and when leaving it,
+// we start serving them again only after the storage has been successfully opened.
+func (f *FedBOX) toggleMaintenance() (bool, error) {
+	if !f.maintenanceMode.Load() {
+		f.maintenanceMode.Store(true)
+		return true, f.releaseStorage()
+	}
+	if err := f.acquireStorage(); err != nil {
+		return true, err
+	}
+	f.maintenanceMode.Store(false)
+	go f.replaySpool()
+	return false, nil
+}
+
+// toggleReadOnly switches the read-only mode of the server, and returns the new value.
+// Unlike the maintenance mode, the storage stays open, so the server can keep serving GET requests,
+// but nothing gets written to it, which allows for consistent copies of it to be made.
+func (f *FedBOX) toggleReadOnly() bool {
+	isReadOnly := !f.readOnlyMode.Load()
+	f.readOnlyMode.Store(isReadOnly)
+	if !isReadOnly {
+		go f.replaySpool()
+	}
+	return isReadOnly
+}
+
+// pauseWrites puts the server in read-only mode, if it isn't already, and returns the function that restores it.
+func (f *FedBOX) pauseWrites() func() {
+	wasReadOnly := f.readOnlyMode.Swap(true)
+	return func() {
+		if !wasReadOnly {
+			f.toggleReadOnly()
+		}
+	}
+}
+
+// pauseStorage puts the server in maintenance mode, if it isn't already, and closes the storage while keeping
+// the lock on it, so its files can be copied without other processes accessing them.
+// It returns the function that opens the storage again and restores the previous mode.
+func (f *FedBOX) pauseStorage() func() error {
+	if f.maintenanceMode.Swap(true) {
+		// The storage is already closed, and it's up to the operator to leave the maintenance mode
+		return func() error { return nil }
+	}
+	f.Storage.Close()
+	return func() error {
+		if err := f.Storage.Open(); err != nil {
+			// We stay in maintenance mode, and the activities received in the inboxes get spooled
+			return errors.Annotatef(err, "unable to reopen the storage, the server stays in maintenance mode")
+		}
+		f.maintenanceMode.Store(false)
+		go f.replaySpool()
+		return nil
+	}
+}
+
+// toggleDebug switches the debug mode of the server, and returns the new value.
+func (f *FedBOX) toggleDebug() bool {
+	isDebug := !f.debugMode.Load()
+	f.debugMode.Store(isDebug)
+	return isDebug
+}
+
 func (f *FedBOX) reload() (err error) {
 	err = config.Load(&f.Conf, ".")
 	f.caches.Delete()
 	return err
 }
 
+// actorKeyTypes returns the types of keys we generate for actors.
+func actorKeyTypes(conf config.Options) []ap.KeyType {
+	if conf.MastodonCompatible {
+		// Mastodon only understands RSA keys, so we publish one as the actor's publicKey,
+		// and the Ed25519 one as an assertion method.
+		return []ap.KeyType{ap.KeyTypeRSA, ap.KeyTypeED25519}
+	}
+	return []ap.KeyType{ap.KeyTypeED25519}
+}
+
 func IsProxyURL(i vocab.IRI) bool {
 	return strings.ToLower(filepath.Base(i.String())) == strings.ToLower("proxyUrl")
 }
@@ -240,6 +358,11 @@ func (f *FedBOX) Run(ctx context.Context) error {
 		logger.Warnf("Some CLI commands relying on it will not work")
 	}
 
+	f.scheduleKeyRotation(ctx)
+	f.scheduleSuspensionExpiry(ctx)
+	// Process the activities left over from a maintenance that ended with the server stopping
+	go f.replaySpool()
+
 	exitWithErrOrInterrupt := func(err error, exit chan<- error) {
 		if err == nil {
 			err = w.Interrupt
@@ -255,16 +378,13 @@ func (f *FedBOX) Run(ctx context.Context) error {
 			}
 		},
 		syscall.SIGUSR2: func(_ chan<- error) {
-			isDebug := f.debugMode.Load()
-			f.debugMode.Store(!isDebug)
-			logger.WithContext(lw.Ctx{"debug": !isDebug}).Debugf("SIGUSR2 received, toggle debug mode")
+			isDebug := f.toggleDebug()
+			logger.WithContext(lw.Ctx{"debug": isDebug}).Debugf("SIGUSR2 received, toggle debug mode")
 		},
 		syscall.SIGUSR1: func(_ chan<- error) {
-			isMaintenance := f.maintenanceMode.Load()
-			f.maintenanceMode.Store(!isMaintenance)
-
-			logFn := logger.WithContext(lw.Ctx{"maintenance": !isMaintenance}).Debugf
-			if err := f.Pause(); err != nil {
+			isMaintenance, err := f.toggleMaintenance()
+			logFn := logger.WithContext(lw.Ctx{"maintenance": isMaintenance}).Debugf
+			if err != nil {
 				logFn = logger.WithContext(lw.Ctx{"err": err.Error()}).Warnf
 			}
 			logFn("SIGUSR1 received, toggle maintenance mode")
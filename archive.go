@@ -0,0 +1,408 @@
+package fedbox
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-chi/chi/v5"
+)
+
+// archivesDir is the folder, in the storage path, where we keep the archives requested by the actors.
+const archivesDir = ".archives"
+
+type ArchiveState string
+
+const (
+	ArchivePending ArchiveState = "pending"
+	ArchiveReady   ArchiveState = "ready"
+	ArchiveFailed  ArchiveState = "failed"
+)
+
+// ArchiveStatus is the state of the archive of an actor's data, as returned by the archive endpoint.
+type ArchiveStatus struct {
+	State    ArchiveState `json:"state"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished,omitempty"`
+	Size     int64        `json:"size,omitempty"`
+	URL      vocab.IRI    `json:"url,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+var mediaTypes = vocab.ActivityVocabularyTypes{vocab.ImageType, vocab.VideoType, vocab.AudioType, vocab.DocumentType}
+
+// archiveJobs holds the actors for which an archive is being generated.
+var archiveJobs sync.Map
+
+// ArchiveRoutes serves the archive endpoint of the actors:
+// POST requests the generation of a new archive, GET returns its status, and GET /download returns the archive.
+func (f *FedBOX) ArchiveRoutes(r chi.Router) {
+	r.Get("/", f.archiveHandler(f.archiveStatus))
+	r.Post("/", f.archiveHandler(f.requestArchive))
+	r.Get("/download", f.archiveHandler(f.downloadArchive))
+}
+
+type archiveHandlerFn func(w http.ResponseWriter, r *http.Request, actor *vocab.Actor)
+
+// archiveHandler loads the actor that the archive endpoint belongs to, and checks that the request
+// has been authorized by it.
+func (f *FedBOX) archiveHandler(fn archiveHandlerFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri := vocab.IRI(reqURL(*r, f.Conf.Secure))
+
+		actor, err := ap.LoadActor(f.Storage, archiveActorIRI(iri))
+		if err != nil || !vocab.ActorTypes.Match(actor.Type) {
+			errors.HandleError(errors.NotFoundf("actor not found")).ServeHTTP(w, r)
+			return
+		}
+		authorized := f.actorFromRequestWithClient(r, ActorClient(f.Base, vocab.PublicNS), iri)
+		if authorized.ID.Equal(vocab.PublicNS) {
+			errors.HandleError(errors.Unauthorizedf("authorization required")).ServeHTTP(w, r)
+			return
+		}
+		if !authorized.ID.Equal(actor.ID) {
+			errors.HandleError(errors.Forbiddenf("the archive is available only to its actor")).ServeHTTP(w, r)
+			return
+		}
+		fn(w, r, &actor)
+	}
+}
+
+// archiveActorIRI returns the IRI of the actor that the archive endpoint IRI belongs to.
+// We strip only the trailing segments, as the actor's own path can contain "/archive".
+func archiveActorIRI(iri vocab.IRI) vocab.IRI {
+	u, err := iri.URL()
+	if err != nil {
+		return iri
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawPath = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.Path = strings.TrimSuffix(u.Path, "/download")
+	u.Path = strings.TrimSuffix(u.Path, "/"+ap.ArchiveEndpoint)
+	return vocab.IRI(u.String())
+}
+
+// archivePath returns the path of the archive file of the actor, and the one of its status.
+func (f *FedBOX) archivePath(actor vocab.IRI) (string, string, error) {
+	base, err := f.Conf.BaseStoragePath()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(actor))
+	name := filepath.Join(base, archivesDir, hex.EncodeToString(sum[:]))
+	return name + ".zip", name + ".json", nil
+}
+
+func (f *FedBOX) loadArchiveStatus(actor vocab.IRI) (*ArchiveStatus, error) {
+	_, statusFile, err := f.archivePath(actor)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(statusFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.NotFoundf("no archive has been requested")
+		}
+		return nil, err
+	}
+	st := new(ArchiveStatus)
+	if err = json.Unmarshal(raw, st); err != nil {
+		return nil, err
+	}
+	if _, running := archiveJobs.Load(actor); st.State == ArchivePending && !running {
+		// The server has been stopped while the archive was being generated
+		st.State = ArchiveFailed
+		st.Error = "the archive generation has been interrupted"
+	}
+	return st, nil
+}
+
+func (f *FedBOX) saveArchiveStatus(actor vocab.IRI, st ArchiveStatus) error {
+	_, statusFile, err := f.archivePath(actor)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statusFile, raw, 0o600)
+}
+
+func (f *FedBOX) archiveStatus(w http.ResponseWriter, r *http.Request, actor *vocab.Actor) {
+	st, err := f.loadArchiveStatus(actor.ID)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (f *FedBOX) requestArchive(w http.ResponseWriter, r *http.Request, actor *vocab.Actor) {
+	if _, running := archiveJobs.LoadOrStore(actor.ID, struct{}{}); running {
+		f.archiveStatus(w, r, actor)
+		return
+	}
+
+	archiveFile, _, err := f.archivePath(actor.ID)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(archiveFile), 0o700)
+	}
+	st := ArchiveStatus{State: ArchivePending, Started: time.Now().UTC()}
+	if err == nil {
+		err = f.saveArchiveStatus(actor.ID, st)
+	}
+	if err != nil {
+		archiveJobs.Delete(actor.ID)
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	go func() {
+		defer archiveJobs.Delete(actor.ID)
+
+		l := f.Logger.WithContext(lw.Ctx{"log": "archive", "actor": actor.ID})
+		size, err := f.writeArchive(actor, archiveFile)
+		st.Finished = time.Now().UTC()
+		if err != nil {
+			l.WithContext(lw.Ctx{"err": err.Error()}).Warnf("Unable to generate archive")
+			st.State = ArchiveFailed
+			st.Error = err.Error()
+		} else {
+			l.WithContext(lw.Ctx{"size": size}).Infof("Generated archive")
+			st.State = ArchiveReady
+			st.Size = size
+			st.URL = ap.ArchiveIRI(actor).AddPath("download")
+		}
+		_ = f.saveArchiveStatus(actor.ID, st)
+	}()
+
+	w.Header().Set("Location", ap.ArchiveIRI(actor).String())
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+func (f *FedBOX) downloadArchive(w http.ResponseWriter, r *http.Request, actor *vocab.Actor) {
+	st, err := f.loadArchiveStatus(actor.ID)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	if st.State != ArchiveReady {
+		errors.HandleError(errors.NotFoundf("the archive is not ready")).ServeHTTP(w, r)
+		return
+	}
+	archiveFile, _, err := f.archivePath(actor.ID)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	name := fmt.Sprintf("%s-%s.zip", path.Base(actor.ID.String()), st.Finished.Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeFile(w, r, archiveFile)
+}
+
+// writeArchive saves the data of the actor to a zip file: its profile, outbox, liked, following and followers
+// collections, and the media files it uploaded.
+func (f *FedBOX) writeArchive(actor *vocab.Actor, archiveFile string) (int64, error) {
+	tmp := archiveFile + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	zw := zip.NewWriter(out)
+	if err = f.archiveActor(zw, actor); err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+	if err = zw.Close(); err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+	if err = out.Close(); err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), os.Rename(tmp, archiveFile)
+}
+
+func writeZipItem(zw *zip.Writer, name string, it vocab.Item) error {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
+
+func (f *FedBOX) archiveActor(zw *zip.Writer, actor *vocab.Actor) error {
+	if err := writeZipItem(zw, "actor.json", actor); err != nil {
+		return err
+	}
+
+	media := vocab.ItemCollection{actor.Icon, actor.Image}
+	col := emptyCollection(f.Base, vocab.Outbox.IRI(actor))
+	err := streamCollection(f.Base, vocab.Outbox.IRI(actor), func(it vocab.Item) error {
+		it = f.embedObject(it)
+		// the media is collected before flattening, which replaces the attachments with their IRIs
+		media = append(media, activityMedia(it)...)
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			act.Actor = vocab.FlattenToIRI(act.Actor)
+			act.Object = vocab.FlattenProperties(act.Object)
+			return nil
+		})
+		_ = col.Append(it)
+		return nil
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err = writeZipItem(zw, "outbox.json", col); err != nil {
+		return err
+	}
+
+	for _, typ := range []vocab.CollectionPath{vocab.Liked, vocab.Following, vocab.Followers} {
+		members, err := collectionMembers(f.Base, typ.IRI(actor))
+		if err != nil {
+			continue
+		}
+		if err = writeZipItem(zw, string(typ)+".json", members); err != nil {
+			return err
+		}
+	}
+	return f.archiveMedia(zw, actor, media)
+}
+
+// embedObject loads the object of the activity when the storage returned only its IRI, so the archive
+// contains the objects the actor created, not only links to them.
+func (f *FedBOX) embedObject(it vocab.Item) vocab.Item {
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		if vocab.IsNil(act.Object) || !vocab.IsIRI(act.Object) {
+			return nil
+		}
+		ob, err := f.Storage.Load(act.Object.GetLink())
+		if err != nil || vocab.IsNil(ob) {
+			return nil
+		}
+		act.Object = ob
+		return nil
+	})
+	return it
+}
+
+// activityMedia returns the media objects created by the activity, and the attachments of its object.
+func activityMedia(it vocab.Item) vocab.ItemCollection {
+	media := make(vocab.ItemCollection, 0)
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		if act.Type != vocab.CreateType || vocab.IsNil(act.Object) {
+			return nil
+		}
+		if mediaTypes.Match(act.Object.GetType()) {
+			media = append(media, act.Object)
+		}
+		return vocab.OnObject(act.Object, func(ob *vocab.Object) error {
+			if vocab.IsItemCollection(ob.Attachment) {
+				return vocab.OnItemCollection(ob.Attachment, func(col *vocab.ItemCollection) error {
+					media = append(media, *col...)
+					return nil
+				})
+			}
+			media = append(media, ob.Attachment)
+			return nil
+		})
+	})
+	return media
+}
+
+// archiveMedia saves the contents of the local media objects, which are stored as data URIs.
+func (f *FedBOX) archiveMedia(zw *zip.Writer, actor *vocab.Actor, media vocab.ItemCollection) error {
+	seen := make(map[vocab.IRI]struct{})
+	for _, it := range media {
+		if vocab.IsNil(it) || !it.GetLink().Contains(vocab.IRI(f.Conf.BaseURL), false) {
+			continue
+		}
+		if _, ok := seen[it.GetLink()]; ok {
+			continue
+		}
+		seen[it.GetLink()] = struct{}{}
+
+		ob, err := f.Storage.Load(it.GetLink())
+		if err != nil || !mediaTypes.Match(ob.GetType()) {
+			continue
+		}
+		var content vocab.NaturalLanguageValues
+		var attributedTo vocab.Item
+		_ = vocab.OnObject(ob, func(o *vocab.Object) error {
+			content = o.Content
+			attributedTo = o.AttributedTo
+			return nil
+		})
+		if !vocab.IsNil(attributedTo) && !attributedTo.GetLink().Equal(actor.ID) {
+			continue
+		}
+		mediaType, raw, ok := decodeDataURI(content.First().String())
+		if !ok {
+			continue
+		}
+		name := path.Base(ob.GetLink().String())
+		if ext, _ := mime.ExtensionsByType(mediaType); len(ext) > 0 {
+			name += ext[0]
+		}
+		w, err := zw.Create(path.Join("media", name))
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeDataURI returns the media type and the contents of a base64 encoded data URI.
+func decodeDataURI(s string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(s, "data:")
+	if !ok {
+		return "", nil, false
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, false
+	}
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !isBase64 {
+		return mediaType, []byte(data), true
+	}
+	raw, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+	if err != nil {
+		return "", nil, false
+	}
+	return mediaType, raw, true
+}
@@ -0,0 +1,116 @@
+package fedbox
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestArchiveActorIRI(t *testing.T) {
+	tests := []struct {
+		iri  vocab.IRI
+		want vocab.IRI
+	}{
+		{iri: "https://example.com/actors/1/archive", want: "https://example.com/actors/1"},
+		{iri: "https://example.com/actors/1/archive/", want: "https://example.com/actors/1"},
+		{iri: "https://example.com/actors/1/archive/download", want: "https://example.com/actors/1"},
+		{iri: "https://example.com/actors/1/archive?x=1", want: "https://example.com/actors/1"},
+		{iri: "https://example.com/actors/archivist/archive", want: "https://example.com/actors/archivist"},
+		{iri: "https://example.com/actors/archive/archive/download", want: "https://example.com/actors/archive"},
+	}
+	for _, tt := range tests {
+		if got := archiveActorIRI(tt.iri); !got.Equal(tt.want) {
+			t.Errorf("archiveActorIRI(%s) = %s, want %s", tt.iri, got, tt.want)
+		}
+	}
+}
+
+func TestFedBOX_archiveActor(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	actor := &vocab.Actor{ID: checkBaseURL + "/actors/jdoe", Type: vocab.PersonType}
+	actor.Outbox = vocab.Outbox.IRI(actor)
+	_, _ = db.Save(actor)
+	_, _ = db.Save(newOrderedCollection(ctl, actor.Outbox.GetLink()))
+
+	img := &vocab.Object{
+		ID:           checkBaseURL + "/objects/img",
+		Type:         vocab.ImageType,
+		AttributedTo: actor.ID,
+		Content:      vocab.DefaultNaturalLanguage("data:image/png;base64,aGVsbG8="),
+	}
+	note := &vocab.Object{
+		ID:           checkBaseURL + "/objects/note",
+		Type:         vocab.NoteType,
+		AttributedTo: actor.ID,
+		Attachment:   img.ID,
+	}
+	// the storage keeps only the IRI of the object in the activity
+	create := &vocab.Activity{ID: checkBaseURL + "/activities/create", Type: vocab.CreateType, Actor: actor.ID, Object: note.ID}
+	for _, it := range []vocab.Item{img, note, create} {
+		_, _ = db.Save(it)
+	}
+	_ = db.AddTo(actor.Outbox.GetLink(), create)
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	f := &FedBOX{Base: ctl}
+	if err := f.archiveActor(zw, actor); err != nil {
+		t.Fatalf("archiveActor() error = %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid archive: %s", err)
+	}
+	files := make(map[string][]byte)
+	for _, zf := range zr.File {
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[zf.Name], _ = io.ReadAll(r)
+		_ = r.Close()
+	}
+	if _, ok := files["actor.json"]; !ok {
+		t.Errorf("actor.json is missing from the archive")
+	}
+	raw, ok := files["outbox.json"]
+	if !ok {
+		t.Fatalf("outbox.json is missing from the archive")
+	}
+	outbox, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		t.Fatalf("invalid outbox.json: %s", err)
+	}
+	_ = vocab.OnCollectionIntf(outbox, func(col vocab.CollectionInterface) error {
+		if len(col.Collection()) != 1 {
+			t.Fatalf("outbox items = %d, want 1", len(col.Collection()))
+		}
+		return vocab.OnActivity(col.Collection()[0], func(act *vocab.Activity) error {
+			if vocab.IsIRI(act.Object) || act.Object.GetType() != vocab.NoteType {
+				t.Errorf("the object of the activity = %v, expected the note to be embedded", act.Object)
+			}
+			return nil
+		})
+	})
+
+	found := false
+	for name, content := range files {
+		if strings.HasPrefix(name, "media/img") {
+			found = true
+			if string(content) != "hello" {
+				t.Errorf("%s = %q, want %q", name, content, "hello")
+			}
+		}
+	}
+	if !found {
+		t.Errorf("the media of the note is missing from the archive")
+	}
+}
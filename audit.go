@@ -0,0 +1,197 @@
+package fedbox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"git.sr.ht/~mariusor/mask"
+	"github.com/alecthomas/kong"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/pborman/uuid"
+)
+
+// readOnlyCommand is implemented by the commands that don't modify anything. They are not recorded in the audit log,
+// and they can be run while the server is in read-only mode.
+type readOnlyCommand interface {
+	readOnly() bool
+}
+
+func (Serve) readOnly() bool         { return true }
+func (StatusCmd) readOnly() bool     { return true }
+func (ListCmd) readOnly() bool       { return true }
+func (InfoCmd) readOnly() bool       { return true }
+func (ListActorsCmd) readOnly() bool { return true }
+func (ShowActorCmd) readOnly() bool  { return true }
+func (ExportCmd) readOnly() bool     { return true }
+func (Export) readOnly() bool        { return true }
+func (ListSSHKeys) readOnly() bool   { return true }
+func (LsClient) readOnly() bool      { return true }
+func (ListHostKeys) readOnly() bool  { return true }
+func (BackupCmd) readOnly() bool     { return true }
+func (MigrateCmd) readOnly() bool    { return true }
+func (ListAudit) readOnly() bool     { return true }
+
+// The following commands modify the storage only when some of their flags are set
+func (c CheckCmd) readOnly() bool  { return !c.Repair }
+func (f FetchCmd) readOnly() bool  { return !f.Store }
+func (r ActorRole) readOnly() bool { return r.Role == "" }
+
+// isReadOnly returns true if the command selected in the kong context doesn't modify anything.
+func isReadOnly(ktx *kong.Context) bool {
+	node := ktx.Selected()
+	if node == nil || !node.Target.IsValid() {
+		return false
+	}
+	cmd, ok := node.Target.Interface().(readOnlyCommand)
+	return ok && cmd.readOnly()
+}
+
+// AuditIRI returns the IRI of the private collection that holds the audit log entries.
+func AuditIRI(service vocab.Item) vocab.IRI {
+	return service.GetLink().AddPath("audit")
+}
+
+// commandArgs returns the arguments of the selected command, with the values of the flags tagged as secret masked.
+func commandArgs(ktx *kong.Context) []string {
+	args := make([]string, 0)
+	if node := ktx.Selected(); node != nil {
+		for _, pos := range node.Positional {
+			if pos.Set && pos.Target.IsValid() {
+				args = append(args, argValue(pos))
+			}
+		}
+	}
+	for _, flag := range ktx.Flags() {
+		if !flag.Set || !flag.Target.IsValid() {
+			continue
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", flag.Name, argValue(flag.Value)))
+	}
+	return args
+}
+
+// argValue returns the string representation of a command argument, masked if it's tagged as secret.
+func argValue(v *kong.Value) string {
+	var val string
+	switch t := v.Target.Interface().(type) {
+	case *os.File:
+		val = t.Name()
+	case []*os.File:
+		names := make([]string, 0, len(t))
+		for _, f := range t {
+			names = append(names, f.Name())
+		}
+		val = fmt.Sprintf("%v", names)
+	default:
+		val = fmt.Sprintf("%v", t)
+	}
+	if v.Tag != nil && v.Tag.Has("secret") {
+		val = mask.S(val).String()
+	}
+	return val
+}
+
+// runAudited runs the command selected in the kong context, and records it in the audit log if it's a mutating one.
+func (ctl *Base) runAudited(ktx *kong.Context) error {
+	err := ktx.Run(ctl)
+
+	if isReadOnly(ktx) {
+		return err
+	}
+	cmd := commandPath(ktx)
+	if auditErr := ctl.Audit(cmd, commandArgs(ktx), err); auditErr != nil {
+		ctl.Logger.WithContext(lw.Ctx{"cmd": cmd, "err": auditErr.Error()}).Warnf("unable to save audit log entry")
+	}
+	return err
+}
+
+// Audit saves an entry in the audit log for the cmd command, run by the current author with the args arguments.
+func (ctl *Base) Audit(cmd string, args []string, cmdErr error) error {
+	if ctl.Storage == nil || ctl.Service.ID == "" {
+		return errors.Newf("storage is not available")
+	}
+
+	col := AuditIRI(ctl.Service)
+	if _, err := ctl.Storage.Load(col); err != nil {
+		c := newOrderedCollection(ctl, col)
+		// The audit collection and its entries are addressed only to the service,
+		// so they are not visible to anybody else
+		c.To = vocab.ItemCollection{ctl.Service.ID}
+		if _, err = ctl.Storage.Save(c); err != nil {
+			return err
+		}
+	}
+
+	outcome := "OK"
+	if cmdErr != nil {
+		outcome = cmdErr.Error()
+	}
+	author := ctl.Author()
+	entry := &vocab.Activity{
+		ID:        col.AddPath(uuid.New()),
+		Type:      vocab.ActivityType,
+		Actor:     author.GetLink(),
+		To:        vocab.ItemCollection{ctl.Service.ID},
+		Published: time.Now().UTC(),
+		Summary:   vocab.DefaultNaturalLanguage(cmd),
+		Content:   vocab.DefaultNaturalLanguage(strings.Join(args, " ")),
+		Result: &vocab.Object{
+			Type:    vocab.NoteType,
+			Content: vocab.DefaultNaturalLanguage(outcome),
+		},
+	}
+	if _, err := ctl.Storage.Save(entry); err != nil {
+		return err
+	}
+	return ctl.Storage.AddTo(col, entry)
+}
+
+type Audit struct {
+	List ListAudit `cmd:"" help:"List the entries of the audit log."`
+}
+
+type ListAudit struct {
+	Since time.Time   `help:"Show only the entries newer than this time, in RFC3339 format." format:"2006-01-02T15:04:05Z07:00"`
+	Until time.Time   `help:"Show only the entries older than this time, in RFC3339 format." format:"2006-01-02T15:04:05Z07:00"`
+	Actor []vocab.IRI `help:"Show only the entries for commands run by these actors."`
+}
+
+func (l ListAudit) Run(ctl *Base) error {
+	err := streamCollection(ctl, AuditIRI(ctl.Service), func(it vocab.Item) error {
+		return vocab.OnActivity(it, func(e *vocab.Activity) error {
+			outcome := ""
+			_ = vocab.OnObject(e.Result, func(o *vocab.Object) error {
+				outcome = o.Content.String()
+				return nil
+			})
+			_, _ = fmt.Fprintf(ctl.out, "%s\t%s\t%s %s\t%s\n", e.Published.Format(time.RFC3339), e.Actor.GetLink(),
+				e.Summary.String(), e.Content.String(), outcome)
+			return nil
+		})
+	}, l.checks()...)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load audit log")
+	}
+	return nil
+}
+
+// checks builds the filters for the audit log entries to list.
+func (l ListAudit) checks() filters.Checks {
+	checks := make(filters.Checks, 0)
+	if !l.Since.IsZero() || !l.Until.IsZero() {
+		checks = append(checks, publishedBetween{since: l.Since, until: l.Until})
+	}
+	if len(l.Actor) > 0 {
+		actors := make(filters.Checks, 0, len(l.Actor))
+		for _, iri := range l.Actor {
+			actors = append(actors, filters.SameID(iri))
+		}
+		checks = append(checks, filters.Actor(filters.Any(actors...)))
+	}
+	return checks
+}
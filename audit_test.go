@@ -0,0 +1,91 @@
+package fedbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+func parseSSHCommand(t *testing.T, args ...string) *kong.Context {
+	vars := kong.Vars{}
+	for k, v := range kongDefaultVars {
+		vars[k] = v
+	}
+	k, err := kong.New(new(SSH), vars, kong.Exit(func(int) {}))
+	if err != nil {
+		t.Fatalf("unable to initialize the command parser: %s", err)
+	}
+	ktx, err := k.Parse(args)
+	if err != nil {
+		t.Fatalf("unable to parse %v: %s", args, err)
+	}
+	return ktx
+}
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"storage", "check"}, true},
+		{[]string{"storage", "check", "--repair"}, false},
+		{[]string{"pub", "actor", "list"}, true},
+		{[]string{"pub", "fetch", "https://example.com"}, true},
+		{[]string{"pub", "fetch", "--store", "https://example.com"}, false},
+		{[]string{"accounts", "role", "https://example.com/actors/jdoe"}, true},
+		{[]string{"accounts", "role", "https://example.com/actors/jdoe", "moderator"}, false},
+		{[]string{"oauth", "client", "del", "client"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := isReadOnly(parseSSHCommand(t, tt.args...)); got != tt.want {
+				t.Errorf("isReadOnly() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "host-key.pem")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ktx := parseSSHCommand(t, "storage", "host-keys", "import", keyPath)
+	for _, arg := range commandArgs(ktx) {
+		if strings.Contains(arg, keyPath) {
+			t.Errorf("commandArgs() = %s, the secret argument is not masked", arg)
+		}
+	}
+}
+
+func TestListAudit_checks(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	jdoe := vocab.IRI("https://example.com/actors/jdoe")
+	entry := &vocab.Activity{Type: vocab.ActivityType, Actor: jdoe, Published: now}
+
+	tests := []struct {
+		name string
+		list ListAudit
+		want bool
+	}{
+		{name: "no filters", list: ListAudit{}, want: true},
+		{name: "since", list: ListAudit{Since: now.Add(-time.Hour)}, want: true},
+		{name: "since after", list: ListAudit{Since: now.Add(time.Hour)}, want: false},
+		{name: "until before", list: ListAudit{Until: now.Add(-time.Hour)}, want: false},
+		{name: "actor", list: ListAudit{Actor: []vocab.IRI{"https://example.com/actors/admin", jdoe}}, want: true},
+		{name: "other actor", list: ListAudit{Actor: []vocab.IRI{"https://example.com/actors/admin"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filters.All(tt.list.checks()...).Match(entry); got != tt.want {
+				t.Errorf("checks() match = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package fedbox
 
 import (
 	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -149,8 +152,37 @@ func loadPubTypes(types ...vocab.ActivityVocabularyType) []vocab.ActivityVocabul
 	return append(append(objectTyp, actorTyp...), activityTyp...)
 }
 
+// loadAssertionKey loads the Ed25519 assertion method key of a local actor from its metadata.
+func (ctl *Base) loadAssertionKey(actor vocab.IRI) (vocab.IRI, ed25519.PrivateKey, bool) {
+	m := new(ap.Metadata)
+	if err := ctl.Storage.LoadMetadata(actor, m); err != nil {
+		return "", nil, false
+	}
+	return m.AssertionKey()
+}
+
+// withPublicKey returns a copy of the actor that has the pub key as its publicKey.
+func withPublicKey(act vocab.Actor, keyID vocab.IRI, pub crypto.PublicKey) *vocab.Actor {
+	enc, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return &act
+	}
+	act.PublicKey = vocab.PublicKey{
+		ID:           keyID,
+		Owner:        act.ID,
+		PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: enc})),
+	}
+	return &act
+}
+
 func ActorClient(ctl *Base, actor vocab.Item) *client.C {
-	var tr http.RoundTripper = &http.Transport{}
+	return actorClient(ctl, actor, &http.Transport{}, true)
+}
+
+// actorClient returns a client that signs its requests as the actor, and sends them through the tr transport.
+// When cached is false, the responses are neither loaded from, nor stored in, the private HTTP cache.
+func actorClient(ctl *Base, actor vocab.Item, tr http.RoundTripper, cached bool) *client.C {
+	tr = deliveryCounter{RoundTripper: tr}
 	if ctl.debugMode.Load() {
 		tr = debug.New(debug.WithTransport(tr), debug.WithPath(ctl.Conf.StoragePath))
 	}
@@ -158,18 +190,21 @@ func ActorClient(ctl *Base, actor vocab.Item) *client.C {
 	ll := ctl.Logger
 
 	conf := ctl.Conf
-	var cacheStorage cache2.Storage = cache2.Mem(MB)
-	if !conf.Env.IsDev() {
-		cachePath, err := os.UserCacheDir()
-		if err != nil {
-			cachePath = os.TempDir()
+	if cached {
+		var cacheStorage cache2.Storage = cache2.Mem(MB)
+		if !conf.Env.IsDev() {
+			cachePath, err := os.UserCacheDir()
+			if err != nil {
+				cachePath = os.TempDir()
+			}
+			cacheStorage = cache2.FS(filepath.Join(cachePath, conf.AppName))
 		}
-		cacheStorage = cache2.FS(filepath.Join(cachePath, conf.AppName))
+		tr = cache2.Private(tr, cacheStorage)
 	}
 
 	ua := fmt.Sprintf("%s@%s (+%s)", conf.BaseURL, conf.Version, ap.ProjectURL)
 	baseClient := &http.Client{
-		Transport: cache2.Private(tr, cacheStorage),
+		Transport: tr,
 	}
 
 	initFns := []client.OptionFn{
@@ -201,7 +236,32 @@ func ActorClient(ctl *Base, actor vocab.Item) *client.C {
 				s2s.WithCoveredComponents(s2s.FetchCoveredComponents...),
 				s2s.WithLogFn(ll.Warnf),
 			)
-			initFns = append(initFns, client.WithAuthorizationFn(sig.SignRFC9421, sig.SignDraft))
+			signFns := []func(*http.Request) error{sig.SignRFC9421, sig.SignDraft}
+			edKeyID := signActor.PublicKey.ID.GetLink()
+			edKey, hasEdKey := prv.(ed25519.PrivateKey)
+			if !hasEdKey {
+				if edKeyID, edKey, hasEdKey = ctl.loadAssertionKey(signActor.ID); hasEdKey {
+					// The main key is used for the draft-cavage signatures that Mastodon expects,
+					// while for RFC9421 signatures we prefer the Ed25519 assertion method key.
+					edSig := s2s.New(
+						s2s.WithActor(withPublicKey(*signActor, edKeyID, edKey.Public()), edKey),
+						s2s.WithCoveredComponents(s2s.FetchCoveredComponents...),
+						s2s.WithLogFn(ll.Warnf),
+					)
+					signFns = []func(*http.Request) error{edSig.SignRFC9421, sig.SignDraft}
+				}
+			}
+			if hasEdKey {
+				// We can only generate eddsa-jcs-2022 integrity proofs with Ed25519 keys
+				for i, signFn := range signFns {
+					signFns[i] = withIntegrityProof(signActor, edKey, edKeyID, ll, signFn)
+				}
+			}
+			for i, signFn := range signFns {
+				// The properties need to be added before the integrity proof is generated
+				signFns[i] = withLocalActorProperties(ctl, signFn)
+			}
+			initFns = append(initFns, client.WithAuthorizationFn(signFns...))
 		}
 	}
 	initFns = append(initFns, client.WithLogger(ll.WithContext(lw.Ctx{"log": "client"})))
@@ -232,6 +292,27 @@ func GenerateID(base vocab.IRI) func(it vocab.Item, by vocab.Item) (vocab.ID, er
 }
 
 func (ctl *Base) Saver(actor *vocab.Actor, onlyLocalSaves bool) processing.P {
+	if vocab.IsNil(actor) {
+		actor = &ctl.Service
+	}
+	if !onlyLocalSaves && ctl.IsSuspended(actor.ID) {
+		// The activities of suspended actors are not sent to other servers
+		ctl.Logger.WithContext(lw.Ctx{"log": "processing", "actor": actor.ID}).Warnf("not disseminating activities of suspended Actor")
+		onlyLocalSaves = true
+	}
+	return ctl.saver(actor, onlyLocalSaves)
+}
+
+// DeleteSaver returns a processor for the Delete activities of the actor, which are disseminated even when
+// the actor is suspended, as the remote servers need them for removing its content.
+func (ctl *Base) DeleteSaver(actor *vocab.Actor) processing.P {
+	if vocab.IsNil(actor) {
+		actor = &ctl.Service
+	}
+	return ctl.saver(actor, false)
+}
+
+func (ctl *Base) saver(actor *vocab.Actor, onlyLocalSaves bool) processing.P {
 	baseIRI := ctl.Service.ID
 
 	db := ctl.Storage
@@ -244,9 +325,6 @@ func (ctl *Base) Saver(actor *vocab.Actor, onlyLocalSaves bool) processing.P {
 	if baseIRI != "" && !baseIRI.Equal(auth.AnonymousActor.ID) {
 		initFns = append(initFns, processing.WithIRI(baseIRI, InternalIRI), processing.WithIDGenerator(GenerateID(baseIRI)))
 	}
-	if vocab.IsNil(actor) {
-		actor = &ctl.Service
-	}
 	if onlyLocalSaves {
 		// NOTE(marius): currently setting the retry count to a negative value
 		// is the only way to avoid remote dissemination.
@@ -324,7 +402,7 @@ func (ctl *Base) AddObject(p *vocab.Object, author vocab.Actor) (*vocab.Object,
 
 func (ctl *Base) DeleteObjects(reason string, inReplyTo []string, ids ...vocab.IRI) error {
 	invalidRemoveTypes := append(append(vocab.ActivityTypes, vocab.IntransitiveActivityTypes...), vocab.TombstoneType)
-	self := ap.Self(vocab.IRI(ctl.Conf.BaseURL), AppName)
+	author := ctl.Author()
 
 	d := new(vocab.Delete)
 	d.Type = vocab.DeleteType
@@ -344,7 +422,7 @@ func (ctl *Base) DeleteObjects(reason string, inReplyTo []string, ids ...vocab.I
 		}
 		d.InReplyTo = replIRI
 	}
-	d.Actor = self
+	d.Actor = author
 
 	delItems := make(vocab.ItemCollection, 0)
 	for _, iri := range ids {
@@ -353,10 +431,13 @@ func (ctl *Base) DeleteObjects(reason string, inReplyTo []string, ids ...vocab.I
 			continue
 		}
 		// NOTE(marius): this should work if "it" is a collection or a single object
-		_ = vocab.OnObject(it, func(o *vocab.Object) error {
+		err = vocab.OnObject(it, func(o *vocab.Object) error {
 			if invalidRemoveTypes.Match(o.GetType()) {
 				return nil
 			}
+			if err := ctl.canModerateItem(o); err != nil {
+				return err
+			}
 			d.To = o.To
 			d.Bto = o.Bto
 			d.CC = o.CC
@@ -367,14 +448,17 @@ func (ctl *Base) DeleteObjects(reason string, inReplyTo []string, ids ...vocab.I
 			delItems = append(delItems, o.GetLink())
 			return nil
 		})
+		if err != nil {
+			return err
+		}
 	}
-	d.CC = append(d.CC, self.GetLink())
+	d.CC = append(d.CC, author.GetLink())
 	if len(delItems) == 0 {
 		return errors.NotFoundf("No items found to delete")
 	}
 	d.Object = delItems
 
-	if _, err := ctl.Saver(&ctl.Service, false).ProcessClientActivity(d, self, vocab.Outbox.Of(d.Actor).GetLink()); err != nil {
+	if _, err := ctl.DeleteSaver(&author).ProcessClientActivity(d, author, vocab.Outbox.Of(d.Actor).GetLink()); err != nil {
 		return err
 	}
 
@@ -390,6 +474,9 @@ func (ctl *Base) operateOnObjects(fn func(col vocab.IRI, it vocab.Item) error, t
 	if err != nil {
 		return err
 	}
+	if err = ctl.canModerateItem(to); err != nil {
+		return err
+	}
 
 	for _, iri := range from {
 		it, err := ctl.Storage.Load(iri.GetLink())
@@ -405,6 +492,9 @@ func (ctl *Base) operateOnObjects(fn func(col vocab.IRI, it vocab.Item) error, t
 			return errors.Newf("Invalid object at IRI %s, %v", from, it)
 		}
 
+		if err = ctl.canModerateItem(it); err != nil {
+			return err
+		}
 		if err = fn(to, it); err != nil {
 			return err
 		}
@@ -505,6 +595,7 @@ func (ctl *Base) GenAuthToken(clientID, actorIdentifier string, _ any) (string,
 		ExpiresIn:   86400,
 		RedirectUri: cl.GetRedirectUri(),
 		State:       "state",
+		UserData:    actor.GetLink(),
 	}
 
 	// generate token code
@@ -591,7 +682,8 @@ func (ctl *Base) AddClient(pw []byte, redirectUris []string, u any) (string, err
 	if err != nil {
 		ctl.Logger.Errorf("Unable to generate key pair for application %s: %s", name, err)
 	} else {
-		if err = ap.AddKeyToItem(ctl.Storage, p, *pair); err != nil {
+		pairs, _ := ap.WithAssertionKey(*pair)
+		if err = ap.AddKeyToItem(ctl.Storage, p, pairs...); err != nil {
 			ctl.Logger.Errorf("Error saving metadata for application %s: %s", name, err)
 		}
 	}
@@ -669,11 +761,21 @@ func CreateService(ctl *Base, self vocab.Item, pair *ap.KeyPair, pw []byte) (err
 	}
 
 	if pair != nil {
-		if err = ap.AddKeyToItem(storage, self, *pair); err != nil {
+		pairs, _ := ap.WithAssertionKey(*pair)
+		if err = ap.AddKeyToItem(storage, self, pairs...); err != nil {
 			return err
 		}
 	}
 
+	// The SSH server has its own host keys, independent of the service actor's keys
+	hostKeys, err := ap.GenerateKeyPairs(ap.HostKeyTypes...)
+	if err != nil {
+		return err
+	}
+	if err = ap.SaveHostKeys(storage, service.ID, hostKeys...); err != nil {
+		return err
+	}
+
 	col := func(iri vocab.IRI) vocab.CollectionInterface {
 		return &vocab.OrderedCollection{
 			ID:           iri,
@@ -2,6 +2,7 @@ package fedbox
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -16,11 +17,15 @@ type Accounts struct {
 	Export  Export         `cmd:"" help:"Exports accounts metadata."`
 	Import  Import         `cmd:"" help:"Imports accounts metadata."`
 	GenKeys GenKeys        `cmd:"" help:"Generate public/private key pairs for actors that are missing them."`
+	Rotate  RotateKeys     `cmd:"" name:"rotate-keys" help:"Rotate the public/private key pairs of actors, keeping the old ones valid for a while."`
 	Pass    ChangePassword `cmd:"" help:"Change password for an actor."`
+	SSHKeys SSHKeys        `cmd:"" name:"ssh-keys" help:"Manage the SSH public keys actors can use to log in."`
+	Role    ActorRole      `cmd:"" help:"Show or change the role of an actor."`
+	Erase   EraseCmd       `cmd:"" help:"Remove an actor and everything it authored."`
 }
 
 type Export struct {
-	To string `flag:"" help:"The file where to output the items, if absent it will be printed to stdout."`
+	To string `flag:"" type:"path" help:"The file where to output the items, if absent it will be printed to stdout."`
 }
 
 func (e Export) Run(ctl *Base) error {
@@ -53,7 +58,7 @@ func (e Export) Run(ctl *Base) error {
 	if err != nil {
 		return err
 	}
-	where := os.Stdout
+	var where io.Writer = ctl.out
 	if e.To != "" {
 		f, err := os.OpenFile(e.To, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
@@ -67,7 +72,7 @@ func (e Export) Run(ctl *Base) error {
 }
 
 type Import struct {
-	Files []*os.File `arg:"" help:"The file containing the JSON encoded items."`
+	Files []*os.File `arg:"" secret:"" help:"The file containing the JSON encoded items."`
 }
 
 func (i Import) Run(ctl *Base) error {
@@ -153,7 +158,8 @@ func (g GenKeys) Run(ctl *Base) error {
 		saver := ctl.Saver(actor, false)
 		pair, _ := ap.GenerateKeyPair(ap.KeyType(typ))
 		if pair != nil {
-			if err := ap.AddKeyToItem(metaSaver, actor, *pair); err != nil {
+			pairs, _ := ap.WithAssertionKey(*pair)
+			if err := ap.AddKeyToItem(metaSaver, actor, pairs...); err != nil {
 				Errf(ctl.err, "Error: %s", err.Error())
 			}
 		}
@@ -164,10 +170,88 @@ func (g GenKeys) Run(ctl *Base) error {
 			return err
 		}
 	}
-	_, _ = fmt.Fprintln(os.Stdout, "OK")
+	_, _ = fmt.Fprintln(ctl.out, "OK")
 	return nil
 }
 
+type RotateKeys struct {
+	Type      string        `help:"Type of keys to generate: ${keyTypes}. If missing we use the server's defaults." name:"key-type"`
+	Overlap   time.Duration `help:"Duration during which the old keys can still be used to verify signatures." default:"${keyRotationOverlap}"`
+	OlderThan time.Duration `help:"Rotate the keys of all local actors that are older than this duration."`
+	IRIs      []vocab.IRI   `arg:"" optional:"" name:"iri" help:"Actors for which to rotate the keys."`
+}
+
+func (r RotateKeys) Run(ctl *Base) error {
+	if len(r.IRIs) == 0 {
+		if r.OlderThan <= 0 {
+			return errors.Newf("either a list of actors, or an age needs to be passed")
+		}
+		count, err := ctl.RotateStaleKeys(r.OlderThan, r.Overlap)
+		_, _ = fmt.Fprintf(ctl.out, "Rotated keys for %d actors\n", count)
+		return err
+	}
+
+	for _, iri := range r.IRIs {
+		maybeActor, err := ctl.Storage.Load(iri)
+		if err != nil {
+			Errf(ctl.err, "Error: %s", err)
+			continue
+		}
+		actor, err := vocab.ToActor(maybeActor)
+		if err != nil {
+			Errf(ctl.err, "Error: %s", err)
+			continue
+		}
+
+		var pairs []ap.KeyPair
+		if r.Type != "" {
+			pair, err := ap.GenerateKeyPair(ap.KeyType(r.Type))
+			if err != nil {
+				return err
+			}
+			pairs, err = ap.WithAssertionKey(*pair)
+		} else {
+			pairs, err = ap.GenerateKeyPairs(actorKeyTypes(ctl.Conf)...)
+		}
+		if err != nil {
+			return err
+		}
+		if err = ctl.RotateActorKeys(actor, r.Overlap, pairs...); err != nil {
+			Errf(ctl.err, "Error: %s", err)
+			continue
+		}
+		_, _ = fmt.Fprintf(ctl.out, "Rotated keys for %s\n", actor.ID)
+	}
+	return nil
+}
+
+type ActorRole struct {
+	IRI  vocab.IRI `arg:"" name:"iri" help:"The actor for which to show or change the role."`
+	Role ap.Role   `arg:"" optional:"" name:"role" help:"The new role of the actor: ${roles}."`
+}
+
+func (r ActorRole) Run(ctl *Base) error {
+	actor, err := loadActor(ctl, r.IRI)
+	if err != nil {
+		return err
+	}
+	if r.Role == "" {
+		_, _ = fmt.Fprintf(ctl.out, "%s\n", ctl.RoleOf(actor.ID))
+		return nil
+	}
+	if err = ap.ValidRole(r.Role); err != nil {
+		return err
+	}
+	if ctl.Service.ID.Equal(actor.ID) {
+		return errors.Newf("the role of the service actor can't be changed")
+	}
+
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor.ID, m)
+	m.Role = r.Role
+	return ctl.Storage.SaveMetadata(actor.ID, m)
+}
+
 type ChangePassword struct {
 	IRI vocab.IRI `arg:"" optional:"" name:"iri" help:"The actor for which to change the password."`
 }
@@ -1,9 +1,7 @@
 package fedbox
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"net/url"
 	"os"
 	"sort"
@@ -26,15 +24,14 @@ type AddActorCmd struct {
 func (a AddActorCmd) Run(ctl *Base) error {
 	keyType := a.KeyType
 	if len(a.Names) == 0 {
-		// FIXME(marius): pass stdIn also to the Run
-		name, err := loadFromStdin("Enter the actor's name")
-		if err != nil {
+		name, err := readLine(ctl.in, ctl.out, "Enter the actor's name: ")
+		if err != nil || name == "" {
 			return errors.Errorf("Missing the actor's name")
 		}
-		a.Names = append(a.Names, string(name))
+		a.Names = append(a.Names, name)
 	}
 
-	author := ap.Self(vocab.IRI(ctl.Conf.BaseURL), AppName)
+	author := ctl.Author()
 	if authIRI := a.AttributedTo; len(authIRI) > 0 {
 		act, err := ap.LoadActor(ctl.Storage, authIRI)
 		if err != nil {
@@ -43,27 +40,7 @@ func (a AddActorCmd) Run(ctl *Base) error {
 		author = act
 	}
 
-	tags := make(vocab.ItemCollection, 0)
-
-	objectsCollection := filters.ObjectsType.IRI(vocab.IRI(ctl.Conf.BaseURL))
-	allObjects, _ := ctl.Storage.Load(objectsCollection)
-	_ = vocab.OnCollectionIntf(allObjects, func(col vocab.CollectionInterface) error {
-		for _, it := range col.Collection() {
-			_ = vocab.OnObject(it, func(object *vocab.Object) error {
-				for _, tag := range a.Tags {
-					if object.Name.First().String() != tag {
-						continue
-					}
-					if object.AttributedTo.GetLink() != author.GetLink() {
-						continue
-					}
-					_ = tags.Append(object)
-				}
-				return nil
-			})
-		}
-		return nil
-	})
+	tags := findTags(ctl, author, a.Tags)
 
 	rw := muxReadWriter{Reader: ctl.in, Writer: ctl.out}
 	var actors = make(vocab.ItemCollection, 0)
@@ -101,7 +78,8 @@ func (a AddActorCmd) Run(ctl *Base) error {
 		_, _ = fmt.Fprintf(ctl.out, "\t%s\n", p.GetLink())
 		pair, _ := ap.GenerateKeyPair(ap.KeyType(keyType))
 		if pair != nil {
-			if err := ap.AddKeyToItem(ctl.Storage, p, *pair); err != nil {
+			pairs, _ := ap.WithAssertionKey(*pair)
+			if err := ap.AddKeyToItem(ctl.Storage, p, pairs...); err != nil {
 				Errf(ctl.err, "Error saving metadata for %s: %s", name, err)
 			}
 		}
@@ -113,21 +91,20 @@ func (a AddActorCmd) Run(ctl *Base) error {
 	return nil
 }
 
-type ActorsCmd struct {
-	Add AddActorCmd `cmd:"" help:"Adds an ActivityPub actor."`
-}
-
 type Pub struct {
-	Actors ActorsCmd `cmd:"" name:"actor" help:"Actor management helper."`
-	Add    AddCmd    `cmd:"" name:"add" help:"Adds a new object."`
-	List   ListCmd   `cmd:"" help:"Lists objects."`
-	Info   InfoCmd   `cmd:"" help:"Show information about an object."`
-	Delete DeleteCmd `cmd:"" help:"Deletes an ActivityPub object."`
-	Move   MoveCmd   `cmd:"" help:"Move ActivityPub objects to a new collection."`
-	Copy   CopyCmd   `cmd:"" help:"Copy ActivityPub objects."`
-	Index  IndexCmd  `cmd:"" help:"Reindex current storage ActivityPub objects."`
-	Export ExportCmd `cmd:"" help:"Exports ActivityPub objects."`
-	Import ImportCmd `cmd:"" help:"Imports ActivityPub objects."`
+	Actors         ActorsCmd         `cmd:"" name:"actor" help:"Actor management helper."`
+	Add            AddCmd            `cmd:"" name:"add" help:"Adds a new object."`
+	List           ListCmd           `cmd:"" help:"Lists objects."`
+	Info           InfoCmd           `cmd:"" help:"Show information about an object."`
+	Delete         DeleteCmd         `cmd:"" help:"Deletes an ActivityPub object."`
+	Move           MoveCmd           `cmd:"" help:"Move ActivityPub objects to a new collection."`
+	Copy           CopyCmd           `cmd:"" help:"Copy ActivityPub objects."`
+	Index          IndexCmd          `cmd:"" help:"Reindex current storage ActivityPub objects."`
+	Export         ExportCmd         `cmd:"" help:"Exports ActivityPub objects."`
+	Import         ImportCmd         `cmd:"" help:"Imports ActivityPub objects."`
+	ImportMastodon ImportMastodonCmd `cmd:"" name:"import-mastodon" help:"Imports a Mastodon account archive."`
+	Send           SendCmd           `cmd:"" help:"Sends an activity as a local actor."`
+	Fetch          FetchCmd          `cmd:"" help:"Fetches a remote object with a signed request."`
 }
 
 var ValidGenericTypes = vocab.ActivityVocabularyTypes{vocab.ObjectType, vocab.ActorType}
@@ -211,8 +188,8 @@ func (a AddCmd) Run(ctl *Base) error {
 
 	incName := a.Name
 	if len(incName) == 0 {
-		if n, err := loadFromStdin("Enter the %s's %s", incType, prop); err == nil {
-			incName = string(n)
+		if n, err := readLine(ctl.in, ctl.out, fmt.Sprintf("Enter the %s's %s: ", incType, prop)); err == nil {
+			incName = n
 		}
 	}
 	if n := names(incName); n != nil {
@@ -221,7 +198,7 @@ func (a AddCmd) Run(ctl *Base) error {
 
 	authIRI := vocab.IRI(a.AttributedTo)
 	if len(authIRI) == 0 {
-		authIRI = vocab.IRI(ctl.Conf.BaseURL)
+		authIRI = ctl.Author().ID
 	}
 	author, err := ap.LoadActor(ctl.Storage, authIRI, f...)
 	if err != nil {
@@ -246,122 +223,6 @@ func (a AddCmd) Run(ctl *Base) error {
 	return nil
 }
 
-type ImportCmd struct {
-	Base        vocab.IRI  `flag:"" help:"The base IRI to replace"`
-	SkipRemotes bool       `flag:"" help:"Do not try to disseminate to remote recipients"`
-	Files       []*os.File `arg:"" help:"The files containing the JSON encoded items."`
-}
-
-func (i ImportCmd) Run(ctl *Base) error {
-	baseIRI := ctl.Conf.BaseURL
-	toReplace := i.Base
-
-	var col vocab.ItemCollection
-	for _, f := range i.Files {
-		buf, err := io.ReadAll(f)
-		if err != nil {
-			Errf(ctl.err, "Error %v", err)
-			continue
-		}
-		if len(buf) == 0 {
-			Errf(ctl.err, "Empty file %s", f.Name())
-			continue
-		}
-
-		if len(toReplace) > 0 {
-			buf = bytes.Replace(buf, []byte(toReplace), []byte(baseIRI), -1)
-		}
-		ob, err := vocab.UnmarshalJSON(buf)
-		if err != nil {
-			Errf(ctl.err, "Error unmarshaling JSON: %v", err)
-			continue
-		}
-
-		if vocab.IsItemCollection(ob) {
-			var isItThough bool
-			if col, isItThough = ob.(vocab.ItemCollection); !isItThough {
-				Errf(ctl.err, "Invalid item collection unmarshaled: %v", err)
-			}
-		} else {
-			col = vocab.ItemCollection{ob}
-		}
-	}
-
-	activities := make(vocab.ItemCollection, 0, len(col))
-	objects := make(vocab.ItemCollection, 0, len(col))
-	for _, it := range col {
-		typ := it.GetType()
-		if vocab.ActivityTypes.Match(typ) || vocab.IntransitiveActivityTypes.Match(typ) {
-			activities = append(activities, it)
-		} else {
-			objects = append(objects, it)
-		}
-	}
-
-	count := 0
-	start := time.Now()
-	for _, it := range activities {
-		_, _ = fmt.Fprintf(ctl.out, "Processing %s %s\n", it.GetType(), it.GetID())
-		err := vocab.OnIntransitiveActivity(it, func(a *vocab.IntransitiveActivity) error {
-			if a == nil {
-				Errf(ctl.err, "invalid activity, is nil: %s", it.GetLink())
-				return nil
-			}
-			if a.Actor == nil {
-				Errf(ctl.err, "invalid activity, actor is nil: %s", it.GetLink())
-				return nil
-			}
-			actor, err := vocab.ToActor(a.Actor)
-			if err != nil {
-				actor = &vocab.Actor{ID: a.Actor.GetLink()}
-			}
-			activityPub := ctl.Saver(&ctl.Service, i.SkipRemotes)
-			it, err = activityPub.ProcessClientActivity(it, *actor, vocab.Outbox.Of(a.Actor).GetLink())
-			return err
-		})
-		if err != nil {
-			Errf(ctl.err, "Unable to process %s %s: %v", it.GetType(), it.GetID(), err)
-			continue
-		}
-		count++
-	}
-
-	tot := time.Now().Sub(start)
-	_, _ = fmt.Fprintf(ctl.out, "Activities processing time:          %3s\n", tot)
-	if count > 0 {
-		perIt := time.Duration(int64(tot) / int64(count))
-		_, _ = fmt.Fprintf(ctl.out, "Elapsed time per activity: %3s\n", perIt)
-	}
-
-	count = 0
-	start = time.Now()
-	for _, it := range objects {
-		// NOTE(marius): need to check if already created by activities processing
-		exists, err := ctl.Storage.Load(it.GetLink())
-		if (err != nil && errors.IsNotFound(err)) || vocab.IsNil(exists) {
-			if _, err := ctl.Storage.Save(it); err != nil {
-				Errf(ctl.err, "Unable to save %s %s: %v", it.GetType(), it.GetID(), err)
-				continue
-			}
-		}
-		count++
-	}
-
-	tot = time.Now().Sub(start)
-	_, _ = fmt.Fprintf(ctl.out, "Elapsed item save time:          %4s\n", tot)
-	if count > 0 {
-		perIt := time.Duration(int64(tot) / int64(count))
-		_, _ = fmt.Fprintf(ctl.out, "Elapsed time per item: %4s\n", perIt)
-	}
-
-	_, _ = fmt.Fprintf(ctl.out, "Import done!\n")
-	return nil
-}
-
-type ExportCmd struct {
-	To string `name:"to" optional:"" help:"The files where to output the items, if absent it will be printed to stdout."`
-}
-
 func dumpAll(ctl *Base, iri vocab.IRI, f ...filters.Check) (vocab.ItemCollection, error) {
 	col := make(vocab.ItemCollection, 0)
 	objects, err := ctl.Storage.Load(iri, f...)
@@ -379,38 +240,6 @@ func dumpAll(ctl *Base, iri vocab.IRI, f ...filters.Check) (vocab.ItemCollection
 	return col, nil
 }
 
-func (e ExportCmd) Run(ctl *Base) error {
-	baseURL := vocab.IRI(ctl.Conf.BaseURL)
-	objects := make(vocab.ItemCollection, 0)
-	allCollections := vocab.CollectionPaths{filters.ActivitiesType, filters.ActorsType, filters.ObjectsType}
-	for _, col := range allCollections {
-		dump, err := dumpAll(ctl, vocab.IRIf(baseURL, col))
-		if err != nil {
-			return err
-		}
-		objects = append(objects, dump...)
-	}
-	if len(objects) == 0 {
-		return errors.Errorf("No objects to export")
-	}
-	sort.Slice(objects, func(i, j int) bool {
-		return vocab.ItemOrderTimestamp(objects[i], objects[j])
-	})
-	for i, it := range objects {
-		objects[i] = vocab.FlattenProperties(it)
-	}
-	where := os.Stdout
-	if e.To != "" {
-		f, err := os.OpenFile(e.To, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		where = f
-	}
-	return OutJSON(where)(objects)
-}
-
 type InfoCmd struct {
 	IRIs   []vocab.IRI `arg:"" name:"iris"`
 	Output string      `help:"The format in which to output the items." enum:"text,json" default:"text"`
@@ -0,0 +1,371 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
+)
+
+type ActorsCmd struct {
+	Add       AddActorCmd       `cmd:"" help:"Adds an ActivityPub actor."`
+	List      ListActorsCmd     `cmd:"" help:"Lists the actors, with the counts of their followers and posts."`
+	Show      ShowActorCmd      `cmd:"" help:"Show information about an actor."`
+	Update    UpdateActorCmd    `cmd:"" help:"Updates the profile of a local actor, and publishes an Update activity."`
+	Suspend   SuspendActorCmd   `cmd:"" help:"Suspends a local actor, without removing its data."`
+	Unsuspend UnsuspendActorCmd `cmd:"" help:"Lifts the suspension of a local actor."`
+	Delete    DeleteActorCmd    `cmd:"" help:"Deletes a local actor, and notifies its followers."`
+}
+
+// ActorSummary contains the information about an actor shown by the actor list and show commands.
+type ActorSummary struct {
+	IRI                       vocab.IRI   `json:"iri"`
+	Type                      vocab.Typer `json:"type"`
+	Name                      string      `json:"name"`
+	Local                     bool        `json:"local"`
+	Role                      ap.Role     `json:"role,omitempty"`
+	Followers                 int         `json:"followers"`
+	Following                 int         `json:"following"`
+	Posts                     int         `json:"posts"`
+	ManuallyApprovesFollowers bool        `json:"manuallyApprovesFollowers,omitempty"`
+	Suspended                 time.Time   `json:"suspended,omitempty"`
+	SuspendedUntil            time.Time   `json:"suspendedUntil,omitempty"`
+	SuspendReason             string      `json:"suspendReason,omitempty"`
+}
+
+func summarizeActor(ctl *Base, act *vocab.Actor) ActorSummary {
+	s := ActorSummary{
+		IRI:       act.ID,
+		Type:      act.Type,
+		Name:      vocab.PreferredNameOf(act),
+		Local:     act.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false),
+		Followers: collectionTotal(ctl, vocab.Followers.IRI(act)),
+		Following: collectionTotal(ctl, vocab.Following.IRI(act)),
+		Posts:     collectionTotal(ctl, vocab.Outbox.IRI(act)),
+	}
+	if !s.Local {
+		return s
+	}
+	s.Role = ctl.RoleOf(act.ID)
+	m := new(ap.Metadata)
+	if err := ctl.Storage.LoadMetadata(act.ID, m); err == nil {
+		s.ManuallyApprovesFollowers = m.ManuallyApprovesFollowers
+		if m.IsSuspended(time.Now().UTC()) {
+			s.Suspended = m.Suspended
+			s.SuspendedUntil = m.SuspendedUntil
+			s.SuspendReason = m.SuspendReason
+		}
+	}
+	return s
+}
+
+// collectionTotal returns the number of items in the collection, or zero if it can't be loaded.
+// We load only the first item, as the storage sets totalItems to the size of the whole collection.
+func collectionTotal(ctl *Base, iri vocab.IRI) int {
+	it, err := ctl.Storage.Load(iri, filters.WithMaxCount(1))
+	if err != nil || vocab.IsNil(it) {
+		return 0
+	}
+	count := 0
+	_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		count = len(col.Collection())
+		return nil
+	})
+	switch it.GetType() {
+	case vocab.OrderedCollectionType, vocab.OrderedCollectionPageType:
+		_ = vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+			count = max(count, int(col.TotalItems))
+			return nil
+		})
+	case vocab.CollectionType, vocab.CollectionPageType:
+		_ = vocab.OnCollection(it, func(col *vocab.Collection) error {
+			count = max(count, int(col.TotalItems))
+			return nil
+		})
+	}
+	return count
+}
+
+// localActor loads the actor with the iri, and verifies that it belongs to the current instance.
+func localActor(ctl *Base, iri vocab.IRI) (*vocab.Actor, error) {
+	actor, err := loadActor(ctl, iri)
+	if err != nil || actor == nil || actor.ID == "" {
+		return nil, errors.NotFoundf("unable to load actor %s", iri)
+	}
+	if !actor.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+		return nil, errors.Newf("the actor %s is not local", actor.ID)
+	}
+	return actor, nil
+}
+
+type ListActorsCmd struct {
+	Type   []vocab.ActivityVocabularyType `help:"The type(s) of the actors to list."`
+	Name   string                         `help:"List only the actors whose name contains this value."`
+	Output string                         `short:"o" help:"The format in which to output the actors: text or json." enum:"text,json" default:"text"`
+}
+
+func (l ListActorsCmd) Run(ctl *Base) error {
+	checks := make(filters.Checks, 0)
+	if len(l.Type) > 0 {
+		checks = append(checks, filters.HasType(l.Type...))
+	}
+	if l.Name != "" {
+		checks = append(checks, filters.NameLike(l.Name))
+	}
+	// The actors are loaded one page at a time, in the order of the collection, and we keep only their summaries
+	summaries := make([]ActorSummary, 0)
+	err := streamCollection(ctl, filters.ActorsType.IRI(vocab.IRI(ctl.Conf.BaseURL)), func(it vocab.Item) error {
+		return vocab.OnActor(it, func(act *vocab.Actor) error {
+			if act.ID != "" {
+				summaries = append(summaries, summarizeActor(ctl, act))
+			}
+			return nil
+		})
+	}, checks...)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if l.Output == "json" {
+		enc := json.NewEncoder(ctl.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	}
+	for _, s := range summaries {
+		status := ""
+		if !s.Suspended.IsZero() {
+			status = " [suspended]"
+		}
+		_, _ = fmt.Fprintf(ctl.out, "[%s] %s %q followers: %d posts: %d%s\n", s.Type, s.IRI, s.Name, s.Followers, s.Posts, status)
+	}
+	return nil
+}
+
+type ShowActorCmd struct {
+	IRI    vocab.IRI `arg:"" name:"iri" help:"The actor to show."`
+	Output string    `short:"o" help:"The format in which to output the actor: text or json." enum:"text,json" default:"text"`
+}
+
+func (s ShowActorCmd) Run(ctl *Base) error {
+	actor, err := loadActor(ctl, s.IRI)
+	if err != nil {
+		return err
+	}
+	sum := summarizeActor(ctl, actor)
+	if s.Output == "json" {
+		enc := json.NewEncoder(ctl.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sum)
+	}
+	if err = printItem(ctl.out, actor, s.Output); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(ctl.out, "\n\tFollowers: %d\n\tFollowing: %d\n\tPosts: %d\n", sum.Followers, sum.Following, sum.Posts)
+	if !sum.Local {
+		return nil
+	}
+	_, _ = fmt.Fprintf(ctl.out, "\tRole: %s\n", sum.Role)
+	_, _ = fmt.Fprintf(ctl.out, "\tManually approves followers: %s\n", yesNo(sum.ManuallyApprovesFollowers))
+	if !sum.Suspended.IsZero() {
+		_, _ = fmt.Fprintf(ctl.out, "\tSuspended: %s %s\n", sum.Suspended.Format(time.RFC3339), sum.SuspendReason)
+		if !sum.SuspendedUntil.IsZero() {
+			_, _ = fmt.Fprintf(ctl.out, "\tSuspended until: %s\n", sum.SuspendedUntil.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+type UpdateActorCmd struct {
+	IRI                       vocab.IRI `arg:"" name:"iri" help:"The local actor to update."`
+	Name                      *string   `help:"The new name of the actor."`
+	Summary                   *string   `help:"The new summary of the actor."`
+	Icon                      *string   `help:"The URL of the new icon of the actor."`
+	ManuallyApprovesFollowers *bool     `name:"manually-approves-followers" negatable:"" help:"Signal to other servers that the follow requests need to be approved."`
+	Tags                      []string  `name:"tag" help:"The tag(s) to attach to the actor, replacing the current ones."`
+}
+
+func (u UpdateActorCmd) Run(ctl *Base) error {
+	actor, err := localActor(ctl, u.IRI)
+	if err != nil {
+		return err
+	}
+
+	if u.Name != nil {
+		actor.Name = vocab.DefaultNaturalLanguage(*u.Name)
+	}
+	if u.Summary != nil {
+		actor.Summary = vocab.DefaultNaturalLanguage(*u.Summary)
+	}
+	if u.Icon != nil {
+		if *u.Icon == "" {
+			actor.Icon = nil
+		} else {
+			actor.Icon = &vocab.Image{Type: vocab.ImageType, URL: vocab.IRI(*u.Icon)}
+		}
+	}
+	if len(u.Tags) > 0 {
+		actor.Tag = findTags(ctl, actor, u.Tags)
+	}
+	if u.ManuallyApprovesFollowers != nil {
+		m := new(ap.Metadata)
+		_ = ctl.Storage.LoadMetadata(actor.ID, m)
+		m.ManuallyApprovesFollowers = *u.ManuallyApprovesFollowers
+		if err = ctl.Storage.SaveMetadata(actor.ID, m); err != nil {
+			return errors.Annotatef(err, "unable to save metadata")
+		}
+	}
+	actor.Updated = time.Now().UTC()
+
+	update := ap.WrapObjectInUpdate(actor, actor)
+	if _, err = ctl.Saver(actor, false).ProcessClientActivity(update, *actor, vocab.Outbox.IRI(actor)); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Updated %s\n", actor.ID)
+	return nil
+}
+
+// findTags returns the objects created by the author that have the received names.
+func findTags(ctl *Base, author vocab.Item, names []string) vocab.ItemCollection {
+	tags := make(vocab.ItemCollection, 0)
+	if vocab.IsNil(author) || len(names) == 0 {
+		return tags
+	}
+
+	byName := make(filters.Checks, 0, len(names))
+	for _, name := range names {
+		byName = append(byName, filters.NameIs(name))
+	}
+	objectsCollection := filters.ObjectsType.IRI(vocab.IRI(ctl.Conf.BaseURL))
+	_ = streamCollection(ctl, objectsCollection, func(it vocab.Item) error {
+		return tags.Append(it)
+	}, filters.SameAttributedTo(author.GetLink()), filters.Any(byName...))
+	return tags
+}
+
+type SuspendActorCmd struct {
+	IRI    vocab.IRI     `arg:"" name:"iri" help:"The local actor to suspend."`
+	Reason string        `help:"The reason for the suspension."`
+	For    time.Duration `help:"The duration of a temporary suspension, after which it is lifted automatically."`
+}
+
+// Run marks the actor as suspended in its metadata, and revokes its OAuth2 tokens.
+func (s SuspendActorCmd) Run(ctl *Base) error {
+	actor, err := localActor(ctl, s.IRI)
+	if err != nil {
+		return err
+	}
+	if actor.ID.Equal(ctl.Service.GetLink()) {
+		return errors.Forbiddenf("the service actor can not be suspended")
+	}
+	if err = ctl.canModerate(actor.ID); err != nil {
+		return err
+	}
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor.ID, m)
+	now := time.Now().UTC()
+	if m.IsSuspended(now) {
+		return errors.Newf("the actor %s is already suspended", actor.ID)
+	}
+	m.LiftSuspension()
+	m.Suspended = now
+	m.SuspendReason = s.Reason
+	if s.For > 0 {
+		m.SuspendedUntil = now.Add(s.For)
+	}
+	if err = ctl.Storage.SaveMetadata(actor.ID, m); err != nil {
+		return errors.Annotatef(err, "unable to save metadata")
+	}
+	ctl.suspensions.forget(actor.ID)
+
+	count, errs := revokeTokens(ctl, actor.ID)
+	if len(errs) > 0 {
+		return errors.Annotatef(errors.Join(errs...), "suspended %s, but unable to revoke %d of its tokens", actor.ID, len(errs))
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Suspended %s, revoked %d tokens\n", actor.ID, count)
+	return nil
+}
+
+type UnsuspendActorCmd struct {
+	IRI vocab.IRI `arg:"" name:"iri" help:"The local actor for which to lift the suspension."`
+}
+
+func (u UnsuspendActorCmd) Run(ctl *Base) error {
+	actor, err := localActor(ctl, u.IRI)
+	if err != nil {
+		return err
+	}
+	if err = ctl.canModerate(actor.ID); err != nil {
+		return err
+	}
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor.ID, m)
+	if !m.IsSuspended(time.Now().UTC()) {
+		return errors.Newf("the actor %s is not suspended", actor.ID)
+	}
+	m.LiftSuspension()
+	if err = ctl.Storage.SaveMetadata(actor.ID, m); err != nil {
+		return errors.Annotatef(err, "unable to save metadata")
+	}
+	ctl.suspensions.forget(actor.ID)
+	_, _ = fmt.Fprintf(ctl.out, "Lifted the suspension of %s\n", actor.ID)
+	return nil
+}
+
+type DeleteActorCmd struct {
+	IRI    vocab.IRI `arg:"" name:"iri" help:"The local actor to delete."`
+	Reason string    `help:"The reason for the deletion, added to the Delete activity."`
+	Yes    bool      `help:"Don't ask for confirmation."`
+}
+
+func deleteActorPrompt(iri vocab.IRI) string {
+	return fmt.Sprintf("This will delete %s, its objects will be kept. Type the IRI of the actor to confirm: ", iri)
+}
+
+// Run replaces the actor with a tombstone, notifies its followers, and removes its credentials.
+// The objects and activities of the actor are kept, the "accounts erase" command
+// can be used for removing them as well.
+func (d DeleteActorCmd) Run(ctl *Base) error {
+	actor, err := localActor(ctl, d.IRI)
+	if err != nil {
+		return err
+	}
+	if actor.ID.Equal(ctl.Service.GetLink()) {
+		return errors.Forbiddenf("the service actor can not be deleted")
+	}
+	if !d.Yes {
+		answer, err := readLine(ctl.in, ctl.out, deleteActorPrompt(actor.ID))
+		if err != nil {
+			return err
+		}
+		if !vocab.IRI(answer).Equal(actor.ID) {
+			return errors.Newf("the deletion has not been confirmed")
+		}
+	}
+
+	del := &vocab.Activity{
+		Type:   vocab.DeleteType,
+		Actor:  actor.ID,
+		Object: actor.ID,
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		CC:     vocab.ItemCollection{vocab.Followers.IRI(actor)},
+	}
+	if d.Reason != "" {
+		del.Content = vocab.DefaultNaturalLanguage(d.Reason)
+	}
+	if _, err = ctl.DeleteSaver(actor).ProcessClientActivity(del, *actor, vocab.Outbox.IRI(actor)); err != nil {
+		return err
+	}
+	// The tokens are indexed in the metadata, so they are revoked before removing it
+	_, errs := revokeTokens(ctl, actor.ID)
+	if err = clearMetadata(ctl, actor.ID); err != nil {
+		errs = append(errs, errors.Annotatef(err, "unable to remove metadata"))
+	}
+	if len(errs) > 0 {
+		return errors.Annotatef(errors.Join(errs...), "deleted %s, but unable to remove its credentials", actor.ID)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Deleted %s\n", actor.ID)
+	return nil
+}
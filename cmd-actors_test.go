@@ -0,0 +1,37 @@
+package fedbox
+
+import (
+	"fmt"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestFindTags(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	actor := vocab.IRI(checkBaseURL + "/actors/jdoe")
+	other := vocab.IRI(checkBaseURL + "/actors/other")
+
+	objects := vocab.IRIf(checkBaseURL, "objects")
+	_, _ = db.Save(newOrderedCollection(ctl, objects))
+	add := func(id string, name string, author vocab.IRI) {
+		ob := &vocab.Object{ID: objects.AddPath(id), Type: vocab.NoteType, AttributedTo: author}
+		if name != "" {
+			ob.Name = vocab.DefaultNaturalLanguage(name)
+		}
+		_, _ = db.Save(ob)
+		_ = db.AddTo(objects, ob)
+	}
+	for i := 0; i < exportPageSize; i++ {
+		add(fmt.Sprintf("%d", i), "", actor)
+	}
+	// The tags are past the first page of objects
+	add("tag", "#tag", actor)
+	add("other-tag", "#tag", other)
+	add("unused", "#unused", actor)
+
+	tags := findTags(ctl, actor, []string{"#tag", "#missing"})
+	if len(tags) != 1 || !tags[0].GetLink().Equal(objects.AddPath("tag")) {
+		t.Errorf("findTags() = %v, want [%s]", tags.IRIs(), objects.AddPath("tag"))
+	}
+}
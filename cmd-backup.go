@@ -0,0 +1,361 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~mariusor/storage-all"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+// backupFormatVersion is the version of the layout of the backup archives. It needs to be incremented
+// every time the layout changes in a way that older versions of the restore command can't handle.
+const backupFormatVersion = 1
+
+const (
+	backupStorageDir = "storage"
+	backupManifest   = "manifest.json"
+)
+
+// BackupManifest describes the contents of a backup archive. It's saved as the last entry of the archive,
+// as the checksums of the files are computed while they're being archived.
+type BackupManifest struct {
+	Version  int          `json:"version"`
+	App      string       `json:"app"`
+	Created  time.Time    `json:"created"`
+	Storage  storage.Type `json:"storage"`
+	Hostname string       `json:"hostname"`
+	Files    []BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Path   string      `json:"path"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+}
+
+// fileBackends are the storage backends that keep their data in files we can archive.
+var fileBackends = []storage.Type{config.StorageFS, config.StorageBoltDB, config.StorageBadger, config.StorageSqlite}
+
+func canBackup(typ storage.Type) error {
+	for _, t := range fileBackends {
+		if t == typ {
+			return nil
+		}
+	}
+	return errors.Newf("backups are not supported for the %s storage, please use its own tools", typ)
+}
+
+type BackupCmd struct {
+	To string `arg:"" name:"archive" type:"path" help:"The path of the archive to create."`
+}
+
+// Run archives the storage folder, together with a manifest containing the checksums of the files.
+// When the command is executed by a running server, it is put in maintenance mode, with the storage
+// closed, for the whole duration of the backup. The backends keep writing to their files while they're open,
+// even without new activities (badger compacts its tables and value logs, sqlite checkpoints its WAL file),
+// so this is the only way to get a consistent copy. The activities delivered meanwhile are spooled.
+func (b BackupCmd) Run(ctl *Base) (err error) {
+	if err := canBackup(ctl.Conf.Storage); err != nil {
+		return err
+	}
+	base, err := ctl.Conf.BaseStoragePath()
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(base, b.To); err == nil && !strings.HasPrefix(rel, "..") {
+		return errors.Newf("the archive can not be saved inside the storage path %s", base)
+	}
+
+	out, err := os.OpenFile(b.To, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Annotatef(err, "unable to create archive")
+	}
+	defer out.Close()
+
+	if ctl.pauseStorage != nil {
+		resume := ctl.pauseStorage()
+		defer func() {
+			err = errors.Join(err, resume())
+		}()
+	}
+
+	m, err := writeBackup(out, base, ctl.Conf)
+	if err != nil {
+		_ = os.Remove(b.To)
+		return err
+	}
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Saved %d files, %d bytes, from %s storage to %s\n", len(m.Files), size, m.Storage, b.To)
+	return nil
+}
+
+func writeBackup(w io.Writer, base string, conf config.Options) (*BackupManifest, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	m := BackupManifest{
+		Version:  backupFormatVersion,
+		App:      fmt.Sprintf("%s %s", AppName, AppVersion),
+		Created:  time.Now().UTC(),
+		Storage:  conf.Storage,
+		Hostname: conf.Hostname,
+		Files:    make([]BackupFile, 0),
+	}
+
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == spoolDir || d.Name() == archivesDir) {
+			// The spool can still receive activities while we're in maintenance mode,
+			// and the actor archives can be generated again from the storage
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := backupFile(tw, p, path.Join(backupStorageDir, filepath.ToSlash(rel)), fi)
+		if err != nil {
+			return errors.Annotatef(err, "unable to archive %s", p)
+		}
+		f.Path = filepath.ToSlash(rel)
+		m.Files = append(m.Files, *f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := tar.Header{Name: backupManifest, Mode: 0o600, Size: int64(len(raw)), ModTime: m.Created}
+	if err = tw.WriteHeader(&hdr); err != nil {
+		return nil, err
+	}
+	if _, err = tw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return &m, gz.Close()
+}
+
+func backupFile(tw *tar.Writer, p, name string, fi fs.FileInfo) (*BackupFile, error) {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if err = tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(tw, io.TeeReader(f, h))
+	if err != nil {
+		return nil, err
+	}
+	return &BackupFile{Size: n, Mode: fi.Mode().Perm(), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+type RestoreCmd struct {
+	From       string `arg:"" name:"archive" type:"existingfile" help:"The path of the archive to restore."`
+	VerifyOnly bool   `name:"verify-only" help:"Only check the integrity of the archive, without restoring it."`
+	Force      bool   `help:"Replace the existing storage. Its files are kept in a folder next to it."`
+}
+
+func (r RestoreCmd) Run(ctl *Base) error {
+	if r.VerifyOnly {
+		m, err := readBackup(r.From, "")
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(ctl.out, "Archive is valid: %d files from %s storage, created %s by %s\n",
+			len(m.Files), m.Storage, m.Created.Format(time.RFC3339), m.App)
+		return nil
+	}
+
+	if ctl.pauseWrites != nil {
+		return errors.Conflictf("the storage can not be restored while the server is running")
+	}
+	if err := canBackup(ctl.Conf.Storage); err != nil {
+		return err
+	}
+	base, err := ctl.Conf.BaseStoragePath()
+	if err != nil {
+		return err
+	}
+
+	suffix := time.Now().UTC().Format("20060102150405")
+	tmp := base + ".restore-" + suffix
+	m, err := readBackup(r.From, tmp)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if m.Storage != ctl.Conf.Storage {
+		_ = os.RemoveAll(tmp)
+		return errors.Newf("the archive contains a %s storage, but %s is configured, please use 'storage migrate'", m.Storage, ctl.Conf.Storage)
+	}
+
+	old := ""
+	if entries, _ := os.ReadDir(base); len(entries) > 0 {
+		if !r.Force {
+			_ = os.RemoveAll(tmp)
+			return errors.Conflictf("the storage path %s is not empty, use --force to replace it", base)
+		}
+		old = base + ".pre-restore-" + suffix
+		if err = os.Rename(base, old); err != nil {
+			_ = os.RemoveAll(tmp)
+			return errors.Annotatef(err, "unable to move the existing storage out of the way")
+		}
+		_, _ = fmt.Fprintf(ctl.out, "Moved existing storage to %s\n", old)
+	} else {
+		_ = os.Remove(base)
+	}
+	if err = os.Rename(tmp, base); err != nil {
+		// Put the existing storage back, so the instance is not left without one
+		if old != "" {
+			if rerr := os.Rename(old, base); rerr != nil {
+				return errors.Annotatef(err, "unable to move restored storage to %s, and the existing storage remains in %s", base, old)
+			}
+			_, _ = fmt.Fprintf(ctl.out, "Moved existing storage back to %s\n", base)
+		}
+		return errors.Annotatef(err, "unable to move restored storage to %s, it remains in %s", base, tmp)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Restored %d files to %s\n", len(m.Files), base)
+	return nil
+}
+
+// restoreFile computes the checksum of the current file in the archive, and if dst is not empty, it saves it there.
+func restoreFile(r io.Reader, dst string, mode fs.FileMode) (*BackupFile, error) {
+	var w io.Writer = io.Discard
+	if dst != "" {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return nil, err
+		}
+		defer out.Close()
+		w = out
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupFile{Size: n, Mode: mode, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// readBackup reads the archive, and checks that its files match the checksums in the manifest.
+// If "to" is not empty, the files are extracted there.
+func readBackup(from, to string) (*BackupManifest, error) {
+	f, err := os.Open(from)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid archive")
+	}
+	tr := tar.NewReader(gz)
+
+	var m *BackupManifest
+	found := make(map[string]BackupFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid archive")
+		}
+		if hdr.Name == backupManifest {
+			m = new(BackupManifest)
+			if err = json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, errors.Annotatef(err, "invalid manifest")
+			}
+			continue
+		}
+		rel, ok := strings.CutPrefix(hdr.Name, backupStorageDir+"/")
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		rel = path.Clean(rel)
+		if !fs.ValidPath(rel) {
+			return nil, errors.Newf("invalid path in archive %s", hdr.Name)
+		}
+
+		dst := ""
+		if to != "" {
+			dst = filepath.Join(to, filepath.FromSlash(rel))
+		}
+		got, err := restoreFile(tr, dst, hdr.FileInfo().Mode().Perm())
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read %s from archive", rel)
+		}
+		got.Path = rel
+		found[rel] = *got
+	}
+
+	if m == nil {
+		return nil, errors.Newf("the archive doesn't contain a manifest")
+	}
+	if m.Version > backupFormatVersion {
+		return nil, errors.Newf("the archive format version %d is newer than the supported %d", m.Version, backupFormatVersion)
+	}
+	if len(m.Files) == 0 {
+		return nil, errors.Newf("the archive manifest doesn't list any files")
+	}
+	if len(found) != len(m.Files) {
+		return nil, errors.Newf("the archive contains %d files, but the manifest lists %d", len(found), len(m.Files))
+	}
+	for _, exp := range m.Files {
+		got, ok := found[exp.Path]
+		if !ok {
+			return nil, errors.Newf("missing file %s from archive", exp.Path)
+		}
+		if got.Size != exp.Size || got.SHA256 != exp.SHA256 {
+			return nil, errors.Newf("checksum mismatch for %s", exp.Path)
+		}
+	}
+	return m, nil
+}
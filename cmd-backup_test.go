@@ -0,0 +1,115 @@
+package fedbox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	base := t.TempDir()
+	files := map[string]string{
+		"storage.bdb":           "bolt",
+		"example.com/item.json": `{"id":"https://example.com/item"}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(base, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The spooled activities are not part of the backup
+	if err := os.MkdirAll(filepath.Join(base, spoolDir), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(base, spoolDir, "1.json"), []byte("{}"), 0o600)
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := writeBackup(out, base, config.Options{Storage: config.StorageBoltDB, Hostname: "example.com"})
+	_ = out.Close()
+	if err != nil {
+		t.Fatalf("unable to write backup: %s", err)
+	}
+	if len(m.Files) != len(files) {
+		t.Fatalf("expected %d files in manifest, got %d", len(files), len(m.Files))
+	}
+
+	to := filepath.Join(t.TempDir(), "restored")
+	got, err := readBackup(archive, to)
+	if err != nil {
+		t.Fatalf("unable to read backup: %s", err)
+	}
+	if got.Storage != config.StorageBoltDB || got.Version != backupFormatVersion {
+		t.Errorf("invalid manifest %+v", got)
+	}
+	for name, content := range files {
+		raw, err := os.ReadFile(filepath.Join(to, name))
+		if err != nil {
+			t.Errorf("missing restored file %s: %s", name, err)
+			continue
+		}
+		if string(raw) != content {
+			t.Errorf("invalid content for %s: %q, expected %q", name, raw, content)
+		}
+	}
+
+	raw, _ := os.ReadFile(archive)
+	raw[len(raw)/2] ^= 0xff
+	_ = os.WriteFile(archive, raw, 0o600)
+	if _, err = readBackup(archive, ""); err == nil {
+		t.Errorf("expected error when verifying a corrupted archive")
+	}
+}
+
+func TestBackupCmd_PausesStorage(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "storage.bdb"), []byte("bolt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	paused, resumed := 0, 0
+	ctl := &Base{
+		Conf: config.Options{Storage: config.StorageBoltDB, StoragePath: base},
+		out:  io.Discard,
+		pauseStorage: func() func() error {
+			paused++
+			return func() error {
+				resumed++
+				return nil
+			}
+		},
+	}
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := (BackupCmd{To: archive}).Run(ctl); err != nil {
+		t.Fatalf("BackupCmd.Run() error = %s", err)
+	}
+	if paused != 1 || resumed != 1 {
+		t.Errorf("expected the storage to be paused and resumed once, got %d and %d", paused, resumed)
+	}
+}
+
+func TestReadBackup_EmptyManifest(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeBackup(out, t.TempDir(), config.Options{Storage: config.StorageBoltDB, Hostname: "example.com"})
+	_ = out.Close()
+	if err != nil {
+		t.Fatalf("unable to write backup: %s", err)
+	}
+	if _, err = readBackup(archive, ""); err == nil {
+		t.Errorf("expected error when reading an archive without files")
+	}
+}
@@ -41,7 +41,7 @@ func (b ResetCmd) Run(ctl *Base) error {
 
 type BootstrapCmd struct {
 	KeyType  string `help:"Type of keys to generate: ${keyTypes}" enum:"${keyTypes}" default:"${defaultKeyType}"`
-	Password string `hidden:""`
+	Password string `hidden:"" secret:""`
 }
 
 func (b BootstrapCmd) Run(ctl *Base) error {
@@ -88,10 +88,11 @@ func bootstrap(ctl *Base, service vocab.Item, l lw.Logger, pair *ap.KeyPair, pw
 	l.Infof("Successfully created %s db for storage %s", path, conf.Storage)
 
 	if ctl.Storage == nil {
-		ctl.Storage, err = storage.New(initFns...)
+		db, err := storage.New(initFns...)
 		if err != nil {
 			return http.Annotatef(err, "Unable to initialize %s path for storage %s", path, conf.Storage)
 		}
+		ctl.Storage = withTokenIndex(db, ap.DefaultServiceIRI(conf.BaseURL))
 	}
 	if err = ctl.Storage.Open(); err != nil {
 		return http.Annotatef(err, "Unable to open %s path for storage %s", path, conf.Storage)
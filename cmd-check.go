@@ -0,0 +1,386 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// ProblemKind is the type of inconsistency found by the storage check.
+type ProblemKind string
+
+const (
+	ProblemDanglingMember ProblemKind = "dangling-member"
+	ProblemMissingActor   ProblemKind = "missing-actor"
+	ProblemMissingObject  ProblemKind = "missing-object"
+	ProblemMissingKeys    ProblemKind = "missing-keys"
+	ProblemMissingInbox   ProblemKind = "missing-inbox"
+	ProblemMissingOutbox  ProblemKind = "missing-outbox"
+	ProblemTotalItems     ProblemKind = "wrong-total-items"
+	ProblemWrongLocation  ProblemKind = "wrong-location"
+)
+
+type CheckProblem struct {
+	Kind       ProblemKind `json:"kind"`
+	IRI        vocab.IRI   `json:"iri"`
+	Collection vocab.IRI   `json:"collection,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
+	Repaired   bool        `json:"repaired"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// CheckReport is the result of a storage check.
+type CheckReport struct {
+	Items       int            `json:"items"`
+	Collections int            `json:"collections"`
+	Problems    []CheckProblem `json:"problems"`
+}
+
+type CheckCmd struct {
+	Repair bool   `help:"Try to fix the problems found."`
+	Output string `short:"o" help:"The format of the report: text or json." enum:"text,json" default:"text"`
+}
+
+// checker walks the storage looking for inconsistencies, and optionally repairs them.
+type checker struct {
+	ctl     *Base
+	repair  bool
+	report  CheckReport
+	checked map[vocab.IRI]struct{}
+}
+
+func (c CheckCmd) Run(ctl *Base) error {
+	if c.Repair && ctl.pauseWrites != nil {
+		resume := ctl.pauseWrites()
+		defer resume()
+	}
+
+	ch := checker{ctl: ctl, repair: c.Repair, checked: make(map[vocab.IRI]struct{})}
+	ch.report.Problems = make([]CheckProblem, 0)
+	if err := ch.run(); err != nil {
+		return err
+	}
+
+	if c.Output == "json" {
+		enc := json.NewEncoder(ctl.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ch.report)
+	}
+	printCheckReport(ctl, ch.report)
+	return nil
+}
+
+func printCheckReport(ctl *Base, r CheckReport) {
+	for _, p := range r.Problems {
+		status := ""
+		if p.Repaired {
+			status = " [repaired]"
+		} else if p.Error != "" {
+			status = fmt.Sprintf(" [repair failed: %s]", p.Error)
+		}
+		where := ""
+		if p.Collection != "" {
+			where = fmt.Sprintf(" in %s", p.Collection)
+		}
+		_, _ = fmt.Fprintf(ctl.out, "%-18s %s%s: %s%s\n", p.Kind, p.IRI, where, p.Detail, status)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Checked %d items and %d collections, found %d problems\n", r.Items, r.Collections, len(r.Problems))
+}
+
+// problem records an inconsistency, and if we're in repair mode, it tries to fix it using the fix function.
+// It returns true if the problem has been repaired.
+func (c *checker) problem(p CheckProblem, fix func() error) bool {
+	if c.repair && fix != nil {
+		if err := fix(); err != nil {
+			p.Error = err.Error()
+		} else {
+			p.Repaired = true
+		}
+	}
+	c.report.Problems = append(c.report.Problems, p)
+	return p.Repaired
+}
+
+func (c *checker) isLocal(iri vocab.IRI) bool {
+	return iri.Contains(vocab.IRI(c.ctl.Conf.BaseURL), false)
+}
+
+func (c *checker) run() error {
+	ctl := c.ctl
+	baseURL := vocab.IRI(ctl.Conf.BaseURL)
+
+	c.checkItem(ctl.Service)
+	for _, col := range streamCollections {
+		colIRI := vocab.IRIf(baseURL, col)
+		c.checkCollection(colIRI)
+		if err := streamCollection(ctl, colIRI, c.checkItem); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	c.checkCollection(AuditIRI(ctl.Service))
+	return nil
+}
+
+func (c *checker) checkItem(it vocab.Item) error {
+	c.report.Items++
+	switch {
+	case vocab.ActivityTypes.Match(it.GetType()) || vocab.IntransitiveActivityTypes.Match(it.GetType()):
+		c.checkActivity(it)
+	case vocab.ActorTypes.Match(it.GetType()):
+		c.checkActor(it)
+		for _, col := range getActorCollections(it) {
+			c.checkCollection(col)
+		}
+		return nil
+	}
+	if !vocab.IsCollection(it) {
+		for _, col := range getObjectCollections(it) {
+			c.checkCollection(col)
+		}
+	}
+	return nil
+}
+
+// checkCollection verifies that the members of the collection exist and are stored at their IRI,
+// and that the totalItems property matches the number of members.
+func (c *checker) checkCollection(iri vocab.IRI) {
+	if _, ok := c.checked[iri]; ok {
+		return
+	}
+	c.checked[iri] = struct{}{}
+
+	it, err := c.ctl.Storage.Load(iri)
+	if err != nil {
+		// Missing collections are handled by "storage fix-collections"
+		return
+	}
+	c.report.Collections++
+
+	// The storage can return only the first page of the collection when loading it,
+	// so we count the members by going through all of them
+	count := 0
+	_ = streamCollection(c.ctl, iri, func(member vocab.Item) error {
+		if !c.checkMember(iri, member) {
+			count++
+		}
+		return nil
+	})
+
+	total, ok := totalItems(it)
+	if ok && int(total) != count {
+		c.problem(CheckProblem{
+			Kind:   ProblemTotalItems,
+			IRI:    iri,
+			Detail: fmt.Sprintf("totalItems is %d, but the collection has %d items", total, count),
+		}, func() error {
+			return c.fixTotalItems(iri, count)
+		})
+	}
+}
+
+// totalItems returns the value of the totalItems property of the collection, if it has one.
+func totalItems(it vocab.Item) (uint, bool) {
+	var total uint
+	switch it.GetType() {
+	case vocab.OrderedCollectionType:
+		_ = vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+			total = col.TotalItems
+			return nil
+		})
+	case vocab.CollectionType:
+		_ = vocab.OnCollection(it, func(col *vocab.Collection) error {
+			total = col.TotalItems
+			return nil
+		})
+	default:
+		return 0, false
+	}
+	return total, true
+}
+
+// checkMember verifies that the member of the collection exists, and returns true if it has been removed from it.
+func (c *checker) checkMember(colIRI vocab.IRI, member vocab.Item) bool {
+	if !c.isLocal(member.GetLink()) {
+		return false
+	}
+	ob, err := c.ctl.Storage.Load(member.GetLink())
+	if err == nil && !vocab.IsNil(ob) {
+		if !ob.GetLink().Equal(member.GetLink()) {
+			c.problem(CheckProblem{
+				Kind:       ProblemWrongLocation,
+				IRI:        member.GetLink(),
+				Collection: colIRI,
+				Detail:     fmt.Sprintf("the stored object has the ID %s", ob.GetLink()),
+			}, func() error {
+				return c.relocate(colIRI, member.GetLink(), ob)
+			})
+		}
+		return false
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return false
+	}
+	if !vocab.IsIRI(member) {
+		// The collection returned the object, but it can't be loaded from its IRI,
+		// so it's saved at a different location
+		c.problem(CheckProblem{
+			Kind:       ProblemWrongLocation,
+			IRI:        member.GetLink(),
+			Collection: colIRI,
+			Detail:     "the object can't be loaded from its IRI",
+		}, func() error {
+			_, err := c.ctl.Storage.Save(member)
+			return err
+		})
+		return false
+	}
+	return c.problem(CheckProblem{
+		Kind:       ProblemDanglingMember,
+		IRI:        member.GetLink(),
+		Collection: colIRI,
+		Detail:     "the member doesn't exist",
+	}, func() error {
+		return c.ctl.Storage.RemoveFrom(colIRI, member.GetLink())
+	})
+}
+
+// relocate saves the object found at the "at" IRI under its own ID, and replaces it in the collection.
+func (c *checker) relocate(colIRI, at vocab.IRI, ob vocab.Item) error {
+	if _, err := c.ctl.Storage.Save(ob); err != nil {
+		return err
+	}
+	if err := c.ctl.Storage.RemoveFrom(colIRI, at); err != nil {
+		return err
+	}
+	return c.ctl.Storage.AddTo(colIRI, ob.GetLink())
+}
+
+// fixTotalItems sets the totalItems property of the collection, leaving the rest of its properties unchanged.
+// We load the collection again, as its members might have changed while repairing them.
+func (c *checker) fixTotalItems(iri vocab.IRI, count int) error {
+	it, err := c.ctl.Storage.Load(iri)
+	if err != nil {
+		return err
+	}
+	switch it.GetType() {
+	case vocab.OrderedCollectionType:
+		err = vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+			col.TotalItems = uint(count)
+			return nil
+		})
+	case vocab.CollectionType:
+		err = vocab.OnCollection(it, func(col *vocab.Collection) error {
+			col.TotalItems = uint(count)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.ctl.Storage.Save(it)
+	return err
+}
+
+// checkActivity verifies that the local actor and object of the activity exist.
+func (c *checker) checkActivity(it vocab.Item) {
+	_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+		if c.isMissing(act.Actor) {
+			c.problem(CheckProblem{Kind: ProblemMissingActor, IRI: act.ID, Detail: fmt.Sprintf("the actor %s doesn't exist", act.Actor.GetLink())}, func() error {
+				return c.restore(act.Actor)
+			})
+		}
+		return nil
+	})
+	if !vocab.ActivityTypes.Match(it.GetType()) {
+		return
+	}
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		// The object of a Delete is expected to be gone
+		if act.Type == vocab.DeleteType {
+			return nil
+		}
+		if c.isMissing(act.Object) {
+			c.problem(CheckProblem{Kind: ProblemMissingObject, IRI: act.ID, Detail: fmt.Sprintf("the object %s doesn't exist", act.Object.GetLink())}, func() error {
+				return c.restore(act.Object)
+			})
+		}
+		return nil
+	})
+}
+
+// restore saves the missing item referenced by an activity: the item itself, if the activity embeds it,
+// or a tombstone in its place otherwise, the same as if it had been deleted.
+func (c *checker) restore(it vocab.Item) error {
+	if !vocab.IsIRI(it) {
+		_, err := c.ctl.Storage.Save(it)
+		return err
+	}
+	_, err := c.ctl.Storage.Save(&vocab.Tombstone{
+		ID:      it.GetLink(),
+		Type:    vocab.TombstoneType,
+		Deleted: time.Now().UTC(),
+	})
+	return err
+}
+
+func (c *checker) isMissing(it vocab.Item) bool {
+	if vocab.IsNil(it) || vocab.IsItemCollection(it) || !c.isLocal(it.GetLink()) {
+		return false
+	}
+	_, err := c.ctl.Storage.Load(it.GetLink())
+	return errors.IsNotFound(err)
+}
+
+// checkActor verifies that the local actor has keys, an inbox and an outbox.
+func (c *checker) checkActor(it vocab.Item) {
+	if !c.isLocal(it.GetLink()) {
+		return
+	}
+	_ = vocab.OnActor(it, func(act *vocab.Actor) error {
+		m := new(ap.Metadata)
+		_ = c.ctl.Storage.LoadMetadata(act.ID, m)
+		if len(act.PublicKey.PublicKeyPem) == 0 || len(m.PrivateKey) == 0 {
+			c.problem(CheckProblem{Kind: ProblemMissingKeys, IRI: act.ID, Detail: "the actor doesn't have a key pair"}, func() error {
+				return c.fixKeys(act)
+			})
+		}
+		if vocab.IsNil(act.Inbox) {
+			c.problem(CheckProblem{Kind: ProblemMissingInbox, IRI: act.ID, Detail: "the actor doesn't have an inbox"}, func() error {
+				return c.fixStream(act, vocab.Inbox)
+			})
+		}
+		if vocab.IsNil(act.Outbox) {
+			c.problem(CheckProblem{Kind: ProblemMissingOutbox, IRI: act.ID, Detail: "the actor doesn't have an outbox"}, func() error {
+				return c.fixStream(act, vocab.Outbox)
+			})
+		}
+		return nil
+	})
+}
+
+// fixKeys generates new keys for the actor, of the same types we generate for new actors.
+func (c *checker) fixKeys(act *vocab.Actor) error {
+	if err := ap.KeyGenerator(c.ctl.Storage, actorKeyTypes(c.ctl.Conf)...)(act); err != nil {
+		return err
+	}
+	_, err := c.ctl.Storage.Save(act)
+	return err
+}
+
+func (c *checker) fixStream(act *vocab.Actor, typ vocab.CollectionPath) error {
+	iri := typ.IRI(act)
+	switch typ {
+	case vocab.Inbox:
+		act.Inbox = iri
+	case vocab.Outbox:
+		act.Outbox = iri
+	}
+	if _, err := c.ctl.Storage.Save(act); err != nil {
+		return err
+	}
+	return tryCreateCollection(c.ctl, iri)
+}
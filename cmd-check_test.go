@@ -0,0 +1,300 @@
+package fedbox
+
+import (
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/jsonld"
+	"github.com/openshift/osin"
+)
+
+// memStorage is a minimal in memory storage for the tests of the commands, which applies the filters
+// only to the collections. Like the storage backends, it encodes the metadata as JSON-LD.
+type memStorage struct {
+	storage.FullStorage
+	items       map[vocab.IRI]vocab.Item
+	collections map[vocab.IRI]*vocab.OrderedCollection
+	metadata    map[vocab.IRI][]byte
+	clients     map[string]osin.Client
+	authorize   map[string]*osin.AuthorizeData
+	access      map[string]*osin.AccessData
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{
+		items:       make(map[vocab.IRI]vocab.Item),
+		collections: make(map[vocab.IRI]*vocab.OrderedCollection),
+		metadata:    make(map[vocab.IRI][]byte),
+		clients:     make(map[string]osin.Client),
+		authorize:   make(map[string]*osin.AuthorizeData),
+		access:      make(map[string]*osin.AccessData),
+	}
+}
+
+func (s *memStorage) Load(iri vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	if col, ok := s.collections[iri]; ok {
+		res := *col
+		res.OrderedItems = make(vocab.ItemCollection, 0, len(col.OrderedItems))
+		for _, member := range col.OrderedItems {
+			if it, ok := s.items[member.GetLink()]; ok {
+				member = it
+			}
+			res.OrderedItems = append(res.OrderedItems, member)
+		}
+		return filters.Checks(ff).Run(&res), nil
+	}
+	if it, ok := s.items[iri]; ok {
+		return it, nil
+	}
+	return nil, errors.NotFoundf("%s not found", iri)
+}
+
+func (s *memStorage) Save(it vocab.Item) (vocab.Item, error) {
+	if it.GetType() != vocab.OrderedCollectionType {
+		s.items[it.GetLink()] = it
+		return it, nil
+	}
+	err := vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+		c := *col
+		c.OrderedItems = make(vocab.ItemCollection, 0, len(col.OrderedItems))
+		for _, member := range col.OrderedItems {
+			c.OrderedItems = append(c.OrderedItems, member.GetLink())
+		}
+		s.collections[col.ID] = &c
+		return nil
+	})
+	return it, err
+}
+
+func (s *memStorage) Delete(it vocab.Item) error {
+	delete(s.items, it.GetLink())
+	delete(s.collections, it.GetLink())
+	return nil
+}
+
+func (s *memStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	_, err := s.Save(col)
+	return col, err
+}
+
+func (s *memStorage) AddTo(iri vocab.IRI, items ...vocab.Item) error {
+	col, ok := s.collections[iri]
+	if !ok {
+		return errors.NotFoundf("%s not found", iri)
+	}
+	for _, it := range items {
+		col.OrderedItems = append(col.OrderedItems, it.GetLink())
+	}
+	return nil
+}
+
+func (s *memStorage) RemoveFrom(iri vocab.IRI, items ...vocab.Item) error {
+	col, ok := s.collections[iri]
+	if !ok {
+		return errors.NotFoundf("%s not found", iri)
+	}
+	for _, it := range items {
+		col.OrderedItems.Remove(it.GetLink())
+	}
+	return nil
+}
+
+func (s *memStorage) LoadMetadata(iri vocab.IRI, m any) error {
+	raw, ok := s.metadata[iri]
+	if !ok {
+		return errors.NotFoundf("metadata for %s not found", iri)
+	}
+	return jsonld.Unmarshal(raw, m)
+}
+
+func (s *memStorage) SaveMetadata(iri vocab.IRI, m any) error {
+	raw, err := jsonld.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.metadata[iri] = raw
+	return nil
+}
+
+func (s *memStorage) ListClients() ([]osin.Client, error) {
+	clients := make([]osin.Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func (s *memStorage) GetClient(id string) (osin.Client, error) {
+	if c, ok := s.clients[id]; ok {
+		return c, nil
+	}
+	return nil, osin.ErrNotFound
+}
+
+func (s *memStorage) CreateClient(c osin.Client) error {
+	s.clients[c.GetId()] = c
+	return nil
+}
+
+func (s *memStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	s.authorize[data.Code] = data
+	return nil
+}
+
+func (s *memStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	if data, ok := s.authorize[code]; ok {
+		return data, nil
+	}
+	return nil, osin.ErrNotFound
+}
+
+func (s *memStorage) RemoveAuthorize(code string) error {
+	delete(s.authorize, code)
+	return nil
+}
+
+func (s *memStorage) SaveAccess(data *osin.AccessData) error {
+	s.access[data.AccessToken] = data
+	return nil
+}
+
+func (s *memStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	if data, ok := s.access[token]; ok {
+		return data, nil
+	}
+	return nil, osin.ErrNotFound
+}
+
+func (s *memStorage) RemoveAccess(token string) error {
+	delete(s.access, token)
+	return nil
+}
+
+func (s *memStorage) RemoveRefresh(string) error {
+	return nil
+}
+
+const checkBaseURL = "https://example.com"
+
+func checkTestBase(t *testing.T) (*Base, *memStorage) {
+	t.Helper()
+
+	db := newMemStorage()
+	service := vocab.Actor{ID: checkBaseURL, Type: vocab.ServiceType}
+	ctl := &Base{
+		Conf:    config.Options{BaseURL: checkBaseURL},
+		Logger:  lw.Dev(),
+		Service: service,
+		Storage: db,
+	}
+	for _, col := range streamCollections {
+		_, _ = db.Save(newOrderedCollection(ctl, vocab.IRIf(checkBaseURL, col)))
+	}
+	return ctl, db
+}
+
+func runCheck(t *testing.T, ctl *Base, repair bool) CheckReport {
+	t.Helper()
+
+	ch := checker{ctl: ctl, repair: repair, checked: make(map[vocab.IRI]struct{})}
+	ch.report.Problems = make([]CheckProblem, 0)
+	if err := ch.run(); err != nil {
+		t.Fatalf("checker.run() error = %s", err)
+	}
+	return ch.report
+}
+
+func findProblem(r CheckReport, kind ProblemKind, iri vocab.IRI) *CheckProblem {
+	for _, p := range r.Problems {
+		if p.Kind == kind && p.IRI.Equal(iri) {
+			return &p
+		}
+	}
+	return nil
+}
+
+func TestChecker_TotalItems(t *testing.T) {
+	ctl, db := checkTestBase(t)
+
+	note := &vocab.Object{ID: checkBaseURL + "/objects/1", Type: vocab.NoteType}
+	_, _ = db.Save(note)
+	_ = db.AddTo(vocab.IRIf(checkBaseURL, filters.ObjectsType), note.ID)
+
+	likes := vocab.Likes.IRI(note)
+	col := newOrderedCollection(ctl, likes)
+	col.Name = vocab.DefaultNaturalLanguage("likes of the note")
+	col.TotalItems = 5
+	_, _ = db.Save(col)
+	_ = db.AddTo(likes, note.ID)
+
+	r := runCheck(t, ctl, true)
+	p := findProblem(r, ProblemTotalItems, likes)
+	if p == nil || !p.Repaired {
+		t.Fatalf("expected a repaired %s problem for %s, got %+v", ProblemTotalItems, likes, r.Problems)
+	}
+	fixed := db.collections[likes]
+	if fixed.TotalItems != 1 {
+		t.Errorf("totalItems = %d, expected 1", fixed.TotalItems)
+	}
+	if fixed.Name.First().String() != "likes of the note" {
+		t.Errorf("the other properties of the collection have been changed: %+v", fixed)
+	}
+	if len(fixed.OrderedItems) != 1 {
+		t.Errorf("the members of the collection have been changed: %v", fixed.OrderedItems)
+	}
+}
+
+func TestChecker_DanglingAndMissing(t *testing.T) {
+	ctl, db := checkTestBase(t)
+
+	actor := &vocab.Actor{ID: checkBaseURL + "/actors/1", Type: vocab.PersonType}
+	actor.Inbox = vocab.Inbox.IRI(actor)
+	actor.Outbox = vocab.Outbox.IRI(actor)
+	_, _ = db.Save(actor)
+	_ = db.AddTo(vocab.IRIf(checkBaseURL, filters.ActorsType), actor.ID)
+
+	missing := vocab.IRI(checkBaseURL + "/objects/missing")
+	act := &vocab.Activity{ID: checkBaseURL + "/activities/1", Type: vocab.LikeType, Actor: actor.ID, Object: missing}
+	_, _ = db.Save(act)
+	activities := vocab.IRIf(checkBaseURL, filters.ActivitiesType)
+	_ = db.AddTo(activities, act.ID)
+	dangling := vocab.IRI(checkBaseURL + "/activities/dangling")
+	_ = db.AddTo(activities, dangling)
+
+	r := runCheck(t, ctl, false)
+	for _, p := range r.Problems {
+		if p.Repaired {
+			t.Errorf("problem %s for %s has been repaired without --repair", p.Kind, p.IRI)
+		}
+	}
+	if findProblem(r, ProblemMissingKeys, actor.ID) == nil {
+		t.Errorf("expected a %s problem for %s", ProblemMissingKeys, actor.ID)
+	}
+
+	r = runCheck(t, ctl, true)
+	if p := findProblem(r, ProblemDanglingMember, dangling); p == nil || !p.Repaired {
+		t.Errorf("expected a repaired %s problem for %s, got %+v", ProblemDanglingMember, dangling, p)
+	}
+	if db.collections[activities].OrderedItems.Contains(dangling) {
+		t.Errorf("the dangling member %s is still in %s", dangling, activities)
+	}
+	if p := findProblem(r, ProblemMissingObject, act.ID); p == nil || !p.Repaired {
+		t.Errorf("expected a repaired %s problem for %s, got %+v", ProblemMissingObject, act.ID, p)
+	}
+	if ob, ok := db.items[missing]; !ok || ob.GetType() != vocab.TombstoneType {
+		t.Errorf("expected a tombstone for the missing object %s, got %v", missing, ob)
+	}
+	if p := findProblem(r, ProblemMissingKeys, actor.ID); p == nil || !p.Repaired {
+		t.Fatalf("expected a repaired %s problem for %s, got %+v", ProblemMissingKeys, actor.ID, p)
+	}
+	m := new(ap.Metadata)
+	if err := db.LoadMetadata(actor.ID, m); err != nil || len(m.PrivateKey) == 0 {
+		t.Errorf("expected a private key for %s: %v", actor.ID, err)
+	}
+}
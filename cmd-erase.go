@@ -0,0 +1,311 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
+)
+
+type EraseCmd struct {
+	IRI    vocab.IRI `arg:"" name:"iri" help:"The local actor to erase."`
+	Reason string    `help:"The reason for the erasure, added to the Delete activities."`
+	Yes    bool      `help:"Don't ask for confirmation."`
+	Output string    `short:"o" help:"The format of the report: text or json." enum:"text,json" default:"text"`
+}
+
+// EraseReport lists what has been removed for an actor.
+type EraseReport struct {
+	Actor       vocab.IRI  `json:"actor"`
+	Tombstoned  vocab.IRIs `json:"tombstoned"`
+	Deleted     vocab.IRIs `json:"deleted"`
+	Activities  int        `json:"activities"`
+	Collections vocab.IRIs `json:"collections"`
+	Credentials bool       `json:"credentials"`
+	Tokens      int        `json:"tokens"`
+	Errors      []string   `json:"errors,omitempty"`
+}
+
+func erasePrompt(iri vocab.IRI) string {
+	return fmt.Sprintf("This will remove %s and everything it authored. Type the IRI of the actor to confirm: ", iri)
+}
+
+// Run removes everything that belongs to a local actor. The public objects are replaced by tombstones and
+// their deletion is disseminated to the remote servers, the private ones are removed from the storage.
+func (e EraseCmd) Run(ctl *Base) error {
+	actor, err := ap.LoadActor(ctl.Storage, e.IRI)
+	if err != nil || actor.ID == "" {
+		return errors.NotFoundf("unable to load actor %s", e.IRI)
+	}
+	if !actor.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+		return errors.Newf("the actor %s is not local", actor.ID)
+	}
+	if actor.ID.Equal(ctl.Service.GetLink()) {
+		return errors.Forbiddenf("the service actor can not be erased")
+	}
+	if !e.Yes {
+		answer, err := readLine(ctl.in, ctl.out, erasePrompt(actor.ID))
+		if err != nil {
+			return err
+		}
+		if !vocab.IRI(answer).Equal(actor.ID) {
+			return errors.Newf("the erasure has not been confirmed")
+		}
+	}
+
+	r := EraseReport{Actor: actor.ID, Tombstoned: vocab.IRIs{}, Deleted: vocab.IRIs{}, Collections: vocab.IRIs{}}
+	e.eraseObjects(ctl, &actor, &r)
+	// The Delete for the actor needs to be signed with its key, and sent to its followers,
+	// so it has to happen before removing them
+	e.disseminate(ctl, &actor, &actor, &r)
+	e.eraseActivities(ctl, &actor, &r)
+	e.eraseCollections(ctl, &actor, &r)
+	e.eraseCredentials(ctl, &actor, &r)
+
+	if e.Output == "json" {
+		enc := json.NewEncoder(ctl.out)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(r); err != nil {
+			return err
+		}
+	} else {
+		printEraseReport(ctl, r)
+	}
+	if len(r.Errors) > 0 {
+		return errors.Newf("the actor %s has not been erased completely, %d errors", actor.ID, len(r.Errors))
+	}
+	return nil
+}
+
+func (r *EraseReport) failed(err error, s string, par ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf("%s: %s", fmt.Sprintf(s, par...), err))
+}
+
+func isPublic(it vocab.Item) bool {
+	public := false
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		public = ob.Recipients().Contains(vocab.PublicNS)
+		return nil
+	})
+	return public
+}
+
+// eraseObjects tombstones the public objects attributed to the actor, and removes the rest of them.
+func (e EraseCmd) eraseObjects(ctl *Base, actor *vocab.Actor, r *EraseReport) {
+	err := pruneCollection(ctl, filters.ObjectsType.IRI(ctl.Service), func(ob vocab.Item) bool {
+		if vocab.IsNil(ob) || ob.GetType() == vocab.TombstoneType {
+			return false
+		}
+		if isPublic(ob) {
+			e.disseminate(ctl, actor, ob, r)
+			return false
+		}
+		if err := ctl.Storage.Delete(ob); err != nil {
+			r.failed(err, "unable to delete %s", ob.GetLink())
+			return false
+		}
+		r.Deleted = append(r.Deleted, ob.GetLink())
+		return true
+	}, filters.SameAttributedTo(actor.ID))
+	if err != nil && !errors.IsNotFound(err) {
+		r.failed(err, "unable to load objects")
+	}
+}
+
+// pruneCollection loads the items of the collection matching the checks in pages, and calls fn for each of them,
+// until nothing is left. The fn function returns true if it removed the item from the collection.
+// As the removed items can't be used for the pagination, the next page starts after the last item
+// of the current one which is still in the collection.
+func pruneCollection(ctl *Base, iri vocab.IRI, fn func(vocab.Item) bool, checks ...filters.Check) error {
+	var last vocab.IRI
+	seen := make(map[vocab.IRI]struct{})
+	for {
+		page := append(filters.Checks{}, checks...)
+		if last != "" {
+			page = append(page, filters.After(filters.SameID(last)))
+		}
+		page = append(page, filters.WithMaxCount(exportPageSize))
+
+		items, err := dumpAll(ctl, iri, page...)
+		if err != nil {
+			return err
+		}
+		prev, removed := last, false
+		for _, it := range items {
+			if vocab.IsNil(it) {
+				continue
+			}
+			if _, ok := seen[it.GetLink()]; ok {
+				// The item is still returned after having been removed, so we move past it
+				last = it.GetLink()
+				continue
+			}
+			seen[it.GetLink()] = struct{}{}
+			if fn(it) {
+				removed = true
+			} else {
+				last = it.GetLink()
+			}
+		}
+		if len(items) < exportPageSize || (!removed && last == prev) {
+			return nil
+		}
+	}
+}
+
+// disseminate processes a Delete activity of the actor for the object, which replaces it with a tombstone
+// and notifies the servers of its recipients.
+func (e EraseCmd) disseminate(ctl *Base, actor *vocab.Actor, ob vocab.Item, r *EraseReport) {
+	d := &vocab.Activity{Type: vocab.DeleteType, Actor: actor.ID, Object: ob.GetLink()}
+	_ = vocab.OnObject(ob, func(o *vocab.Object) error {
+		d.To, d.CC, d.Bto, d.BCC = o.To, o.CC, o.Bto, o.BCC
+		return nil
+	})
+	if vocab.ActorTypes.Match(ob.GetType()) {
+		d.To = vocab.ItemCollection{vocab.PublicNS}
+		d.CC = vocab.ItemCollection{vocab.Followers.IRI(actor)}
+	}
+	if e.Reason != "" {
+		d.Content = vocab.DefaultNaturalLanguage(e.Reason)
+	}
+	if _, err := ctl.DeleteSaver(actor).ProcessClientActivity(d, *actor, vocab.Outbox.IRI(actor)); err != nil {
+		r.failed(err, "unable to delete %s", ob.GetLink())
+		return
+	}
+	r.Tombstoned = append(r.Tombstoned, ob.GetLink())
+}
+
+// eraseActivities removes the activities of the actor, with the exception of the Delete ones, which remote servers
+// might still need to load.
+func (e EraseCmd) eraseActivities(ctl *Base, actor *vocab.Actor, r *EraseReport) {
+	err := pruneCollection(ctl, filters.ActivitiesType.IRI(ctl.Service), func(act vocab.Item) bool {
+		if vocab.IsNil(act) || act.GetType() == vocab.DeleteType {
+			return false
+		}
+		if err := ctl.Storage.Delete(act); err != nil {
+			r.failed(err, "unable to delete %s", act.GetLink())
+			return false
+		}
+		r.Activities++
+		return true
+	}, filters.Actor(filters.SameID(actor.ID)))
+	if err != nil && !errors.IsNotFound(err) {
+		r.failed(err, "unable to load activities")
+	}
+}
+
+func (e EraseCmd) eraseCollections(ctl *Base, actor *vocab.Actor, r *EraseReport) {
+	for _, iri := range getActorCollections(actor) {
+		col, err := ctl.Storage.Load(iri)
+		if err != nil {
+			continue
+		}
+		if err = ctl.Storage.Delete(col); err != nil {
+			r.failed(err, "unable to delete collection %s", iri)
+			continue
+		}
+		r.Collections = append(r.Collections, iri)
+	}
+}
+
+// eraseCredentials removes the metadata of the actor, which holds its password, keys and SSH keys,
+// and the OAuth2 tokens issued to it.
+// The tokens are indexed in the metadata, so they are revoked before removing it.
+func (e EraseCmd) eraseCredentials(ctl *Base, actor *vocab.Actor, r *EraseReport) {
+	count, errs := revokeTokens(ctl, actor.ID)
+	r.Tokens = count
+	for _, err := range errs {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	if err := clearMetadata(ctl, actor.ID); err != nil {
+		r.failed(err, "unable to remove metadata")
+	} else {
+		r.Credentials = true
+	}
+}
+
+// clearMetadata removes the metadata of the actor, keeping only the index of the tokens that could not be revoked,
+// so a later attempt can still find them.
+func clearMetadata(ctl *Base, actor vocab.IRI) error {
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor, m)
+	return ctl.Storage.SaveMetadata(actor, &ap.Metadata{Authorizations: m.Authorizations, AccessTokens: m.AccessTokens})
+}
+
+// revokeTokens removes the OAuth2 authorizations and access tokens issued to the actor, which are indexed in its metadata.
+// The ones that can't be removed are kept in the index.
+func revokeTokens(ctl *Base, actor vocab.IRI) (int, []error) {
+	m := new(ap.Metadata)
+	if err := ctl.Storage.LoadMetadata(actor, m); err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, []error{errors.Annotatef(err, "unable to load the tokens of %s", actor)}
+	}
+
+	count := 0
+	errs := make([]error, 0)
+	authorizations, accesses := actorTokens(ctl.Storage, m)
+	m.Authorizations = m.Authorizations[:0]
+	m.AccessTokens = m.AccessTokens[:0]
+	for _, a := range authorizations {
+		if err := ctl.Storage.RemoveAuthorize(a.Code); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to remove authorization"))
+			m.Authorizations = append(m.Authorizations, a.Code)
+			continue
+		}
+		count++
+	}
+	for _, a := range accesses {
+		if a.RefreshToken != "" {
+			if err := ctl.Storage.RemoveRefresh(a.RefreshToken); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to remove refresh token"))
+			}
+		}
+		if err := ctl.Storage.RemoveAccess(a.AccessToken); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to remove access token"))
+			m.AccessTokens = append(m.AccessTokens, a.AccessToken)
+			continue
+		}
+		count++
+	}
+	// The storage might have updated the index while removing the tokens, so we save only our changes to it
+	saved := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor, saved)
+	saved.Authorizations = m.Authorizations
+	saved.AccessTokens = m.AccessTokens
+	if err := ctl.Storage.SaveMetadata(actor, saved); err != nil {
+		errs = append(errs, errors.Annotatef(err, "unable to update the tokens of %s", actor))
+	}
+	return count, errs
+}
+
+func printEraseReport(ctl *Base, r EraseReport) {
+	_, _ = fmt.Fprintf(ctl.out, "Erased %s\n", r.Actor)
+	_, _ = fmt.Fprintf(ctl.out, "Tombstoned:  %d\n", len(r.Tombstoned))
+	for _, iri := range r.Tombstoned {
+		_, _ = fmt.Fprintf(ctl.out, "  %s\n", iri)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Deleted:     %d\n", len(r.Deleted))
+	for _, iri := range r.Deleted {
+		_, _ = fmt.Fprintf(ctl.out, "  %s\n", iri)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Activities:  %d\n", r.Activities)
+	_, _ = fmt.Fprintf(ctl.out, "Collections: %d\n", len(r.Collections))
+	_, _ = fmt.Fprintf(ctl.out, "Credentials: %s\n", yesNo(r.Credentials))
+	_, _ = fmt.Fprintf(ctl.out, "Tokens:      %d\n", r.Tokens)
+	for _, e := range r.Errors {
+		_, _ = fmt.Fprintf(ctl.err, "Error: %s\n", e)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
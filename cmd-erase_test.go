@@ -0,0 +1,103 @@
+package fedbox
+
+import (
+	"io"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
+	"github.com/openshift/osin"
+)
+
+func TestPruneCollection(t *testing.T) {
+	ctl, db := checkTestBase(t)
+
+	objects := vocab.IRIf(checkBaseURL, filters.ObjectsType)
+	for _, id := range []vocab.IRI{"1", "2", "3"} {
+		ob := &vocab.Object{ID: objects.AddPath(string(id)), Type: vocab.NoteType}
+		_, _ = db.Save(ob)
+		_ = db.AddTo(objects, ob.ID)
+	}
+
+	calls := make(map[vocab.IRI]int)
+	err := pruneCollection(ctl, objects, func(it vocab.Item) bool {
+		calls[it.GetLink()]++
+		if it.GetLink().Equal(objects.AddPath("2")) {
+			return false
+		}
+		_ = db.RemoveFrom(objects, it.GetLink())
+		return true
+	})
+	if err != nil {
+		t.Fatalf("pruneCollection() error = %s", err)
+	}
+	for iri, n := range calls {
+		if n != 1 {
+			t.Errorf("%s has been pruned %d times", iri, n)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("pruned %d items, expected 3", len(calls))
+	}
+	if left := db.collections[objects].OrderedItems; len(left) != 1 || !left[0].GetLink().Equal(objects.AddPath("2")) {
+		t.Errorf("expected only the kept item to be left, got %v", left)
+	}
+}
+
+// keepAccessStorage refuses to remove the access tokens.
+type keepAccessStorage struct {
+	*memStorage
+}
+
+func (s keepAccessStorage) RemoveAccess(string) error {
+	return errors.Newf("unable to remove access token")
+}
+
+func tokensTestBase(t *testing.T) (*Base, *memStorage, *vocab.Actor) {
+	t.Helper()
+
+	ctl, db := checkTestBase(t)
+	ctl.out = io.Discard
+	actor := &vocab.Actor{ID: checkBaseURL + "/actors/jdoe", Type: vocab.PersonType}
+	_, _ = db.Save(actor)
+	_ = db.SaveMetadata(actor.ID, &ap.Metadata{Pw: []byte("secret")})
+
+	ctl.Storage = withTokenIndex(db, ctl.Service.ID)
+	_ = ctl.Storage.SaveAuthorize(&osin.AuthorizeData{Code: "code", UserData: actor.ID})
+	_ = ctl.Storage.SaveAccess(&osin.AccessData{AccessToken: "token", UserData: actor.ID})
+	return ctl, db, actor
+}
+
+func TestEraseCmd_eraseCredentials(t *testing.T) {
+	ctl, db, actor := tokensTestBase(t)
+
+	r := EraseReport{}
+	EraseCmd{}.eraseCredentials(ctl, actor, &r)
+	if len(r.Errors) > 0 || !r.Credentials {
+		t.Fatalf("eraseCredentials() errors = %v", r.Errors)
+	}
+	if r.Tokens != 2 || len(db.access) > 0 || len(db.authorize) > 0 {
+		t.Errorf("revoked %d tokens, %d access tokens and %d authorizations are left", r.Tokens, len(db.access), len(db.authorize))
+	}
+	m := new(ap.Metadata)
+	_ = db.LoadMetadata(actor.ID, m)
+	if len(m.Pw) > 0 {
+		t.Errorf("expected the password to be removed")
+	}
+}
+
+func TestSuspendActorCmd_failedRevoke(t *testing.T) {
+	ctl, db, actor := tokensTestBase(t)
+	ctl.Storage = withTokenIndex(keepAccessStorage{db}, ctl.Service.ID)
+
+	if err := (SuspendActorCmd{IRI: actor.ID}).Run(ctl); err == nil {
+		t.Fatalf("Run() expected an error when the tokens can't be revoked")
+	}
+	m := new(ap.Metadata)
+	_ = db.LoadMetadata(actor.ID, m)
+	if len(m.AccessTokens) != 1 {
+		t.Errorf("indexed access tokens = %v, expected the token that was not revoked to be kept", m.AccessTokens)
+	}
+}
@@ -0,0 +1,272 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// exportPageSize is the number of items we load from the storage at once when exporting.
+const exportPageSize = 100
+
+type ExportCmd struct {
+	To              string    `name:"to" optional:"" type:"path" help:"The file where to output the items, if absent it will be printed to stdout."`
+	Format          string    `help:"The format of the output: a JSON array, JSON Lines, or a tar archive with a file for each item." enum:"json,jsonl,tar" default:"json"`
+	Actor           vocab.IRI `help:"Export only the actor and the items they authored."`
+	Filter          string    `help:"Filter the items, using the query string syntax of the HTTP API, eg: 'type=Note&attributedTo=~alice'."`
+	Since           time.Time `help:"Export only the items published after this time."`
+	Until           time.Time `help:"Export only the items published before this time."`
+	WithCollections bool      `name:"with-collections" help:"Include the members of the collections of the exported items."`
+}
+
+// itemWriter writes the exported items one by one.
+type itemWriter interface {
+	Write(it vocab.Item) error
+	Close() error
+}
+
+func (e ExportCmd) Run(ctl *Base) error {
+	where := io.Writer(ctl.out)
+	if e.To != "" {
+		f, err := os.OpenFile(e.To, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		where = f
+	}
+
+	var w itemWriter
+	switch e.Format {
+	case "jsonl":
+		w = &jsonLinesWriter{w: where}
+	case "tar":
+		w = &tarWriter{tw: tar.NewWriter(where)}
+	default:
+		w = &jsonArrayWriter{w: where}
+	}
+
+	checks, err := e.checks()
+	if err != nil {
+		return err
+	}
+
+	baseURL := vocab.IRI(ctl.Conf.BaseURL)
+	count := 0
+	collections := make(vocab.IRIs, 0)
+	write := func(it vocab.Item) error {
+		if e.WithCollections {
+			if vocab.ActorTypes.Match(it.GetType()) {
+				collections = append(collections, getActorCollections(it)...)
+			} else if !vocab.IsCollection(it) {
+				collections = append(collections, getObjectCollections(it)...)
+			}
+		}
+		count++
+		return w.Write(vocab.FlattenProperties(it))
+	}
+
+	for _, col := range streamCollections {
+		if err = streamCollection(ctl, vocab.IRIf(baseURL, col), write, checks...); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if count == 0 {
+		return errors.NotFoundf("No objects to export")
+	}
+
+	seen := make(map[vocab.IRI]struct{})
+	for _, iri := range collections {
+		if _, ok := seen[iri]; ok {
+			continue
+		}
+		seen[iri] = struct{}{}
+		col, err := collectionMembers(ctl, iri)
+		if err != nil {
+			continue
+		}
+		if err = w.Write(col); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// checks builds the filters for the items to export.
+func (e ExportCmd) checks() (filters.Checks, error) {
+	checks := make(filters.Checks, 0)
+	if e.Filter != "" {
+		q, err := url.ParseQuery(e.Filter)
+		if err != nil {
+			return nil, errors.NewBadRequest(err, "invalid filter %q", e.Filter)
+		}
+		checks = append(checks, filters.FromValues(q)...)
+	}
+	if e.Actor != "" {
+		checks = append(checks, filters.Any(
+			filters.SameID(e.Actor),
+			filters.SameAttributedTo(e.Actor),
+			filters.Actor(filters.SameID(e.Actor)),
+		))
+	}
+	if !e.Since.IsZero() || !e.Until.IsZero() {
+		checks = append(checks, publishedBetween{since: e.Since, until: e.Until})
+	}
+	return checks, nil
+}
+
+// publishedBetween matches the items published in the [since, until) interval.
+// A zero value for any of the ends leaves the interval open on that side.
+type publishedBetween struct {
+	since, until time.Time
+}
+
+func (p publishedBetween) Match(it vocab.Item) bool {
+	var published time.Time
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		published = ob.Published
+		return nil
+	})
+	if published.IsZero() {
+		return false
+	}
+	if !p.since.IsZero() && published.Before(p.since) {
+		return false
+	}
+	if !p.until.IsZero() && !published.Before(p.until) {
+		return false
+	}
+	return true
+}
+
+// streamCollection loads the collection one page at a time and calls fn for each of its items,
+// so we don't need to hold all of them in memory.
+func streamCollection(ctl *Base, iri vocab.IRI, fn func(vocab.Item) error, checks ...filters.Check) error {
+	var last vocab.IRI
+	for {
+		// The pagination checks keep state, so we need new ones for every page
+		page := append(filters.Checks{}, checks...)
+		if last != "" {
+			page = append(page, filters.After(filters.SameID(last)))
+		}
+		page = append(page, filters.WithMaxCount(exportPageSize))
+
+		items, err := dumpAll(ctl, iri, page...)
+		if err != nil {
+			return err
+		}
+		for _, it := range items {
+			if err = fn(it); err != nil {
+				return err
+			}
+		}
+		if len(items) < exportPageSize || items[len(items)-1].GetLink() == last {
+			return nil
+		}
+		last = items[len(items)-1].GetLink()
+	}
+}
+
+// collectionMembers loads the collection, and replaces its items with their IRIs.
+// The members are loaded one page at a time, so we hold only their IRIs in memory.
+func collectionMembers(ctl *Base, iri vocab.IRI) (vocab.Item, error) {
+	it, err := ctl.Storage.Load(iri, filters.WithMaxCount(1))
+	if err != nil {
+		return nil, err
+	}
+	col := emptyCollection(ctl, it)
+	err = streamCollection(ctl, iri, func(member vocab.Item) error {
+		return col.Append(member.GetLink())
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = vocab.OnOrderedCollection(col, func(c *vocab.OrderedCollection) error {
+		// We loaded only the first page of the collection for its properties, which can have a different ID
+		c.ID = iri
+		c.TotalItems = uint(len(c.OrderedItems))
+		return nil
+	})
+	return col, nil
+}
+
+type jsonArrayWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonArrayWriter) Write(it vocab.Item) error {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if j.count == 0 {
+		sep = "["
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, raw)
+	return err
+}
+
+func (j *jsonArrayWriter) Close() error {
+	if j.count == 0 {
+		_, err := io.WriteString(j.w, "[]")
+		return err
+	}
+	_, err := io.WriteString(j.w, "]")
+	return err
+}
+
+type jsonLinesWriter struct {
+	w io.Writer
+}
+
+func (j *jsonLinesWriter) Write(it vocab.Item) error {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, "%s\n", raw)
+	return err
+}
+
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
+
+// tarWriter saves every item as a separate JSON file, named after its IRI.
+type tarWriter struct {
+	tw    *tar.Writer
+	count int
+}
+
+func (t *tarWriter) Write(it vocab.Item) error {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return err
+	}
+	t.count++
+	name := fmt.Sprintf("%06d.json", t.count)
+	if u, err := it.GetLink().URL(); err == nil && u.Host != "" {
+		name = path.Join(u.Host, u.Path) + ".json"
+	}
+	hdr := tar.Header{Name: name, Mode: 0o600, Size: int64(len(raw)), ModTime: time.Now().UTC()}
+	if err = t.tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err = t.tw.Write(raw)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	return t.tw.Close()
+}
@@ -0,0 +1,168 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestPublishedBetween(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	note := &vocab.Object{Type: vocab.NoteType, Published: now}
+
+	tests := []struct {
+		name  string
+		check publishedBetween
+		it    vocab.Item
+		want  bool
+	}{
+		{name: "open interval", check: publishedBetween{}, it: note, want: true},
+		{name: "since", check: publishedBetween{since: now}, it: note, want: true},
+		{name: "since later", check: publishedBetween{since: now.Add(time.Second)}, it: note, want: false},
+		{name: "until is excluded", check: publishedBetween{until: now}, it: note, want: false},
+		{name: "until later", check: publishedBetween{until: now.Add(time.Second)}, it: note, want: true},
+		{name: "not published", check: publishedBetween{}, it: &vocab.Object{Type: vocab.NoteType}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check.Match(tt.it); got != tt.want {
+				t.Errorf("Match() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// exportTestBase returns a storage with two actors, and their notes. The outbox of the first one
+// has more items than fit in a page.
+func exportTestBase(t *testing.T) (*Base, *vocab.Actor, *vocab.Actor) {
+	t.Helper()
+
+	ctl, db := checkTestBase(t)
+	actors := make([]*vocab.Actor, 0, 2)
+	for _, name := range []string{"jdoe", "other"} {
+		actor := &vocab.Actor{ID: vocab.IRI(checkBaseURL + "/actors/" + name), Type: vocab.PersonType}
+		actor.Outbox = vocab.Outbox.IRI(actor)
+		_, _ = db.Save(actor)
+		_ = db.AddTo(vocab.IRIf(checkBaseURL, "actors"), actor)
+		_, _ = db.Save(newOrderedCollection(ctl, actor.Outbox.GetLink()))
+		actors = append(actors, actor)
+	}
+	for i := 0; i < exportPageSize+20; i++ {
+		actor := actors[0]
+		if i%10 == 0 {
+			actor = actors[1]
+		}
+		note := &vocab.Object{
+			ID:           vocab.IRI(fmt.Sprintf("%s/objects/%d", checkBaseURL, i)),
+			Type:         vocab.NoteType,
+			AttributedTo: actor.ID,
+			Published:    time.Now().UTC(),
+		}
+		_, _ = db.Save(note)
+		_ = db.AddTo(vocab.IRIf(checkBaseURL, "objects"), note)
+		_ = db.AddTo(actor.Outbox.GetLink(), note)
+	}
+	return ctl, actors[0], actors[1]
+}
+
+func TestExportCmd_jsonl(t *testing.T) {
+	ctl, jdoe, other := exportTestBase(t)
+	to := filepath.Join(t.TempDir(), "export.jsonl")
+
+	cmd := ExportCmd{To: to, Format: "jsonl", Actor: jdoe.ID, WithCollections: true}
+	if err := cmd.Run(ctl); err != nil {
+		t.Fatalf("Run() error = %s", err)
+	}
+	raw, err := os.ReadFile(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make(map[vocab.IRI]vocab.Item)
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		it, err := vocab.UnmarshalJSON(s.Bytes())
+		if err != nil {
+			t.Fatalf("invalid line %q: %s", s.Text(), err)
+		}
+		items[it.GetLink()] = it
+	}
+
+	if _, ok := items[jdoe.ID]; !ok {
+		t.Errorf("the actor %s was not exported", jdoe.ID)
+	}
+	for iri, it := range items {
+		if iri == other.ID || iri == other.Outbox.GetLink() {
+			t.Errorf("the item %s of another actor was exported", iri)
+		}
+		_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+			if ob.Type == vocab.NoteType && !ob.AttributedTo.GetLink().Equal(jdoe.ID) {
+				t.Errorf("the note %s of another actor was exported", iri)
+			}
+			return nil
+		})
+	}
+	outbox, ok := items[jdoe.Outbox.GetLink()]
+	if !ok {
+		t.Fatalf("the outbox %s was not exported", jdoe.Outbox)
+	}
+	want := exportPageSize + 20 - (exportPageSize+20+9)/10
+	_ = vocab.OnCollectionIntf(outbox, func(col vocab.CollectionInterface) error {
+		if got := len(col.Collection()); got != want {
+			t.Errorf("outbox members = %d, want %d", got, want)
+		}
+		return nil
+	})
+}
+
+func TestExportCmd_tar(t *testing.T) {
+	ctl, jdoe, _ := exportTestBase(t)
+	to := filepath.Join(t.TempDir(), "export.tar")
+
+	since := time.Now().UTC().Add(-time.Hour)
+	cmd := ExportCmd{To: to, Format: "tar", Actor: jdoe.ID, Since: since}
+	if err := cmd.Run(ctl); err != nil {
+		t.Fatalf("Run() error = %s", err)
+	}
+	f, err := os.Open(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	names := make(map[string]struct{})
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid archive: %s", err)
+		}
+		raw, _ := io.ReadAll(tr)
+		if _, err = vocab.UnmarshalJSON(raw); err != nil {
+			t.Errorf("invalid JSON in %s: %s", hdr.Name, err)
+		}
+		names[hdr.Name] = struct{}{}
+	}
+	// The actor was not published in the interval, so only its notes are exported
+	if _, ok := names["example.com/actors/jdoe.json"]; ok {
+		t.Errorf("the actor was exported, even if it was not published after %s", since)
+	}
+	if _, ok := names["example.com/objects/1.json"]; !ok {
+		t.Errorf("the note example.com/objects/1.json is missing from the archive: %v", names)
+	}
+	if _, ok := names["example.com/objects/0.json"]; ok {
+		t.Errorf("the note example.com/objects/0.json of another actor was exported")
+	}
+}
@@ -0,0 +1,162 @@
+package fedbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+type FetchCmd struct {
+	IRI    vocab.IRI `arg:"" name:"iri" help:"The IRI of the object to fetch."`
+	As     vocab.IRI `help:"The local actor that signs the request. If missing, the service actor is used."`
+	Store  bool      `help:"Save the fetched object in the local storage. Only remote objects, with the ID on the host they have been fetched from, are saved."`
+	Output string    `short:"o" help:"The format in which to output the object." enum:"text,json" default:"text"`
+}
+
+// Run dereferences the IRI with a request signed as the actor, the same way the server does it when
+// loading remote objects, and shows what it received.
+func (f FetchCmd) Run(ctl *Base) error {
+	signer := ctl.Service.ID
+	if f.As != "" {
+		actor, err := localActor(ctl, f.As)
+		if err != nil {
+			return err
+		}
+		signer = actor.ID
+	}
+
+	// We want to see what the remote server returns now, so we skip the HTTP cache
+	attempts := &signingAttempts{RoundTripper: &http.Transport{}}
+	cl := actorClient(ctl, signer, attempts, false)
+	req, err := cl.FetchRequest(context.Background(), f.IRI.String())
+	if err != nil {
+		return err
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "unable to fetch %s", f.IRI)
+	}
+	defer res.Body.Close()
+
+	_, _ = fmt.Fprintf(ctl.out, "GET %s\n", f.IRI)
+	_, _ = fmt.Fprintf(ctl.out, "Signed as: %s\n", signer)
+	for i, a := range attempts.requests {
+		_, _ = fmt.Fprintf(ctl.out, "Attempt %d: %s %s\n", i+1, a.method, a.url)
+		_, _ = fmt.Fprintf(ctl.out, "\tSignature: %s\n", signatureScheme(a.header))
+		_, _ = fmt.Fprintf(ctl.out, "\tResult: %s\n", a.result)
+	}
+	if accept := res.Header.Get("Accept-Signature"); accept != "" {
+		_, _ = fmt.Fprintf(ctl.out, "Accept-Signature: %s\n", accept)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "\n%s %s\n", res.Proto, res.Status)
+	printHeaders(ctl.out, res.Header)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.Annotatef(err, "unable to read the response body")
+	}
+	if res.StatusCode != http.StatusOK {
+		_, _ = fmt.Fprintf(ctl.out, "\n%s\n", body)
+		return errors.NewFromStatus(res.StatusCode, "unable to fetch %s", f.IRI)
+	}
+	it, err := vocab.UnmarshalJSON(body)
+	if err != nil || vocab.IsNil(it) {
+		_, _ = fmt.Fprintf(ctl.out, "\n%s\n", body)
+		return errors.Newf("the response is not a valid ActivityPub object")
+	}
+	_, _ = fmt.Fprintln(ctl.out)
+	if err = printItem(ctl.out, it, f.Output); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(ctl.out)
+
+	if !f.Store {
+		return nil
+	}
+	fetched := f.IRI
+	if res.Request != nil && res.Request.URL != nil {
+		fetched = vocab.IRI(res.Request.URL.String())
+	}
+	if err = storable(ctl, fetched, it); err != nil {
+		return err
+	}
+	if _, err = ctl.Storage.Save(it); err != nil {
+		return errors.Annotatef(err, "unable to save %s", it.GetLink())
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Saved %s\n", it.GetLink())
+	return nil
+}
+
+// storable checks that the fetched object can be saved in the local storage: it needs to be a remote object,
+// with its ID on the host it has been fetched from, so other servers can't overwrite our objects,
+// or the ones of third parties.
+func storable(ctl *Base, fetched vocab.IRI, it vocab.Item) error {
+	id := it.GetLink()
+	if id == "" {
+		return errors.Forbiddenf("refusing to save an object without an ID")
+	}
+	base := vocab.IRI(ctl.Conf.BaseURL)
+	if sameHost(id, base) || sameHost(fetched, base) {
+		return errors.Forbiddenf("refusing to save the local object %s", id)
+	}
+	if !sameHost(id, fetched) {
+		return errors.Forbiddenf("refusing to save %s, which has been fetched from a different host: %s", id, fetched)
+	}
+	return nil
+}
+
+// signingAttempt is a request sent for fetching an object, with the outcome of sending it.
+type signingAttempt struct {
+	method, url string
+	header      http.Header
+	result      string
+}
+
+// signingAttempts records the requests sent through its transport.
+// The client tries the RFC9421 signature first, and falls back to the draft-cavage one if the
+// remote server refuses it, so we need all the requests for showing how each signature has been received.
+type signingAttempts struct {
+	http.RoundTripper
+	requests []signingAttempt
+}
+
+func (s *signingAttempts) RoundTrip(r *http.Request) (*http.Response, error) {
+	a := signingAttempt{method: r.Method, url: r.URL.String(), header: r.Header.Clone()}
+	res, err := s.RoundTripper.RoundTrip(r)
+	switch {
+	case err != nil:
+		a.result = err.Error()
+	case res != nil:
+		a.result = res.Status
+	}
+	s.requests = append(s.requests, a)
+	return res, err
+}
+
+// signatureScheme returns which of the HTTP signature schemes has been used for signing a request.
+func signatureScheme(h http.Header) string {
+	switch {
+	case h.Get("Signature-Input") != "":
+		return "RFC9421 " + h.Get("Signature-Input")
+	case h.Get("Signature") != "":
+		return "draft-cavage-http-signatures " + h.Get("Signature")
+	}
+	return "none"
+}
+
+func printHeaders(out io.Writer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(out, "%s: %s\n", name, strings.Join(h.Values(name), ", "))
+	}
+}
@@ -0,0 +1,68 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func TestStorable(t *testing.T) {
+	ctl := &Base{Conf: config.Options{BaseURL: "https://fedbox.example"}}
+	tests := []struct {
+		name    string
+		fetched vocab.IRI
+		id      vocab.IRI
+		wantErr bool
+	}{
+		{name: "remote", fetched: "https://remote.example/users/alice", id: "https://remote.example/users/alice"},
+		{name: "redirected on the same host", fetched: "https://remote.example/@alice", id: "https://remote.example/users/alice"},
+		{name: "other host", fetched: "https://remote.example/users/alice", id: "https://other.example/users/alice", wantErr: true},
+		{name: "local id", fetched: "https://remote.example/users/alice", id: "https://fedbox.example/actors/alice", wantErr: true},
+		{name: "local fetch", fetched: "https://fedbox.example/actors/alice", id: "https://fedbox.example/actors/alice", wantErr: true},
+		{name: "no id", fetched: "https://remote.example/users/alice", id: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := storable(ctl, tt.fetched, &vocab.Object{ID: tt.id, Type: vocab.NoteType})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("storable() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigningAttempts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Signature-Input") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	attempts := &signingAttempts{RoundTripper: http.DefaultTransport}
+	cl := &http.Client{Transport: attempts}
+	for _, h := range []http.Header{{"Signature-Input": {"sig=()"}}, {"Signature": {`keyId="k"`}}} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header = h
+		res, err := cl.Do(req)
+		if err != nil {
+			t.Fatalf("request error = %s", err)
+		}
+		_ = res.Body.Close()
+	}
+
+	if len(attempts.requests) != 2 {
+		t.Fatalf("recorded %d requests, expected 2", len(attempts.requests))
+	}
+	if got := attempts.requests[0]; got.result != "401 Unauthorized" || signatureScheme(got.header) != "RFC9421 sig=()" {
+		t.Errorf("first attempt = %+v", got)
+	}
+	if got := attempts.requests[1]; got.result != "200 OK" || signatureScheme(got.header) != `draft-cavage-http-signatures keyId="k"` {
+		t.Errorf("second attempt = %+v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package fedbox
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+type HostKeys struct {
+	List   ListHostKeys   `cmd:"" help:"List the SSH host keys and their fingerprints."`
+	Export ExportHostKeys `cmd:"" help:"Export the SSH host keys."`
+	Import ImportHostKeys `cmd:"" help:"Import SSH host keys, replacing the existing ones of the same type."`
+}
+
+type ListHostKeys struct{}
+
+func (l ListHostKeys) Run(ctl *Base) error {
+	hostKeys, err := ap.LoadHostKeys(ctl.Storage, ctl.Service.ID)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load SSH host keys")
+	}
+	for _, pair := range hostKeys {
+		_, _ = fmt.Fprintf(ctl.out, "%s\t%s\n", pair.Type, ap.Fingerprint(pair.Public))
+	}
+	return nil
+}
+
+type ExportHostKeys struct {
+	To string `flag:"" type:"path" help:"The file where to output the keys, if absent they will be printed to stdout."`
+}
+
+func (e ExportHostKeys) Run(ctl *Base) error {
+	hostKeys, err := ap.LoadHostKeys(ctl.Storage, ctl.Service.ID)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load SSH host keys")
+	}
+
+	var where io.Writer = ctl.out
+	if e.To != "" {
+		f, err := os.OpenFile(e.To, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		where = f
+	}
+	for _, pair := range hostKeys {
+		_, prv, err := ap.EncodeKeyPair(pair)
+		if err != nil {
+			return err
+		}
+		if err = pem.Encode(where, &prv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ImportHostKeys struct {
+	Files []*os.File `arg:"" secret:"" help:"The files containing the PEM encoded private keys."`
+}
+
+func (i ImportHostKeys) Run(ctl *Base) error {
+	pairs := make([]ap.KeyPair, 0)
+	for _, f := range i.Files {
+		raw, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		// A file can contain multiple keys
+		for {
+			var block *pem.Block
+			if block, raw = pem.Decode(raw); block == nil {
+				break
+			}
+			pair, err := ap.KeyPairFromPrivateBytes(pem.EncodeToMemory(block))
+			if err != nil {
+				return errors.Annotatef(err, "invalid private key in %s", f.Name())
+			}
+			pairs = append(pairs, *pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return errors.Newf("no private keys found")
+	}
+	if err := ap.SaveHostKeys(ctl.Storage, ctl.Service.ID, pairs...); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		_, _ = fmt.Fprintf(ctl.out, "Imported %s\t%s\n", pair.Type, ap.Fingerprint(pair.Public))
+	}
+	return nil
+}
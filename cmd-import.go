@@ -0,0 +1,377 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+// importCheckpointExt is the extension of the file, saved next to the imported one, that keeps
+// the number of items that have been processed, so an interrupted import can be resumed.
+const importCheckpointExt = ".import-checkpoint"
+
+// importCheckpointEvery is the number of items processed between saving the checkpoints.
+const importCheckpointEvery = 100
+
+// concurrentWriteBackends are the storage backends that can save items from more than one goroutine at a time.
+// The fs and sqlite backends don't synchronize their writes, so concurrent updates of the same
+// collection can lose members, and boltdb allows only one writer at a time, so more workers don't help.
+var concurrentWriteBackends = []storage.Type{config.StorageBadger, config.StoragePostgres}
+
+type ImportCmd struct {
+	Base        vocab.IRI `flag:"" help:"The base IRI to replace"`
+	SkipRemotes bool      `flag:"" help:"Do not try to disseminate to remote recipients"`
+	BatchSize   int       `name:"batch-size" default:"100" help:"The number of objects, that are not activities, saved in one batch."`
+	Workers     int       `default:"1" help:"The number of parallel workers saving a batch of objects, for the badger and postgres storage backends."`
+	Restart     bool      `help:"Ignore the saved checkpoints and import the files from the beginning."`
+	Files       []string  `arg:"" type:"existingfile" help:"The files containing the JSON encoded items: a JSON array, a single object, or JSON Lines."`
+}
+
+// importer holds the state of the import of one file.
+type importer struct {
+	ImportCmd
+	ctl *Base
+
+	checkpoint string
+	skip       int
+	processed  int
+	batch      vocab.ItemCollection
+
+	activities int
+	objects    int
+	failed     int
+}
+
+func (i ImportCmd) Run(ctl *Base) error {
+	if i.BatchSize < 1 {
+		i.BatchSize = 1
+	}
+	if i.Workers < 1 {
+		i.Workers = 1
+	}
+	if i.Workers > 1 && !slices.Contains(concurrentWriteBackends, ctl.Conf.Storage) {
+		_, _ = fmt.Fprintf(ctl.out, "The %s storage doesn't support concurrent writes, using a single worker\n", ctl.Conf.Storage)
+		i.Workers = 1
+	}
+
+	start := time.Now()
+	var activities, objects, failed int
+	for _, name := range i.Files {
+		imp := importer{ImportCmd: i, ctl: ctl, checkpoint: name + importCheckpointExt}
+		if err := imp.importFile(name); err != nil {
+			Errf(ctl.err, "Unable to import %s: %s", name, err)
+			continue
+		}
+		activities += imp.activities
+		objects += imp.objects
+		failed += imp.failed
+	}
+
+	tot := time.Since(start)
+	_, _ = fmt.Fprintf(ctl.out, "Imported %d activities and %d objects, %d failed\n", activities, objects, failed)
+	_, _ = fmt.Fprintf(ctl.out, "Elapsed time:          %4s\n", tot)
+	if count := activities + objects; count > 0 {
+		perIt := time.Duration(int64(tot) / int64(count))
+		_, _ = fmt.Fprintf(ctl.out, "Elapsed time per item: %4s\n", perIt)
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Import done!\n")
+	return nil
+}
+
+func (imp *importer) importFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if imp.Restart {
+		_ = os.Remove(imp.checkpoint)
+	} else if imp.skip, err = loadImportCheckpoint(imp.checkpoint); err != nil {
+		return err
+	}
+	if imp.skip > 0 {
+		_, _ = fmt.Fprintf(imp.ctl.out, "Resuming %s after %d items\n", name, imp.skip)
+	}
+
+	fn := func(raw json.RawMessage) error {
+		imp.processed++
+		if imp.processed <= imp.skip {
+			return nil
+		}
+		imp.process(raw)
+		if imp.processed%importCheckpointEvery == 0 {
+			return imp.saveCheckpoint()
+		}
+		return nil
+	}
+	if strings.HasSuffix(name, ".tar") {
+		err = streamTarItems(f, fn)
+	} else {
+		err = streamItems(f, fn)
+	}
+	imp.flush()
+	if err != nil {
+		// We keep the position up to the last valid item, so the import can be resumed
+		// after fixing the file
+		_ = imp.saveCheckpoint()
+		return err
+	}
+	_ = os.Remove(imp.checkpoint)
+	return nil
+}
+
+// streamItems decodes the JSON values from r one by one, and calls fn for each of them.
+// It supports a JSON array of items, a single item, or JSON Lines, which are a sequence of items.
+func streamItems(r io.Reader, fn func(json.RawMessage) error) error {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		if err == io.EOF {
+			return errors.Newf("empty file")
+		}
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if _, err = dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			raw := json.RawMessage{}
+			if err = dec.Decode(&raw); err != nil {
+				return err
+			}
+			if err = fn(raw); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+
+	for {
+		raw := json.RawMessage{}
+		if err = dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = fn(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// streamTarItems calls fn for the items in each of the files of a tar archive, as created by "pub export".
+func streamTarItems(r io.Reader, fn func(json.RawMessage) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = streamItems(tr, fn); err != nil {
+			return errors.Annotatef(err, "invalid item %s", hdr.Name)
+		}
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+func loadImportCheckpoint(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid checkpoint %s", path)
+	}
+	return n, nil
+}
+
+// saveCheckpoint saves the number of processed items, after making sure that all of them have been saved.
+func (imp *importer) saveCheckpoint() error {
+	imp.flush()
+	tmp := imp.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(imp.processed)), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, imp.checkpoint)
+}
+
+func (imp *importer) process(raw json.RawMessage) {
+	ctl := imp.ctl
+	if len(imp.Base) > 0 {
+		raw = bytes.Replace(raw, []byte(imp.Base), []byte(ctl.Conf.BaseURL), -1)
+	}
+	it, err := vocab.UnmarshalJSON(raw)
+	if err != nil || vocab.IsNil(it) {
+		Errf(ctl.err, "Error unmarshaling JSON: %v", err)
+		imp.failed++
+		return
+	}
+
+	typ := it.GetType()
+	if !vocab.ActivityTypes.Match(typ) && !vocab.IntransitiveActivityTypes.Match(typ) {
+		imp.batch = append(imp.batch, it)
+		if len(imp.batch) >= imp.BatchSize*imp.Workers {
+			imp.flush()
+		}
+		return
+	}
+
+	// The objects received before the activity need to be saved before processing it
+	imp.flush()
+	if err = imp.processActivity(it); err != nil {
+		Errf(ctl.err, "Unable to process %s %s: %v", it.GetType(), it.GetID(), err)
+		imp.failed++
+		return
+	}
+	imp.activities++
+}
+
+func (imp *importer) processActivity(it vocab.Item) error {
+	ctl := imp.ctl
+	_, _ = fmt.Fprintf(ctl.out, "Processing %s %s\n", it.GetType(), it.GetID())
+	return vocab.OnIntransitiveActivity(it, func(a *vocab.IntransitiveActivity) error {
+		if a == nil {
+			return errors.Newf("invalid activity, is nil: %s", it.GetLink())
+		}
+		if a.Actor == nil {
+			return errors.Newf("invalid activity, actor is nil: %s", it.GetLink())
+		}
+		actor, err := vocab.ToActor(a.Actor)
+		if err != nil {
+			actor = &vocab.Actor{ID: a.Actor.GetLink()}
+		}
+		activityPub := ctl.Saver(&ctl.Service, imp.SkipRemotes)
+		_, err = activityPub.ProcessClientActivity(it, *actor, vocab.Outbox.Of(a.Actor).GetLink())
+		return err
+	})
+}
+
+// flush saves the pending objects, split in batches between the workers.
+// The workers only collect their errors, which get reported after all of them finish,
+// as the logger isn't safe to use from more than one goroutine.
+func (imp *importer) flush() {
+	if len(imp.batch) == 0 {
+		return
+	}
+	batches := make([]vocab.ItemCollection, 0, imp.Workers)
+	for start := 0; start < len(imp.batch); start += imp.BatchSize {
+		end := min(start+imp.BatchSize, len(imp.batch))
+		batches = append(batches, imp.batch[start:end])
+	}
+
+	var mu sync.Mutex
+	errs := make([]error, 0)
+	wg := sync.WaitGroup{}
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch vocab.ItemCollection) {
+			defer wg.Done()
+			saved, failed := imp.saveObjects(batch)
+			mu.Lock()
+			imp.objects += saved
+			imp.failed += len(failed)
+			errs = append(errs, failed...)
+			mu.Unlock()
+		}(batch)
+	}
+	wg.Wait()
+	imp.batch = imp.batch[:0]
+
+	for _, err := range errs {
+		Errf(imp.ctl.err, "%s", err)
+	}
+}
+
+// saveObjects saves the objects, and returns the number of the ones saved, and the errors for the ones that failed.
+func (imp *importer) saveObjects(objects vocab.ItemCollection) (int, []error) {
+	ctl := imp.ctl
+	saved := 0
+	errs := make([]error, 0)
+	for _, it := range objects {
+		if vocab.IsCollection(it) {
+			if err := imp.saveCollection(it); err != nil {
+				errs = append(errs, errors.Annotatef(err, "Unable to save collection %s", it.GetID()))
+				continue
+			}
+			saved++
+			continue
+		}
+		// NOTE(marius): need to check if already created by activities processing
+		exists, err := ctl.Storage.Load(it.GetLink())
+		if (err != nil && errors.IsNotFound(err)) || vocab.IsNil(exists) {
+			if _, err := ctl.Storage.Save(it); err != nil {
+				errs = append(errs, errors.Annotatef(err, "Unable to save %s %s", it.GetType(), it.GetID()))
+				continue
+			}
+		}
+		saved++
+	}
+	return saved, errs
+}
+
+// saveCollection creates the collection, if it doesn't exist, and adds its members to it.
+// The storage doesn't save the items of a collection together with it.
+func (imp *importer) saveCollection(it vocab.Item) error {
+	st := imp.ctl.Storage
+	iri := it.GetLink()
+	if _, err := st.Load(iri); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err = st.Create(emptyCollection(imp.ctl, it)); err != nil {
+			return err
+		}
+	}
+	members := make(vocab.ItemCollection, 0)
+	_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		for _, member := range col.Collection() {
+			members = append(members, member.GetLink())
+		}
+		return nil
+	})
+	if len(members) == 0 {
+		return nil
+	}
+	return st.AddTo(iri, members...)
+}
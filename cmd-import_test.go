@@ -0,0 +1,101 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestStreamItems(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{name: "array", input: ` [{"id":"https://example.com/1"}, {"id":"https://example.com/2"}]`, want: 2},
+		{name: "single", input: `{"id":"https://example.com/1"}`, want: 1},
+		{name: "lines", input: "{\"id\":\"https://example.com/1\"}\n{\"id\":\"https://example.com/2\"}\n\n{\"id\":\"https://example.com/3\"}\n", want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			err := streamItems(strings.NewReader(tt.input), func(raw json.RawMessage) error {
+				got++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("streamItems() error = %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("streamItems() got %d items, want %d", got, tt.want)
+			}
+		})
+	}
+	if err := streamItems(strings.NewReader("  "), func(json.RawMessage) error { return nil }); err == nil {
+		t.Errorf("streamItems() expected error for empty input")
+	}
+}
+
+func writeImportFile(t *testing.T, count int, tail string) string {
+	t.Helper()
+
+	lines := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id":"%s/objects/%d","type":"Note"}`, checkBaseURL, i))
+	}
+	name := filepath.Join(t.TempDir(), "items.jsonl")
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"+tail), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestImporter_ResumeFromCheckpoint(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	ctl.out, ctl.err = io.Discard, io.Discard
+
+	name := writeImportFile(t, 3, "")
+	checkpoint := name + importCheckpointExt
+	if err := os.WriteFile(checkpoint, []byte("2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	imp := importer{ImportCmd: ImportCmd{BatchSize: 1, Workers: 1}, ctl: ctl, checkpoint: checkpoint}
+	if err := imp.importFile(name); err != nil {
+		t.Fatalf("importFile() error = %s", err)
+	}
+	if imp.objects != 1 {
+		t.Errorf("expected 1 imported object, got %d", imp.objects)
+	}
+	for i := 1; i <= 3; i++ {
+		iri := vocab.IRI(fmt.Sprintf("%s/objects/%d", checkBaseURL, i))
+		if _, saved := db.items[iri]; saved != (i == 3) {
+			t.Errorf("%s saved = %t, expected %t", iri, saved, i == 3)
+		}
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed after a successful import, got %v", err)
+	}
+}
+
+func TestImporter_KeepCheckpointOnError(t *testing.T) {
+	ctl, _ := checkTestBase(t)
+	ctl.out, ctl.err = io.Discard, io.Discard
+
+	name := writeImportFile(t, 2, "{invalid")
+	checkpoint := name + importCheckpointExt
+
+	imp := importer{ImportCmd: ImportCmd{BatchSize: 1, Workers: 1}, ctl: ctl, checkpoint: checkpoint}
+	if err := imp.importFile(name); err == nil {
+		t.Fatalf("importFile() expected error for an invalid item")
+	}
+	if n, err := loadImportCheckpoint(checkpoint); err != nil || n != 2 {
+		t.Errorf("expected the checkpoint after the 2 valid items, got %d, %v", n, err)
+	}
+}
@@ -1,13 +1,49 @@
 package fedbox
 
-import "syscall"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"syscall"
 
-type Maintenance struct{}
+	"github.com/go-ap/errors"
+)
+
+type Maintenance struct {
+	ReadOnly bool `name:"read-only" help:"Toggle the read-only mode, in which the server keeps answering GET requests."`
+}
 
 func (m Maintenance) Run(ctl *Base) error {
+	if m.ReadOnly {
+		return ctl.toggleReadOnly()
+	}
 	return ctl.SendSignalToServer(syscall.SIGUSR1)()
 }
 
+// toggleReadOnly switches the read-only mode of the running server through its admin API,
+// as there's no signal left for it.
+func (ctl *Base) toggleReadOnly() error {
+	cl := ctl.remoteClient()
+	if cl == nil {
+		return errors.NotFoundf("no running %s server found at %s", AppName, ctl.Conf.InternalSocketPath())
+	}
+	resp, err := cl.Post("http://fedbox/read-only", "application/json", nil)
+	if err != nil {
+		return errors.Annotatef(err, "unable to toggle read-only mode")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.NewFromStatus(resp.StatusCode, "unable to toggle read-only mode")
+	}
+
+	st := new(Status)
+	if err = json.NewDecoder(resp.Body).Decode(st); err != nil {
+		return errors.Annotatef(err, "invalid status received from the running server")
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Read-only: %s\n", onOff(st.ReadOnly))
+	return nil
+}
+
 type Debug struct{}
 
 func (m Debug) Run(ctl *Base) error {
@@ -0,0 +1,432 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// defaultMastodonMediaSize is the default size, in MB, over which we don't import the media files
+// from Mastodon archives.
+// The media files are saved as data URIs in the content of the objects, which get loaded
+// every time the objects are, so we keep them small.
+const defaultMastodonMediaSize = 4
+
+// maxMastodonArchiveSize is the total size of the files we extract from a Mastodon archive.
+const maxMastodonArchiveSize = 8 << 30
+
+type ImportMastodonCmd struct {
+	Actor        vocab.IRI `required:"" help:"The local actor to which the archive gets imported."`
+	Profile      bool      `help:"Replace the name, summary, avatar and header of the actor with the ones from the archive."`
+	MaxMediaSize int64     `name:"max-media-size" default:"${maxMediaSize}" help:"The size in MB over which the media files are not imported, and the attachments keep pointing to the origin server."`
+	Archive      string    `arg:"" type:"path" help:"The Mastodon archive: the .tar.gz file or the folder where it has been extracted."`
+}
+
+// mastodonImport holds the state of the import of a Mastodon archive.
+type mastodonImport struct {
+	ctl   *Base
+	actor *vocab.Actor
+	dir   string
+
+	// origin is the IRI of the actor in the Mastodon archive.
+	origin vocab.IRI
+	// ids maps the IRIs of the statuses in the archive to the IRIs of the imported objects.
+	ids map[vocab.IRI]vocab.IRI
+	// maxMediaSize is the size in bytes over which the media files are not imported.
+	maxMediaSize int64
+
+	notes, media, announces, likes, skipped int
+}
+
+func (m ImportMastodonCmd) Run(ctl *Base) error {
+	actor, err := ap.LoadActor(ctl.Storage, m.Actor)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load local actor %s", m.Actor)
+	}
+	if !actor.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+		return errors.Newf("the actor %s is not local", actor.ID)
+	}
+
+	dir := m.Archive
+	if fi, err := os.Stat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		if dir, err = os.MkdirTemp("", "mastodon-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err = extractTarGz(m.Archive, dir, maxMastodonArchiveSize); err != nil {
+			return errors.Annotatef(err, "unable to extract archive")
+		}
+	}
+
+	imp := mastodonImport{ctl: ctl, actor: &actor, dir: dir, ids: make(map[vocab.IRI]vocab.IRI), maxMediaSize: m.MaxMediaSize << 20}
+	if err = imp.loadOrigin(m.Profile); err != nil {
+		return err
+	}
+	if err = imp.importOutbox(); err != nil {
+		return err
+	}
+	if err = imp.importLikes(); err != nil {
+		return err
+	}
+	if _, err = os.Stat(filepath.Join(dir, "bookmarks.json")); err == nil {
+		// ActivityPub has no equivalent for bookmarks, so there's nothing to map them to
+		_, _ = fmt.Fprintf(ctl.out, "Skipped bookmarks.json: bookmarks are not supported\n")
+	}
+
+	_, _ = fmt.Fprintf(ctl.out, "Imported %d notes, %d media files, %d announces and %d likes to %s, %d items skipped\n",
+		imp.notes, imp.media, imp.announces, imp.likes, actor.ID, imp.skipped)
+	return nil
+}
+
+// extractTarGz extracts the regular files of the archive to dir, failing if their total size is over maxSize.
+func extractTarGz(archive, dir string, maxSize int64) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	var extracted int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if hdr.Typeflag != tar.TypeReg || !fs.ValidPath(name) {
+			continue
+		}
+		// The tar reader doesn't return more than the size in the header for an entry
+		if extracted += hdr.Size; extracted > maxSize {
+			return errors.Newf("the archive contains more than %d bytes of files", maxSize)
+		}
+		if _, err = restoreFile(tr, filepath.Join(dir, filepath.FromSlash(name)), 0o600); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *mastodonImport) loadJSON(name string) (vocab.Item, error) {
+	raw, err := os.ReadFile(filepath.Join(m.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return vocab.UnmarshalJSON(raw)
+}
+
+// loadOrigin loads the actor from the archive, and if requested, copies its profile to the local actor.
+func (m *mastodonImport) loadOrigin(withProfile bool) error {
+	it, err := m.loadJSON("actor.json")
+	if err != nil {
+		return errors.Annotatef(err, "invalid archive, unable to load actor.json")
+	}
+	origin, err := vocab.ToActor(it)
+	if err != nil {
+		return errors.Annotatef(err, "invalid actor.json")
+	}
+	m.origin = origin.ID
+	if !withProfile {
+		return nil
+	}
+
+	act := m.actor
+	act.Name = origin.Name
+	act.Summary = origin.Summary
+	// The avatar and the header are public, as the profile itself
+	profile := &vocab.Object{Published: origin.Published, To: vocab.ItemCollection{vocab.PublicNS}}
+	if icon := m.saveMedia(origin.Icon, profile); icon != nil {
+		act.Icon = icon
+	}
+	if image := m.saveMedia(origin.Image, profile); image != nil {
+		act.Image = image
+	}
+	update := ap.WrapObjectInUpdate(act, act)
+	_, err = m.ctl.Saver(act, true).ProcessClientActivity(update, *act, vocab.Outbox.IRI(act))
+	return err
+}
+
+// rewrite maps the IRIs from the archive to local ones.
+func (m *mastodonImport) rewrite(iri vocab.IRI) vocab.IRI {
+	switch {
+	case iri.Equal(m.origin):
+		return m.actor.ID
+	case iri.Equal(vocab.Followers.IRI(m.origin)):
+		return vocab.Followers.IRI(m.actor)
+	case iri.Equal(vocab.Following.IRI(m.origin)):
+		return vocab.Following.IRI(m.actor)
+	}
+	if local, ok := m.ids[iri]; ok {
+		return local
+	}
+	return iri
+}
+
+func (m *mastodonImport) rewriteAll(col vocab.ItemCollection) vocab.ItemCollection {
+	if len(col) == 0 {
+		return col
+	}
+	res := make(vocab.ItemCollection, 0, len(col))
+	for _, it := range col {
+		res = append(res, m.rewrite(it.GetLink()))
+	}
+	return res
+}
+
+func (m *mastodonImport) importOutbox() error {
+	it, err := m.loadJSON("outbox.json")
+	if err != nil {
+		return errors.Annotatef(err, "invalid archive, unable to load outbox.json")
+	}
+	items := make(vocab.ItemCollection, 0)
+	_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		items = col.Collection()
+		return nil
+	})
+	// The replies to our own statuses need the IRIs of the statuses they reply to,
+	// so we process them in the order in which they have been published
+	sortByPublished(items)
+
+	for _, it := range items {
+		var err error
+		switch it.GetType() {
+		case vocab.CreateType:
+			err = m.importCreate(it)
+		case vocab.AnnounceType:
+			err = m.importAnnounce(it)
+		default:
+			m.skipped++
+			continue
+		}
+		if err != nil {
+			Errf(m.ctl.err, "Unable to import %s %s: %s", it.GetType(), it.GetLink(), err)
+			m.skipped++
+		}
+	}
+	return nil
+}
+
+// sortByPublished sorts the items in the order in which they have been published, keeping the order of the
+// items with the same publishing time, or without one.
+func sortByPublished(items vocab.ItemCollection) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return publishedTime(items[i]).Before(publishedTime(items[j]))
+	})
+}
+
+func publishedTime(it vocab.Item) time.Time {
+	var t time.Time
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		t = ob.Published
+		return nil
+	})
+	return t
+}
+
+// process submits the activity to the actor's outbox, without disseminating it to remote servers.
+func (m *mastodonImport) process(act *vocab.Activity) (vocab.Item, error) {
+	act.ID = ""
+	act.Actor = m.actor.ID
+	act.To = m.rewriteAll(act.To)
+	act.CC = m.rewriteAll(act.CC)
+	act.Bto = m.rewriteAll(act.Bto)
+	act.BCC = m.rewriteAll(act.BCC)
+	return m.ctl.Saver(m.actor, true).ProcessClientActivity(act, *m.actor, vocab.Outbox.IRI(m.actor))
+}
+
+func (m *mastodonImport) importCreate(it vocab.Item) error {
+	return vocab.OnActivity(it, func(act *vocab.Activity) error {
+		var oldID vocab.IRI
+		err := vocab.OnObject(act.Object, func(ob *vocab.Object) error {
+			oldID = ob.ID
+			ob.ID = ""
+			ob.AttributedTo = m.actor.ID
+			ob.To = m.rewriteAll(ob.To)
+			ob.CC = m.rewriteAll(ob.CC)
+			ob.Bto = m.rewriteAll(ob.Bto)
+			ob.BCC = m.rewriteAll(ob.BCC)
+			if !vocab.IsNil(ob.InReplyTo) {
+				ob.InReplyTo = m.rewrite(ob.InReplyTo.GetLink())
+			}
+			// The replies collection points to the origin server, we'll have our own
+			ob.Replies = nil
+			ob.Likes = nil
+			ob.Shares = nil
+			ob.Attachment = m.importAttachments(ob.Attachment, ob)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = m.process(act); err != nil {
+			return err
+		}
+		if !vocab.IsNil(act.Object) && oldID != "" {
+			m.ids[oldID] = act.Object.GetLink()
+		}
+		m.notes++
+		return nil
+	})
+}
+
+func (m *mastodonImport) importAnnounce(it vocab.Item) error {
+	return vocab.OnActivity(it, func(act *vocab.Activity) error {
+		if vocab.IsNil(act.Object) {
+			return errors.Newf("missing object")
+		}
+		act.Object = m.rewrite(act.Object.GetLink())
+		if _, err := m.process(act); err != nil {
+			return err
+		}
+		m.announces++
+		return nil
+	})
+}
+
+func (m *mastodonImport) importAttachments(att vocab.Item, parent *vocab.Object) vocab.Item {
+	if vocab.IsNil(att) {
+		return nil
+	}
+	if !vocab.IsItemCollection(att) {
+		return m.saveMedia(att, parent)
+	}
+	res := make(vocab.ItemCollection, 0)
+	_ = vocab.OnItemCollection(att, func(col *vocab.ItemCollection) error {
+		for _, it := range *col {
+			if media := m.saveMedia(it, parent); media != nil {
+				res = append(res, media)
+			}
+		}
+		return nil
+	})
+	return res
+}
+
+// saveMedia creates a local object for the media file of the archive that the "it" object points to,
+// with the same recipients and publishing time as the parent object to which it's attached.
+// The file is saved as a data URI in the content of the object. The files that are too big keep pointing
+// to their origin server.
+func (m *mastodonImport) saveMedia(it vocab.Item, parent *vocab.Object) vocab.Item {
+	if vocab.IsNil(it) {
+		return nil
+	}
+	var media *vocab.Object
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		media = ob
+		return nil
+	})
+	if media == nil || vocab.IsNil(media.URL) {
+		return nil
+	}
+	// The archive contains the files at the path of their URL on the origin server
+	name := media.URL.GetLink().String()
+	if u, err := media.URL.GetLink().URL(); err == nil {
+		name = u.Path
+	}
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !fs.ValidPath(name) {
+		m.skipped++
+		return nil
+	}
+	file := filepath.Join(m.dir, filepath.FromSlash(name))
+	fi, err := os.Stat(file)
+	if err != nil {
+		m.skipped++
+		return nil
+	}
+	if fi.Size() > m.maxMediaSize {
+		m.skipped++
+		return &vocab.Object{Type: mediaObjectType(media.MediaType), MediaType: media.MediaType, Name: media.Name, URL: media.URL}
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		m.skipped++
+		return nil
+	}
+
+	ob := mediaObject(media, parent, raw)
+	ob.AttributedTo = m.actor.ID
+	create := &vocab.Activity{Type: vocab.CreateType, Published: ob.Published, To: ob.To, CC: ob.CC, Bto: ob.Bto, BCC: ob.BCC, Object: ob}
+	if _, err = m.process(create); err != nil {
+		Errf(m.ctl.err, "Unable to save media %s: %s", name, err)
+		m.skipped++
+		return nil
+	}
+	m.media++
+	return &vocab.Object{ID: ob.ID, Type: ob.Type, MediaType: ob.MediaType, Name: ob.Name, URL: ob.ID}
+}
+
+func mediaObjectType(mediaType vocab.MimeType) vocab.ActivityVocabularyType {
+	switch {
+	case strings.HasPrefix(string(mediaType), "image/"):
+		return vocab.ImageType
+	case strings.HasPrefix(string(mediaType), "video/"):
+		return vocab.VideoType
+	case strings.HasPrefix(string(mediaType), "audio/"):
+		return vocab.AudioType
+	}
+	return vocab.DocumentType
+}
+
+// mediaObject builds the object for the contents of the media file, addressed to the recipients of its parent.
+func mediaObject(media, parent *vocab.Object, raw []byte) *vocab.Object {
+	return &vocab.Object{
+		Type:      mediaObjectType(media.MediaType),
+		MediaType: media.MediaType,
+		Name:      media.Name,
+		Published: parent.Published,
+		To:        parent.To,
+		CC:        parent.CC,
+		Bto:       parent.Bto,
+		BCC:       parent.BCC,
+		Content:   vocab.DefaultNaturalLanguage(fmt.Sprintf("data:%s;base64,%s", media.MediaType, base64.StdEncoding.EncodeToString(raw))),
+	}
+}
+
+func (m *mastodonImport) importLikes() error {
+	it, err := m.loadJSON("likes.json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Annotatef(err, "unable to load likes.json")
+	}
+	return vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		for _, liked := range col.Collection() {
+			like := &vocab.Activity{
+				Type:   vocab.LikeType,
+				To:     vocab.ItemCollection{vocab.PublicNS},
+				Object: m.rewrite(liked.GetLink()),
+			}
+			if _, err := m.process(like); err != nil {
+				Errf(m.ctl.err, "Unable to import like for %s: %s", liked.GetLink(), err)
+				m.skipped++
+				continue
+			}
+			m.likes++
+		}
+		return nil
+	})
+}
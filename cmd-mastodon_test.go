@@ -0,0 +1,118 @@
+package fedbox
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestMastodonImport_rewrite(t *testing.T) {
+	origin := vocab.IRI("https://mastodon.example/users/alice")
+	actor := &vocab.Actor{ID: "https://fedbox.example/actors/alice"}
+	m := mastodonImport{
+		actor:  actor,
+		origin: origin,
+		ids: map[vocab.IRI]vocab.IRI{
+			"https://mastodon.example/users/alice/statuses/1": "https://fedbox.example/objects/1",
+		},
+	}
+
+	tests := []struct {
+		iri  vocab.IRI
+		want vocab.IRI
+	}{
+		{iri: origin, want: actor.ID},
+		{iri: vocab.Followers.IRI(origin), want: vocab.Followers.IRI(actor)},
+		{iri: vocab.Following.IRI(origin), want: vocab.Following.IRI(actor)},
+		{iri: "https://mastodon.example/users/alice/statuses/1", want: "https://fedbox.example/objects/1"},
+		{iri: "https://mastodon.example/users/alice/statuses/2", want: "https://mastodon.example/users/alice/statuses/2"},
+		{iri: vocab.PublicNS, want: vocab.PublicNS},
+		{iri: "https://mastodon.example/users/bob", want: "https://mastodon.example/users/bob"},
+	}
+	for _, tt := range tests {
+		if got := m.rewrite(tt.iri); !got.Equal(tt.want) {
+			t.Errorf("rewrite(%s) = %s, want %s", tt.iri, got, tt.want)
+		}
+	}
+
+	got := m.rewriteAll(vocab.ItemCollection{vocab.PublicNS, vocab.Followers.IRI(origin)})
+	want := vocab.ItemCollection{vocab.PublicNS, vocab.Followers.IRI(actor)}
+	if len(got) != len(want) || !got[0].GetLink().Equal(want[0].GetLink()) || !got[1].GetLink().Equal(want[1].GetLink()) {
+		t.Errorf("rewriteAll() = %v, want %v", got, want)
+	}
+}
+
+func TestSortByPublished(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := vocab.ItemCollection{
+		&vocab.Activity{ID: "3", Published: day.Add(2 * time.Hour)},
+		&vocab.Activity{ID: "1", Published: day},
+		&vocab.Activity{ID: "2a", Published: day.Add(time.Hour)},
+		&vocab.Activity{ID: "2b", Published: day.Add(time.Hour)},
+	}
+	sortByPublished(items)
+
+	want := []vocab.IRI{"1", "2a", "2b", "3"}
+	for i, it := range items {
+		if !it.GetLink().Equal(want[i]) {
+			t.Errorf("item %d is %s, want %s", i, it.GetLink(), want[i])
+		}
+	}
+}
+
+func TestMediaObject(t *testing.T) {
+	published := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	parent := &vocab.Object{
+		Published: published,
+		To:        vocab.ItemCollection{vocab.IRI("https://fedbox.example/actors/bob")},
+		CC:        vocab.ItemCollection{vocab.IRI("https://fedbox.example/actors/alice/followers")},
+	}
+	media := &vocab.Object{MediaType: "image/png", Name: vocab.DefaultNaturalLanguage("a picture")}
+
+	ob := mediaObject(media, parent, []byte("png"))
+	if ob.Type != vocab.ImageType {
+		t.Errorf("type = %s, want %s", ob.Type, vocab.ImageType)
+	}
+	if !ob.Published.Equal(published) {
+		t.Errorf("published = %s, want the time of the parent %s", ob.Published, published)
+	}
+	if len(ob.To) != 1 || !ob.To[0].GetLink().Equal(parent.To[0].GetLink()) || len(ob.CC) != 1 {
+		t.Errorf("the media is not addressed to the recipients of the parent: to %v, cc %v", ob.To, ob.CC)
+	}
+	if ob.To.Contains(vocab.PublicNS) {
+		t.Errorf("the media of a non public object is public")
+	}
+	if got := ob.Content.First().String(); got != "data:image/png;base64,cG5n" {
+		t.Errorf("content = %s", got)
+	}
+}
+
+func TestExtractTarGz_MaxSize(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "archive.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"outbox.json", "media/1.png"} {
+		content := []byte("0123456789")
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write(content)
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	_ = f.Close()
+
+	if err = extractTarGz(archive, t.TempDir(), 20); err != nil {
+		t.Errorf("extractTarGz() error = %s", err)
+	}
+	if err = extractTarGz(archive, t.TempDir(), 15); err == nil {
+		t.Errorf("extractTarGz() expected error for an archive over the size limit")
+	}
+}
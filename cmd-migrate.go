@@ -0,0 +1,414 @@
+package fedbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+type MigrateCmd struct {
+	ToType     storage.Type `name:"to-type" required:"" help:"Type of the target storage: ${storageTypes}"`
+	ToPath     string       `name:"to-path" required:"" type:"path" help:"The path of the target storage."`
+	SkipTokens bool         `name:"skip-tokens" help:"Don't copy the OAuth2 authorizations and access tokens, the users will need to log in again. Only the tokens issued through FedBOX can be copied."`
+}
+
+// migration copies the contents of one storage to another. The steps that have been completed are saved to a
+// checkpoint file, so an interrupted migration can be resumed by running the same command again.
+type migration struct {
+	ctl  *Base
+	src  storage.FullStorage
+	dst  storage.FullStorage
+	done map[string]struct{}
+	cp   *os.File
+
+	skipTokens bool
+	// collections are the IRIs of all the collections that have been copied, which are compared at the end.
+	collections vocab.IRIs
+	// authorizations and accesses are the codes of the OAuth2 records that have been copied.
+	authorizations []string
+	accesses       []string
+}
+
+const migrateProgressStep = 100
+
+func (m MigrateCmd) Run(ctl *Base) error {
+	conf := ctl.Conf
+	conf.Storage = m.ToType
+	conf.StoragePath = m.ToPath
+	if err := canMigrate(ctl.Conf, conf); err != nil {
+		return err
+	}
+	path, err := conf.BaseStoragePath()
+	if err != nil {
+		return err
+	}
+	initFns, err := conf.StorageInitFns(ctl.Logger)
+	if err != nil {
+		return err
+	}
+
+	if ctl.pauseWrites != nil {
+		resume := ctl.pauseWrites()
+		defer resume()
+	}
+
+	cpPath := path + ".migrate-checkpoint"
+	done, err := loadCheckpoint(cpPath)
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		if entries, _ := os.ReadDir(path); len(entries) > 0 {
+			return errors.Conflictf("the target storage path %s is not empty", path)
+		}
+		if err = storage.Bootstrap(initFns...); err != nil {
+			return errors.Annotatef(err, "unable to bootstrap %s storage at %s", conf.Storage, path)
+		}
+	} else {
+		_, _ = fmt.Fprintf(ctl.out, "Resuming migration, %d steps already done\n", len(done))
+	}
+
+	dst, err := storage.New(initFns...)
+	if err != nil {
+		return err
+	}
+	if err = dst.Open(); err != nil {
+		return errors.Annotatef(err, "unable to open %s storage at %s", conf.Storage, path)
+	}
+	defer dst.Close()
+
+	cp, err := os.OpenFile(cpPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer cp.Close()
+
+	mig := migration{ctl: ctl, src: ctl.Storage, dst: dst, done: done, cp: cp, skipTokens: m.SkipTokens}
+	if err = mig.run(); err != nil {
+		return err
+	}
+	if err = mig.compare(); err != nil {
+		return err
+	}
+	_ = cp.Close()
+	_ = os.Remove(cpPath)
+	_, _ = fmt.Fprintf(ctl.out, "Migrated %s storage to %s storage at %s\n", ctl.Conf.Storage, conf.Storage, path)
+	return nil
+}
+
+func canMigrate(from, to config.Options) error {
+	if to.Storage == "" {
+		return errors.Newf("missing target storage type")
+	}
+	if from.Storage == to.Storage && from.StoragePath == to.StoragePath {
+		return errors.Newf("the target storage is the same as the source")
+	}
+	return nil
+}
+
+func loadCheckpoint(path string) (map[string]struct{}, error) {
+	done := make(map[string]struct{})
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return done, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			done[line] = struct{}{}
+		}
+	}
+	return done, s.Err()
+}
+
+// step runs fn, unless the key is present in the checkpoint, in which case it has been run by a previous invocation.
+func (m *migration) step(key string, fn func() error) error {
+	if _, ok := m.done[key]; ok {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	m.done[key] = struct{}{}
+	_, err := fmt.Fprintln(m.cp, key)
+	return err
+}
+
+func (m *migration) progress(what string, cur, total int) {
+	if cur%migrateProgressStep != 0 && cur != total {
+		return
+	}
+	if total == 0 {
+		_, _ = fmt.Fprintf(m.ctl.out, "%s: %d\n", what, cur)
+		return
+	}
+	_, _ = fmt.Fprintf(m.ctl.out, "%s: %d/%d\n", what, cur, total)
+}
+
+// sources returns the collections that contain all the items of the storage: the streams, and the audit log,
+// whose entries are saved under the service actor.
+func (m *migration) sources() vocab.IRIs {
+	baseURL := vocab.IRI(m.ctl.Conf.BaseURL)
+	sources := vocab.IRIs{AuditIRI(m.ctl.Service)}
+	for _, col := range streamCollections {
+		sources = append(sources, vocab.IRIf(baseURL, col))
+	}
+	return sources
+}
+
+func (m *migration) run() error {
+	ctl := m.ctl
+
+	// We gather the collections of the items while we copy them, and copy their members after,
+	// so the items they reference already exist in the target storage
+	m.collections = m.sources()
+	copied := 0
+	copyItem := func(it vocab.Item) error {
+		err := m.step("item "+it.GetLink().String(), func() error {
+			return m.copyItem(it)
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to copy %s", it.GetLink())
+		}
+		if vocab.ActorTypes.Match(it.GetType()) {
+			m.collections = append(m.collections, getActorCollections(it)...)
+		} else if !vocab.IsCollection(it) {
+			m.collections = append(m.collections, getObjectCollections(it)...)
+		}
+		copied++
+		m.progress("Items", copied, 0)
+		return nil
+	}
+
+	if err := copyItem(ctl.Service); err != nil {
+		return err
+	}
+	for _, iri := range m.sources() {
+		if err := streamCollection(ctl, iri, copyItem); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Items: %d\n", copied)
+
+	for i, col := range m.collections {
+		err := m.step("collection "+col.String(), func() error {
+			return m.copyCollection(col)
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to copy collection %s", col)
+		}
+		m.progress("Collections", i+1, len(m.collections))
+	}
+
+	if err := m.step("oauth clients", m.copyClients); err != nil {
+		return errors.Annotatef(err, "unable to copy OAuth2 clients")
+	}
+	if m.skipTokens {
+		_, _ = fmt.Fprintf(ctl.out, "Skipping OAuth2 authorizations and access tokens\n")
+		return nil
+	}
+	_, _ = fmt.Fprintf(ctl.out, "OAuth2: %d authorizations, %d access tokens\n", len(m.authorizations), len(m.accesses))
+	return nil
+}
+
+// copyItem saves the item in the target storage, together with its metadata, which holds
+// the password hash and the private keys of actors, and the information moderators attached to any item.
+// The OAuth2 records indexed in the metadata of the actors are copied with it.
+func (m *migration) copyItem(it vocab.Item) error {
+	if _, err := m.dst.Save(it); err != nil {
+		return err
+	}
+	meta := new(ap.Metadata)
+	if err := m.src.LoadMetadata(it.GetLink(), meta); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := m.copyTokens(meta); err != nil {
+		return errors.Annotatef(err, "unable to copy OAuth2 tokens")
+	}
+	return m.dst.SaveMetadata(it.GetLink(), meta)
+}
+
+func (m *migration) copyCollection(iri vocab.IRI) error {
+	it, err := m.src.Load(iri)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if _, err = m.dst.Load(iri); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err = m.dst.Create(emptyCollection(m.ctl, it)); err != nil {
+			return err
+		}
+	}
+	// The members are added one page at a time, so big collections don't need to fit in memory
+	members := make(vocab.ItemCollection, 0, exportPageSize)
+	err = streamCollection(m.ctl, iri, func(member vocab.Item) error {
+		if members = append(members, member.GetLink()); len(members) < exportPageSize {
+			return nil
+		}
+		err := m.dst.AddTo(iri, members...)
+		members = members[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return m.dst.AddTo(iri, members...)
+}
+
+// emptyCollection returns a collection with the same properties as "it", but without its items.
+func emptyCollection(ctl *Base, it vocab.Item) vocab.CollectionInterface {
+	col := newOrderedCollection(ctl, it.GetLink())
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		col.Type = vocab.OrderedCollectionType
+		col.AttributedTo = ob.AttributedTo
+		col.To = ob.To
+		col.CC = ob.CC
+		col.Bto = ob.Bto
+		col.BCC = ob.BCC
+		if !ob.Published.IsZero() {
+			col.Published = ob.Published
+		}
+		return nil
+	})
+	return col
+}
+
+func (m *migration) copyClients() error {
+	clients, err := m.src.ListClients()
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if _, err = m.dst.GetClient(c.GetId()); err == nil {
+			continue
+		}
+		if err = m.dst.CreateClient(c); err != nil {
+			return errors.Annotatef(err, "unable to copy client %s", c.GetId())
+		}
+	}
+	return nil
+}
+
+// copyTokens copies the OAuth2 records indexed in the metadata, and it updates the index with the ones
+// that have been copied.
+func (m *migration) copyTokens(meta *ap.Metadata) error {
+	authorizations, accesses := actorTokens(m.src, meta)
+	meta.Authorizations = meta.Authorizations[:0]
+	meta.AccessTokens = meta.AccessTokens[:0]
+	if m.skipTokens {
+		return nil
+	}
+	for _, a := range authorizations {
+		if err := m.dst.SaveAuthorize(a); err != nil {
+			return err
+		}
+		meta.Authorizations = append(meta.Authorizations, a.Code)
+	}
+	for _, a := range accesses {
+		if err := m.dst.SaveAccess(a); err != nil {
+			return err
+		}
+		meta.AccessTokens = append(meta.AccessTokens, a.AccessToken)
+	}
+	m.authorizations = append(m.authorizations, meta.Authorizations...)
+	m.accesses = append(m.accesses, meta.AccessTokens...)
+	return nil
+}
+
+// countItems returns the number of items in the collection of the db storage, which it loads one page at a time.
+func countItems(db storage.FullStorage, iri vocab.IRI) int {
+	count := 0
+	_ = streamCollection(&Base{Storage: db}, iri, func(vocab.Item) error {
+		count++
+		return nil
+	})
+	return count
+}
+
+// countLoaded returns how many of the codes can be loaded.
+func countLoaded(codes []string, load func(string) error) int {
+	count := 0
+	for _, code := range codes {
+		if load(code) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// compare checks that the target storage contains the same number of items as the source, for all the
+// collections that have been copied, the OAuth2 clients, and the tokens.
+func (m *migration) compare() error {
+	mismatches := make([]string, 0)
+	row := func(name string, src, dst int) {
+		_, _ = fmt.Fprintf(m.ctl.out, "%-16s %10d %10d\n", name, src, dst)
+		if src != dst {
+			mismatches = append(mismatches, name)
+		}
+	}
+
+	_, _ = fmt.Fprintf(m.ctl.out, "%-16s %10s %10s\n", "", "source", "target")
+	sources := m.sources()
+	for _, iri := range sources {
+		row(path.Base(iri.String()), countItems(m.src, iri), countItems(m.dst, iri))
+	}
+
+	// For the collections of the actors and objects we only list the ones that differ
+	checked, differ := 0, make([]string, 0)
+	for _, iri := range m.collections {
+		if sources.Contains(iri) {
+			continue
+		}
+		checked++
+		if countItems(m.src, iri) != countItems(m.dst, iri) {
+			differ = append(differ, iri.String())
+		}
+	}
+	_, _ = fmt.Fprintf(m.ctl.out, "%-16s %10d %10d\n", "collections", checked, checked-len(differ))
+	mismatches = append(mismatches, differ...)
+
+	srcClients, _ := m.src.ListClients()
+	dstClients, _ := m.dst.ListClients()
+	row("clients", len(srcClients), len(dstClients))
+
+	if !m.skipTokens {
+		row("authorizations", len(m.authorizations), countLoaded(m.authorizations, func(code string) error {
+			_, err := m.dst.LoadAuthorize(code)
+			return err
+		}))
+		row("access tokens", len(m.accesses), countLoaded(m.accesses, func(token string) error {
+			_, err := m.dst.LoadAccess(token)
+			return err
+		}))
+	}
+
+	if len(mismatches) > 0 {
+		return errors.Newf("the number of items differs for: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
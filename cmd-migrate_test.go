@@ -0,0 +1,166 @@
+package fedbox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/openshift/osin"
+)
+
+// migrationTestSource returns a storage with an actor that has more items in its outbox
+// than fit in a page, a role, an OAuth2 client, and tokens.
+func migrationTestSource(t *testing.T) (*Base, *memStorage, *vocab.Actor) {
+	t.Helper()
+
+	ctl, src := checkTestBase(t)
+	ctl.out = io.Discard
+	actor := &vocab.Actor{ID: checkBaseURL + "/actors/jdoe", Type: vocab.PersonType}
+	actor.Outbox = vocab.Outbox.IRI(actor)
+	_, _ = src.Save(actor)
+	_ = src.AddTo(vocab.IRIf(checkBaseURL, "actors"), actor)
+	_, _ = src.Save(newOrderedCollection(ctl, actor.Outbox.GetLink()))
+	for i := 0; i < exportPageSize+50; i++ {
+		note := &vocab.Object{ID: vocab.IRI(fmt.Sprintf("%s/objects/%d", checkBaseURL, i)), Type: vocab.NoteType, AttributedTo: actor.ID}
+		_, _ = src.Save(note)
+		_ = src.AddTo(vocab.IRIf(checkBaseURL, "objects"), note)
+		_ = src.AddTo(actor.Outbox.GetLink(), note)
+	}
+	_ = src.SaveMetadata(actor.ID, &ap.Metadata{Role: ap.RoleModerator})
+	_ = src.CreateClient(&osin.DefaultClient{Id: "client"})
+
+	idx := withTokenIndex(src, ctl.Service.ID)
+	if err := idx.SaveAuthorize(&osin.AuthorizeData{Code: "code", UserData: actor.ID}); err != nil {
+		t.Fatalf("SaveAuthorize() error = %s", err)
+	}
+	if err := idx.SaveAccess(&osin.AccessData{AccessToken: "token", UserData: actor.ID}); err != nil {
+		t.Fatalf("SaveAccess() error = %s", err)
+	}
+	return ctl, src, actor
+}
+
+func newTestMigration(t *testing.T, ctl *Base, dst *memStorage, cpPath string) *migration {
+	t.Helper()
+
+	done, err := loadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatalf("loadCheckpoint() error = %s", err)
+	}
+	cp, err := os.OpenFile(cpPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cp.Close() })
+	return &migration{ctl: ctl, src: ctl.Storage, dst: dst, done: done, cp: cp}
+}
+
+func TestMigration_copy(t *testing.T) {
+	ctl, _, actor := migrationTestSource(t)
+	dst := newMemStorage()
+
+	m := newTestMigration(t, ctl, dst, filepath.Join(t.TempDir(), "checkpoint"))
+	if err := m.run(); err != nil {
+		t.Fatalf("run() error = %+v", err)
+	}
+	if err := m.compare(); err != nil {
+		t.Errorf("compare() error = %s", err)
+	}
+
+	if got := countItems(dst, actor.Outbox.GetLink()); got != exportPageSize+50 {
+		t.Errorf("outbox items = %d, want %d", got, exportPageSize+50)
+	}
+	meta := new(ap.Metadata)
+	if err := dst.LoadMetadata(actor.ID, meta); err != nil || meta.Role != ap.RoleModerator {
+		t.Errorf("metadata = %+v, %v, expected the role to be copied", meta, err)
+	}
+	if _, err := dst.LoadAuthorize("code"); err != nil {
+		t.Errorf("LoadAuthorize() error = %s, expected the authorization to be copied", err)
+	}
+	if _, err := dst.LoadAccess("token"); err != nil {
+		t.Errorf("LoadAccess() error = %s, expected the access token to be copied", err)
+	}
+	if len(meta.AccessTokens) != 1 || len(meta.Authorizations) != 1 {
+		t.Errorf("metadata tokens = %v %v, expected them to be indexed", meta.Authorizations, meta.AccessTokens)
+	}
+	if _, err := dst.GetClient("client"); err != nil {
+		t.Errorf("GetClient() error = %s, expected the client to be copied", err)
+	}
+}
+
+func TestMigration_skipTokens(t *testing.T) {
+	ctl, _, actor := migrationTestSource(t)
+	dst := newMemStorage()
+
+	m := newTestMigration(t, ctl, dst, filepath.Join(t.TempDir(), "checkpoint"))
+	m.skipTokens = true
+	if err := m.run(); err != nil {
+		t.Fatalf("run() error = %+v", err)
+	}
+	if len(dst.access) > 0 || len(dst.authorize) > 0 {
+		t.Errorf("expected no tokens to be copied, got %d authorizations and %d access tokens", len(dst.authorize), len(dst.access))
+	}
+	meta := new(ap.Metadata)
+	_ = dst.LoadMetadata(actor.ID, meta)
+	if len(meta.AccessTokens) > 0 || len(meta.Authorizations) > 0 {
+		t.Errorf("metadata tokens = %v %v, expected them to be dropped", meta.Authorizations, meta.AccessTokens)
+	}
+}
+
+func TestMigration_resume(t *testing.T) {
+	ctl, _, actor := migrationTestSource(t)
+	dst := newMemStorage()
+
+	cpPath := filepath.Join(t.TempDir(), "checkpoint")
+	skipped := vocab.IRI(checkBaseURL + "/objects/0")
+	if err := os.WriteFile(cpPath, []byte("item "+skipped.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMigration(t, ctl, dst, cpPath)
+	if err := m.run(); err != nil {
+		t.Fatalf("run() error = %+v", err)
+	}
+	if _, ok := dst.items[skipped]; ok {
+		t.Errorf("the item %s was done in a previous run, and it should not have been copied again", skipped)
+	}
+	if _, ok := dst.items[actor.ID]; !ok {
+		t.Errorf("the item %s was not copied", actor.ID)
+	}
+
+	done, err := loadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatalf("loadCheckpoint() error = %s", err)
+	}
+	for _, key := range []string{"item " + actor.ID.String(), "collection " + actor.Outbox.GetLink().String(), "oauth clients"} {
+		if _, ok := done[key]; !ok {
+			t.Errorf("the checkpoint is missing the %q step", key)
+		}
+	}
+}
+
+func TestMigration_compare(t *testing.T) {
+	ctl, _, actor := migrationTestSource(t)
+	dst := newMemStorage()
+
+	m := newTestMigration(t, ctl, dst, filepath.Join(t.TempDir(), "checkpoint"))
+	if err := m.run(); err != nil {
+		t.Fatalf("run() error = %+v", err)
+	}
+	_ = dst.RemoveFrom(actor.Outbox.GetLink(), vocab.IRI(checkBaseURL+"/objects/1"))
+	_ = dst.RemoveAccess("token")
+
+	err := m.compare()
+	if err == nil {
+		t.Fatalf("compare() expected an error for the missing items")
+	}
+	for _, name := range []string{actor.Outbox.GetLink().String(), "access tokens"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("compare() error = %s, expected it to mention %s", err, name)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
+	"github.com/openshift/osin"
+)
+
+type RehomeCmd struct {
+	From vocab.IRI `required:"" help:"The current base URL of the instance."`
+	To   vocab.IRI `required:"" help:"The new base URL of the instance."`
+	Move bool      `help:"Send Move activities for the local actors, so their followers know about their new IRIs. The old hostname needs to keep pointing to the instance."`
+}
+
+// rehome rewrites the IRIs that start with the "from" base URL to start with "to".
+type rehome struct {
+	ctl      *Base
+	from, to string
+	move     bool
+	// moved holds the old IRIs of the actors, for which we send the Move activities.
+	moved vocab.IRIs
+
+	items, collections, metadata, clients, moves int
+}
+
+func (c RehomeCmd) Run(ctl *Base) error {
+	from := strings.TrimRight(c.From.String(), "/")
+	to := strings.TrimRight(c.To.String(), "/")
+	for _, u := range []string{from, to} {
+		if uu, err := vocab.IRI(u).URL(); err != nil || uu.Host == "" {
+			return errors.Newf("invalid base URL %q", u)
+		}
+	}
+	if from == to {
+		return errors.Newf("the new base URL is the same as the current one")
+	}
+	if ctl.pauseWrites != nil {
+		resume := ctl.pauseWrites()
+		defer resume()
+	}
+
+	r := rehome{ctl: ctl, from: from, to: to, move: c.Move}
+
+	// We load only the IRIs of the items, but we need the list of collections
+	// before the items that own them get rewritten
+	baseURL := vocab.IRI(ctl.Conf.BaseURL)
+	iris := vocab.IRIs{ctl.Service.ID}
+	seen := map[vocab.IRI]struct{}{ctl.Service.ID: {}}
+	sources := vocab.IRIs{AuditIRI(ctl.Service)}
+	for _, col := range streamCollections {
+		sources = append(sources, vocab.IRIf(baseURL, col))
+	}
+	collections := append(vocab.IRIs{}, sources...)
+	collections = append(collections, getActorCollections(ctl.Service)...)
+	for _, src := range sources {
+		err := streamCollection(ctl, src, func(it vocab.Item) error {
+			if _, ok := seen[it.GetLink()]; ok {
+				return nil
+			}
+			seen[it.GetLink()] = struct{}{}
+			iris = append(iris, it.GetLink())
+			if vocab.ActorTypes.Match(it.GetType()) {
+				collections = append(collections, getActorCollections(it)...)
+			} else if !vocab.IsCollection(it) {
+				collections = append(collections, getObjectCollections(it)...)
+			}
+			return nil
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	service := ctl.Service
+	for _, iri := range iris {
+		it, err := ctl.Storage.Load(iri)
+		if err != nil {
+			Errf(ctl.err, "Unable to load %s: %s", iri, err)
+			continue
+		}
+		moved, err := r.rehomeItem(it)
+		if err != nil {
+			Errf(ctl.err, "Unable to rehome %s: %s", iri, err)
+			continue
+		}
+		if iri.Equal(ctl.Service.ID) {
+			_ = vocab.OnActor(moved, func(act *vocab.Actor) error {
+				service = *act
+				return nil
+			})
+		}
+	}
+	for _, iri := range collections {
+		if err := r.rehomeCollection(iri); err != nil {
+			Errf(ctl.err, "Unable to rehome collection %s: %s", iri, err)
+		}
+	}
+	if err := r.rehomeClients(); err != nil {
+		Errf(ctl.err, "Unable to rehome OAuth2 clients: %s", err)
+	}
+	if c.Move {
+		// The Move activities get their IDs, and are processed, as activities of the rehomed instance
+		ctl.Service = service
+		ctl.Conf.BaseURL = r.rewriteIRI(ctl.Conf.BaseURL)
+		for _, iri := range r.moved {
+			if err := r.sendMove(iri); err != nil {
+				Errf(ctl.err, "Unable to send Move for %s: %s", iri, err)
+			}
+		}
+	}
+
+	_, _ = fmt.Fprintf(ctl.out, "Rehomed %d items, %d collections, %d metadata records and %d OAuth2 clients, sent %d Move activities\n",
+		r.items, r.collections, r.metadata, r.clients, r.moves)
+	_, _ = fmt.Fprintf(ctl.out, "Please change the hostname of the instance in its configuration to match %s\n", to)
+	return nil
+}
+
+// rewriteIRI returns the IRI with the new base URL, if it starts with the old one.
+func (r rehome) rewriteIRI(s string) string {
+	if s == r.from {
+		return r.to
+	}
+	rest, ok := strings.CutPrefix(s, r.from)
+	if ok && len(rest) > 0 && strings.ContainsRune("/?#", rune(rest[0])) {
+		return r.to + rest
+	}
+	return s
+}
+
+// rewriteValue walks a decoded JSON value and rewrites all the strings that are IRIs with the old base URL.
+// The IRIs that are part of other strings, like links in the content, are left as they are.
+func (r rehome) rewriteValue(v any) any {
+	switch vv := v.(type) {
+	case string:
+		return r.rewriteIRI(vv)
+	case []any:
+		for i := range vv {
+			vv[i] = r.rewriteValue(vv[i])
+		}
+	case map[string]any:
+		for k := range vv {
+			vv[k] = r.rewriteValue(vv[k])
+		}
+	}
+	return v
+}
+
+func (r rehome) rewriteItem(it vocab.Item) (vocab.Item, error) {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err = dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if raw, err = json.Marshal(r.rewriteValue(doc)); err != nil {
+		return nil, err
+	}
+	return vocab.UnmarshalJSON(raw)
+}
+
+// rehomeItem saves the item with its new IRIs, together with its metadata, and removes the old one.
+func (r *rehome) rehomeItem(it vocab.Item) (vocab.Item, error) {
+	st := r.ctl.Storage
+	moved, err := r.rewriteItem(it)
+	if err != nil {
+		return nil, err
+	}
+	if moved, err = st.Save(moved); err != nil {
+		return nil, err
+	}
+	r.items++
+	if moved.GetLink().Equal(it.GetLink()) {
+		return moved, nil
+	}
+
+	if !vocab.ActorTypes.Match(it.GetType()) {
+		return moved, st.Delete(it)
+	}
+
+	m := new(ap.Metadata)
+	hasMetadata := st.LoadMetadata(it.GetLink(), m) == nil
+	old := new(ap.Metadata)
+	if r.move {
+		// The old actor is kept, so its representation can point to the new IRI, together with its
+		// key, which the remote servers know, for signing the Move activity
+		old.PrivateKey = m.PrivateKey
+		old.MovedTo = moved.GetLink()
+		m.AlsoKnownAs = append(m.AlsoKnownAs, it.GetLink())
+		hasMetadata = true
+	}
+	if hasMetadata {
+		r.rewriteMetadata(m)
+		if err = st.SaveMetadata(moved.GetLink(), m); err != nil {
+			return nil, errors.Annotatef(err, "unable to save metadata")
+		}
+		// The storage has no way of removing metadata, so we clear it
+		_ = st.SaveMetadata(it.GetLink(), old)
+		r.metadata++
+	}
+	if r.move {
+		r.moved = append(r.moved, it.GetLink())
+		return moved, nil
+	}
+	return moved, st.Delete(it)
+}
+
+func (r rehome) rewriteMetadata(m *ap.Metadata) {
+	for i := range m.Keys {
+		m.Keys[i].ID = vocab.IRI(r.rewriteIRI(m.Keys[i].ID.String()))
+	}
+	for i := range m.HostKeys {
+		m.HostKeys[i].ID = vocab.IRI(r.rewriteIRI(m.HostKeys[i].ID.String()))
+	}
+}
+
+// rehomeCollection creates the collection with its new IRI, with the members rewritten, and removes the old one.
+// The members are added one page at a time, and the old collection is removed only after all of them have been.
+func (r *rehome) rehomeCollection(iri vocab.IRI) error {
+	st := r.ctl.Storage
+	it, err := st.Load(iri, filters.WithMaxCount(1))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	newIRI := vocab.IRI(r.rewriteIRI(iri.String()))
+	if newIRI.Equal(iri) {
+		return nil
+	}
+
+	col := emptyCollection(r.ctl, it)
+	moved, err := r.rewriteItem(col)
+	if err != nil {
+		return err
+	}
+	if _, err = st.Load(newIRI); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		newCol, ok := moved.(vocab.CollectionInterface)
+		if !ok {
+			return errors.Newf("invalid collection %T", moved)
+		}
+		if _, err = st.Create(newCol); err != nil {
+			return err
+		}
+	}
+
+	members := make(vocab.ItemCollection, 0, exportPageSize)
+	err = streamCollection(r.ctl, iri, func(member vocab.Item) error {
+		if members = append(members, vocab.IRI(r.rewriteIRI(member.GetLink().String()))); len(members) < exportPageSize {
+			return nil
+		}
+		err := st.AddTo(newIRI, members...)
+		members = members[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		if err = st.AddTo(newIRI, members...); err != nil {
+			return err
+		}
+	}
+	r.collections++
+	return st.Delete(it)
+}
+
+// rehomeClients rewrites the IDs, redirect URIs and user data of the OAuth2 clients.
+func (r *rehome) rehomeClients() error {
+	st := r.ctl.Storage
+	clients, err := st.ListClients()
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		moved := &osin.DefaultClient{
+			Id:          r.rewriteIRI(c.GetId()),
+			Secret:      c.GetSecret(),
+			RedirectUri: r.rewriteRedirectURIs(c.GetRedirectUri()),
+			UserData:    c.GetUserData(),
+		}
+		if s, ok := c.GetUserData().(string); ok {
+			moved.UserData = r.rewriteIRI(s)
+		} else if iri, ok := c.GetUserData().(vocab.IRI); ok {
+			moved.UserData = vocab.IRI(r.rewriteIRI(iri.String()))
+		}
+		if moved.Id == c.GetId() {
+			if moved.RedirectUri == c.GetRedirectUri() {
+				continue
+			}
+			err = st.UpdateClient(moved)
+		} else if err = st.CreateClient(moved); err == nil {
+			err = st.RemoveClient(c.GetId())
+		}
+		if err != nil {
+			return errors.Annotatef(err, "unable to rehome client %s", c.GetId())
+		}
+		r.clients++
+	}
+	return nil
+}
+
+// rewriteRedirectURIs handles the redirect URIs of the clients, which can be a list separated by newlines.
+func (r rehome) rewriteRedirectURIs(s string) string {
+	uris := strings.Split(s, "\n")
+	for i, u := range uris {
+		uris[i] = r.rewriteIRI(u)
+	}
+	return strings.Join(uris, "\n")
+}
+
+// sendMove notifies the followers of the actor that it has moved to its new IRI. The Move has the old IRI
+// as its actor and object, and the new one as its target, which lists the old one in its alsoKnownAs property.
+// The remote servers verify the Move by loading the old IRI, so the old hostname needs
+// to keep pointing to the instance for a while.
+func (r *rehome) sendMove(oldIRI vocab.IRI) error {
+	old, err := ap.LoadActor(r.ctl.Storage, oldIRI)
+	if err != nil {
+		return err
+	}
+	newIRI := vocab.IRI(r.rewriteIRI(oldIRI.String()))
+	move := &vocab.Activity{
+		Type:   vocab.MoveType,
+		Actor:  oldIRI,
+		Object: oldIRI,
+		Target: newIRI,
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		CC:     vocab.ItemCollection{vocab.Followers.IRI(newIRI)},
+	}
+	// The activity is signed with the key of the old actor, and saved in the outbox of the new one
+	if _, err = r.ctl.Saver(&old, false).ProcessClientActivity(move, old, vocab.Outbox.IRI(newIRI)); err != nil {
+		return err
+	}
+	r.moves++
+	return nil
+}
@@ -0,0 +1,118 @@
+package fedbox
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+func TestRehome_rewriteIRI(t *testing.T) {
+	r := rehome{from: "https://old.example", to: "https://new.example"}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "https://old.example", want: "https://new.example"},
+		{in: "https://old.example/actors/1", want: "https://new.example/actors/1"},
+		{in: "https://old.example/actors/1#main", want: "https://new.example/actors/1#main"},
+		{in: "https://old.example?x=1", want: "https://new.example?x=1"},
+		{in: "https://old.example.org/actors/1", want: "https://old.example.org/actors/1"},
+		{in: "https://other.example/https://old.example/", want: "https://other.example/https://old.example/"},
+		{in: "not an IRI", want: "not an IRI"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := r.rewriteIRI(tt.in); got != tt.want {
+			t.Errorf("rewriteIRI(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRehome_rewriteValue(t *testing.T) {
+	r := rehome{from: "https://old.example", to: "https://new.example"}
+	in := map[string]any{
+		"id":      "https://old.example/objects/1",
+		"to":      []any{"https://www.w3.org/ns/activitystreams#Public", "https://old.example/actors/1/followers"},
+		"content": "see https://old.example/objects/2",
+		"tag":     []any{map[string]any{"href": "https://old.example/actors/2", "type": "Mention"}},
+		"count":   float64(2),
+	}
+	want := map[string]any{
+		"id":      "https://new.example/objects/1",
+		"to":      []any{"https://www.w3.org/ns/activitystreams#Public", "https://new.example/actors/1/followers"},
+		"content": "see https://old.example/objects/2",
+		"tag":     []any{map[string]any{"href": "https://new.example/actors/2", "type": "Mention"}},
+		"count":   float64(2),
+	}
+	if got := r.rewriteValue(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("rewriteValue() = %v, want %v", got, want)
+	}
+}
+
+func TestRehome_rehomeItemMove(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	r := rehome{ctl: ctl, from: checkBaseURL, to: "https://new.example", move: true}
+
+	actor := &vocab.Actor{ID: checkBaseURL + "/actors/1", Type: vocab.PersonType}
+	_, _ = db.Save(actor)
+	_ = db.SaveMetadata(actor.ID, &ap.Metadata{PrivateKey: []byte("key")})
+
+	moved, err := r.rehomeItem(actor)
+	if err != nil {
+		t.Fatalf("rehomeItem() error = %s", err)
+	}
+	if !moved.GetLink().Equal("https://new.example/actors/1") {
+		t.Errorf("the actor has been moved to %s", moved.GetLink())
+	}
+	if _, ok := db.items[actor.ID]; !ok {
+		t.Errorf("the old actor has been removed")
+	}
+
+	m := new(ap.Metadata)
+	_ = db.LoadMetadata(moved.GetLink(), m)
+	if len(m.AlsoKnownAs) != 1 || !m.AlsoKnownAs[0].Equal(actor.ID) || string(m.PrivateKey) != "key" {
+		t.Errorf("invalid metadata for the new actor: %+v", m)
+	}
+	old := new(ap.Metadata)
+	_ = db.LoadMetadata(actor.ID, old)
+	if !old.MovedTo.Equal(moved.GetLink()) || string(old.PrivateKey) != "key" {
+		t.Errorf("invalid metadata for the old actor: %+v", old)
+	}
+	if len(r.moved) != 1 || !r.moved[0].Equal(actor.ID) {
+		t.Errorf("expected a Move for %s, got %v", actor.ID, r.moved)
+	}
+}
+
+func TestRehome_rehomeCollection(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	r := rehome{ctl: ctl, from: checkBaseURL, to: "https://new.example"}
+
+	outbox := vocab.IRI(checkBaseURL + "/actors/1/outbox")
+	_, _ = db.Save(newOrderedCollection(ctl, outbox))
+	for i := 0; i < exportPageSize+50; i++ {
+		_ = db.AddTo(outbox, vocab.IRI(fmt.Sprintf("%s/objects/%d", checkBaseURL, i)))
+	}
+
+	if err := r.rehomeCollection(outbox); err != nil {
+		t.Fatalf("rehomeCollection() error = %s", err)
+	}
+	if _, ok := db.collections[outbox]; ok {
+		t.Errorf("the old collection %s has not been removed", outbox)
+	}
+	col, ok := db.collections["https://new.example/actors/1/outbox"]
+	if !ok {
+		t.Fatalf("the collection has not been created with its new IRI")
+	}
+	if len(col.OrderedItems) != exportPageSize+50 {
+		t.Errorf("members = %d, want %d", len(col.OrderedItems), exportPageSize+50)
+	}
+	for _, member := range col.OrderedItems {
+		if !member.GetLink().Contains("https://new.example/", false) {
+			t.Errorf("the member %s has not been rewritten", member)
+			break
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package fedbox
+
+import (
+	"io"
+	"os"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+type SendCmd struct {
+	As        vocab.IRI                    `required:"" help:"The local actor that sends the activity."`
+	File      string                       `short:"f" type:"existingfile" help:"The file containing the JSON encoded activity. If missing, and no type is passed, the activity is read from the standard input."`
+	Type      vocab.ActivityVocabularyType `help:"The type of the activity to send, when building it from flags."`
+	Object    vocab.IRI                    `help:"The object of the activity, when building it from flags."`
+	Target    vocab.IRI                    `help:"The target of the activity, when building it from flags."`
+	To        []vocab.IRI                  `help:"The recipients of the activity. If missing, it's addressed to the public and to the followers of the actor."`
+	LocalOnly bool                         `help:"Save the activity without sending it to other servers."`
+	Output    string                       `short:"o" help:"The format in which to output the resulting activity." enum:"text,json" default:"text"`
+}
+
+// Run processes the activity as if the actor had posted it to its outbox.
+func (s SendCmd) Run(ctl *Base) error {
+	actor, err := localActor(ctl, s.As)
+	if err != nil {
+		return err
+	}
+
+	var act *vocab.Activity
+	if s.Type != "" {
+		act, err = s.build()
+	} else {
+		act, err = s.load(ctl)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !vocab.IsNil(act.Actor) && !act.Actor.GetLink().Equal(actor.ID) {
+		return errors.Newf("the activity's actor %s is different from %s", act.Actor.GetLink(), actor.ID)
+	}
+	act.Actor = actor.ID
+	if len(s.To) > 0 {
+		act.To = make(vocab.ItemCollection, 0, len(s.To))
+		for _, iri := range s.To {
+			act.To = append(act.To, iri)
+		}
+	}
+	if len(act.Recipients()) == 0 {
+		s.address(ctl, actor, act)
+	}
+
+	it, err := ctl.Saver(actor, s.LocalOnly).ProcessClientActivity(act, *actor, vocab.Outbox.IRI(actor))
+	if err != nil {
+		return err
+	}
+	return printItem(ctl.out, it, s.Output)
+}
+
+// build creates the activity from the flags of the command.
+func (s SendCmd) build() (*vocab.Activity, error) {
+	if !vocab.ActivityTypes.Match(s.Type) && !vocab.IntransitiveActivityTypes.Match(s.Type) {
+		return nil, errors.Newf("invalid activity type %q", s.Type)
+	}
+	if s.Object == "" && !vocab.IntransitiveActivityTypes.Match(s.Type) {
+		return nil, errors.Newf("the %s activity needs an object", s.Type)
+	}
+	act := &vocab.Activity{Type: s.Type}
+	if s.Object != "" {
+		act.Object = s.Object
+	}
+	if s.Target != "" {
+		act.Target = s.Target
+	}
+	return act, nil
+}
+
+// load reads the JSON encoded activity from the file, or from the standard input.
+func (s SendCmd) load(ctl *Base) (*vocab.Activity, error) {
+	var in io.Reader = ctl.in
+	if s.File != "" {
+		f, err := os.Open(s.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	raw, err := io.ReadAll(in)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read the activity")
+	}
+	it, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to decode the activity")
+	}
+	if vocab.IsNil(it) || !(vocab.ActivityTypes.Match(it.GetType()) || vocab.IntransitiveActivityTypes.Match(it.GetType())) {
+		return nil, errors.Newf("the document is not an activity")
+	}
+	return vocab.ToActivity(it)
+}
+
+// address sets the default recipients of the activity: the public namespace and the followers of the actor,
+// and the actor or the author of the object, when we know them.
+func (s SendCmd) address(ctl *Base, actor *vocab.Actor, act *vocab.Activity) {
+	act.To = vocab.ItemCollection{vocab.PublicNS}
+	act.CC = vocab.ItemCollection{vocab.Followers.IRI(actor)}
+	if vocab.IsNil(act.Object) {
+		return
+	}
+	ob, err := ctl.Storage.Load(act.Object.GetLink())
+	if err != nil || vocab.IsNil(ob) {
+		// The object of a Follow is always an actor, even when we don't have it stored locally
+		if act.Type == vocab.FollowType {
+			act.To = append(act.To, act.Object.GetLink())
+		}
+		return
+	}
+	if vocab.ActorTypes.Match(ob.GetType()) {
+		act.To = append(act.To, ob.GetLink())
+		return
+	}
+	_ = vocab.OnObject(ob, func(o *vocab.Object) error {
+		if !vocab.IsNil(o.AttributedTo) && !o.AttributedTo.GetLink().Equal(actor.ID) {
+			act.CC = append(act.CC, o.AttributedTo.GetLink())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,37 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestSendCmd_build(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     SendCmd
+		wantErr bool
+	}{
+		{name: "follow", cmd: SendCmd{Type: vocab.FollowType, Object: "https://example.com/actors/1"}},
+		{name: "intransitive", cmd: SendCmd{Type: vocab.ArriveType}},
+		{name: "missing object", cmd: SendCmd{Type: vocab.LikeType}, wantErr: true},
+		{name: "not an activity", cmd: SendCmd{Type: vocab.NoteType, Object: "https://example.com/1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act, err := tt.cmd.build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("build() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if act.Type != tt.cmd.Type {
+				t.Errorf("build() type = %s, want %s", act.Type, tt.cmd.Type)
+			}
+			if tt.cmd.Object != "" && !act.Object.GetLink().Equal(tt.cmd.Object) {
+				t.Errorf("build() object = %s, want %s", act.Object.GetLink(), tt.cmd.Object)
+			}
+		})
+	}
+}
@@ -26,6 +26,22 @@ type Base struct {
 	ServicePrivateKey crypto.PrivateKey
 	Storage           storage.FullStorage
 
+	// actor is the authenticated actor that runs the commands, it's set only for SSH sessions.
+	actor *vocab.Actor
+
+	// status returns the state of the server, it's set only when the commands run inside it.
+	status func() Status
+	// pauseWrites puts the server in read-only mode, and returns the function that restores its previous mode.
+	// It's set only when the commands run inside the server.
+	pauseWrites func() func()
+	// pauseStorage puts the server in maintenance mode, with the storage closed, and returns the function
+	// that opens it again. It's set only when the commands run inside the server.
+	pauseStorage func() func() error
+
+	// suspensions caches the suspension state of the local actors, it's set only for the server,
+	// and shared with the commands that run inside it.
+	suspensions *suspensionCache
+
 	debugMode atomic.Bool
 
 	out io.Writer
@@ -0,0 +1,115 @@
+package fedbox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+type SSHKeys struct {
+	Add    AddSSHKey    `cmd:"" help:"Add an SSH public key that the actor can use to log in."`
+	List   ListSSHKeys  `cmd:"" help:"List the SSH public keys of the actor."`
+	Remove RemoveSSHKey `cmd:"" help:"Remove an SSH public key of the actor."`
+}
+
+type AddSSHKey struct {
+	IRI       vocab.IRI     `arg:"" name:"iri" help:"The actor for which to add the key."`
+	Key       string        `arg:"" name:"key" help:"The public key in the OpenSSH authorized_keys format, \"-\" for reading it from the standard input, or for local commands, the path of a file containing it."`
+	Comment   string        `help:"Comment for the key, if missing we use the one from the key."`
+	ExpiresIn time.Duration `name:"expires-in" help:"Duration after which the key can't be used anymore."`
+}
+
+func (a AddSSHKey) Run(ctl *Base) error {
+	actor, err := loadActor(ctl, a.IRI)
+	if err != nil {
+		return err
+	}
+
+	line, err := a.read(ctl)
+	if err != nil {
+		return err
+	}
+	var expires time.Time
+	if a.ExpiresIn > 0 {
+		expires = time.Now().UTC().Add(a.ExpiresIn).Truncate(time.Second)
+	}
+	key, err := ap.AddAuthorizedKey(ctl.Storage, actor.ID, line, a.Comment, expires)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(ctl.out, "Added %s\n", key.Fingerprint())
+	return nil
+}
+
+// read returns the key from the standard input, when the argument is "-", or from the argument.
+// The commands received over SSH or through the admin API run with an actor, and they must not be able
+// to read files from the filesystem of the server, so only the local ones can pass the path of a file.
+func (a AddSSHKey) read(ctl *Base) ([]byte, error) {
+	if a.Key == "-" {
+		raw, err := io.ReadAll(ctl.in)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read the key")
+		}
+		return raw, nil
+	}
+	if ctl.actor == nil {
+		if raw, err := os.ReadFile(a.Key); err == nil {
+			return raw, nil
+		}
+	}
+	return []byte(a.Key), nil
+}
+
+type ListSSHKeys struct {
+	IRI vocab.IRI `arg:"" name:"iri" help:"The actor for which to list the keys."`
+}
+
+func (l ListSSHKeys) Run(ctl *Base) error {
+	actor, err := loadActor(ctl, l.IRI)
+	if err != nil {
+		return err
+	}
+	m := new(ap.Metadata)
+	if err = ctl.Storage.LoadMetadata(actor.ID, m); err != nil {
+		return errors.Annotatef(err, "unable to load metadata for actor: %s", actor.ID)
+	}
+
+	now := time.Now().UTC()
+	for _, k := range m.AuthorizedKeys {
+		expires := "never"
+		if !k.Expires.IsZero() {
+			expires = k.Expires.Format(time.RFC3339)
+		}
+		if k.Expired(now) {
+			expires += " (expired)"
+		}
+		_, _ = fmt.Fprintf(ctl.out, "%s\t%s\texpires: %s\n", k.Fingerprint(), k.Comment, expires)
+	}
+	return nil
+}
+
+type RemoveSSHKey struct {
+	IRI         vocab.IRI `arg:"" name:"iri" help:"The actor for which to remove the key."`
+	Fingerprint string    `arg:"" name:"fingerprint" help:"The SHA256 fingerprint of the key, as shown by the list command."`
+}
+
+func (r RemoveSSHKey) Run(ctl *Base) error {
+	actor, err := loadActor(ctl, r.IRI)
+	if err != nil {
+		return err
+	}
+	return ap.RemoveAuthorizedKey(ctl.Storage, actor.ID, r.Fingerprint)
+}
+
+func loadActor(ctl *Base, iri vocab.IRI) (*vocab.Actor, error) {
+	it, err := ctl.Storage.Load(iri)
+	if err != nil {
+		return nil, err
+	}
+	return vocab.ToActor(it)
+}
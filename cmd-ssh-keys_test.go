@@ -0,0 +1,44 @@
+package fedbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestAddSSHKey_read(t *testing.T) {
+	const key = "ssh-ed25519 AAAA jdoe@laptop"
+	path := filepath.Join(t.TempDir(), "id_ed25519.pub")
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %s", err)
+	}
+	session := &vocab.Actor{ID: "https://example.com/actors/admin"}
+
+	tests := []struct {
+		name  string
+		actor *vocab.Actor
+		arg   string
+		in    string
+		want  string
+	}{
+		{name: "local file", arg: path, want: key},
+		{name: "session file", actor: session, arg: path, want: path},
+		{name: "session stdin", actor: session, arg: "-", in: key, want: key},
+		{name: "argument", actor: session, arg: key, want: key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctl := &Base{actor: tt.actor, in: strings.NewReader(tt.in)}
+			got, err := AddSSHKey{Key: tt.arg}.read(ctl)
+			if err != nil {
+				t.Fatalf("read() error = %s", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
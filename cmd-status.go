@@ -0,0 +1,91 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+type StatusCmd struct {
+	Output string `help:"The format in which to output the status." enum:"text,json" default:"text"`
+}
+
+func (s StatusCmd) Run(ctl *Base) error {
+	st, err := ctl.serverStatus()
+	if err != nil {
+		return err
+	}
+	if s.Output == "json" {
+		raw, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(ctl.out, "%s\n", raw)
+		return nil
+	}
+	return printStatus(ctl, st)
+}
+
+// serverStatus returns the state of the running server: directly when the command runs inside the server,
+// like for SSH sessions, or through the admin API on the internal socket otherwise.
+func (ctl *Base) serverStatus() (*Status, error) {
+	if ctl.status != nil {
+		st := ctl.status()
+		return &st, nil
+	}
+
+	cl := ctl.remoteClient()
+	if cl == nil {
+		return nil, errors.NotFoundf("no running %s server found at %s", AppName, ctl.Conf.InternalSocketPath())
+	}
+	resp, err := cl.Get("http://fedbox/status")
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load status from the running server")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewFromStatus(resp.StatusCode, "unable to load status from the running server")
+	}
+
+	st := new(Status)
+	if err = json.NewDecoder(resp.Body).Decode(st); err != nil {
+		return nil, errors.Annotatef(err, "invalid status received from the running server")
+	}
+	return st, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func printStatus(ctl *Base, st *Status) error {
+	caches := make([]string, 0, len(st.Caches))
+	for _, c := range st.Caches {
+		if c.Enabled {
+			caches = append(caches, fmt.Sprintf("%s: %d entries", c.Name, c.Entries))
+		} else {
+			caches = append(caches, fmt.Sprintf("%s: disabled", c.Name))
+		}
+	}
+
+	_, _ = fmt.Fprintf(ctl.out, "%s %s at %s\n", st.Name, st.Version, st.URL)
+	_, _ = fmt.Fprintf(ctl.out, "Environment:  %s\n", st.Env)
+	_, _ = fmt.Fprintf(ctl.out, "Started:      %s (up %s)\n", st.Started.Format(time.RFC3339), st.Uptime)
+	_, _ = fmt.Fprintf(ctl.out, "Storage:      %s %s\n", st.Storage.Type, st.Storage.Path)
+	_, _ = fmt.Fprintf(ctl.out, "Maintenance:  %s\n", onOff(st.Maintenance))
+	_, _ = fmt.Fprintf(ctl.out, "Read-only:    %s\n", onOff(st.ReadOnly))
+	_, _ = fmt.Fprintf(ctl.out, "Debug:        %s\n", onOff(st.Debug))
+	_, _ = fmt.Fprintf(ctl.out, "Stopping:     %s\n", onOff(st.ShuttingDown))
+	_, _ = fmt.Fprintf(ctl.out, "Listeners:    %s\n", strings.Join(st.Listeners, ", "))
+	_, _ = fmt.Fprintf(ctl.out, "Caches:       %s\n", strings.Join(caches, ", "))
+	_, _ = fmt.Fprintf(ctl.out, "Deliveries:   %d in flight\n", st.InFlightDeliveries)
+	_, _ = fmt.Fprintf(ctl.out, "Spool:        %d activities\n", st.SpooledActivities)
+	return nil
+}
@@ -0,0 +1,149 @@
+package fedbox
+
+import (
+	"io"
+	"os"
+	"reflect"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/alecthomas/kong"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// commandBase returns a Base that shares the storage and the configuration of the running server,
+// which is used for executing the commands received over SSH or through the admin API.
+func (f *FedBOX) commandBase(actor *vocab.Actor, in io.Reader, out, errOut io.Writer) *Base {
+	ctl := new(Base)
+	ctl.Conf = f.Conf
+	ctl.Logger = f.Logger
+	ctl.Service = f.Service
+	ctl.ServicePrivateKey = f.ServicePrivateKey
+	ctl.Storage = f.Storage
+	ctl.actor = actor
+	ctl.status = f.Status
+	ctl.pauseWrites = f.pauseWrites
+	ctl.pauseStorage = f.pauseStorage
+	ctl.suspensions = f.suspensions
+	ctl.in = in
+	ctl.out = out
+	ctl.err = errOut
+	return ctl
+}
+
+// execCommand parses the args using the SSH command tree, and if the actor of ctl is authorized, it runs them.
+func (f *FedBOX) execCommand(ctl *Base, name string, args []string) error {
+	vars := kong.Vars{}
+	for k, v := range kongDefaultVars {
+		vars[k] = v
+	}
+	vars["name"] = name
+	vars["URL"] = string(f.Service.ID)
+
+	k, err := kong.New(
+		new(SSH),
+		kong.UsageOnError(),
+		kong.Name(name),
+		kong.Description("${name} (version ${version}) ${URL}"),
+		kong.Writers(ctl.out, ctl.err),
+		kong.Exit(func(_ int) {}),
+		vars,
+	)
+	if err != nil {
+		return err
+	}
+
+	ktx, err := k.Parse(args)
+	if err != nil {
+		_ = k.Errorf("%s\n", err)
+		return err
+	}
+
+	if err = authorizeCommand(ctl, ctl.actor, ktx); err != nil {
+		f.Logger.WithContext(lw.Ctx{"cmd": commandPath(ktx), "err": err.Error()}).Warnf("unauthorized command")
+		_ = k.Errorf("%s\n", err)
+		return err
+	}
+
+	if f.readOnlyMode.Load() && !isReadOnly(ktx) {
+		err = errors.Conflictf("server is in read-only mode, unable to run %q", commandPath(ktx))
+		_ = k.Errorf("%s\n", err)
+		return err
+	}
+
+	closeStdin, err := redirectStdinFiles(ktx, ctl.in)
+	if err != nil {
+		_ = k.Errorf("%s\n", err)
+		return err
+	}
+	defer closeStdin()
+
+	if err = ctl.runAudited(ktx); err != nil {
+		_ = k.Errorf("%s\n", err)
+		return err
+	}
+	_ = k.Printf("OK\n")
+	return nil
+}
+
+// redirectStdinFiles replaces the files that kong opened for the "-" arguments of the selected command, which
+// would be the standard input of the server process, with a pipe that receives the input of the command.
+// It returns the function that closes the pipe.
+func redirectStdinFiles(ktx *kong.Context, in io.Reader) (func(), error) {
+	node := ktx.Selected()
+	if node == nil {
+		return func() {}, nil
+	}
+	values := make([]*kong.Value, 0)
+	values = append(values, node.Positional...)
+	for _, fl := range node.Flags {
+		values = append(values, fl.Value)
+	}
+
+	var pr, pw *os.File
+	pipe := func() (reflect.Value, error) {
+		if pr == nil {
+			var err error
+			if pr, pw, err = os.Pipe(); err != nil {
+				return reflect.Value{}, errors.Annotatef(err, "unable to redirect the standard input")
+			}
+		}
+		return reflect.ValueOf(pr), nil
+	}
+	for _, v := range values {
+		if !v.Set || !v.Target.IsValid() {
+			continue
+		}
+		targets := make([]reflect.Value, 0)
+		switch f := v.Target.Interface().(type) {
+		case *os.File:
+			if f == os.Stdin {
+				targets = append(targets, v.Target)
+			}
+		case []*os.File:
+			for i, ff := range f {
+				if ff == os.Stdin {
+					targets = append(targets, v.Target.Index(i))
+				}
+			}
+		}
+		for _, t := range targets {
+			r, err := pipe()
+			if err != nil {
+				return func() {}, err
+			}
+			t.Set(r)
+		}
+	}
+	if pr == nil {
+		return func() {}, nil
+	}
+
+	go func() {
+		_, _ = io.Copy(pw, in)
+		_ = pw.Close()
+	}()
+	return func() {
+		_ = pr.Close()
+	}, nil
+}
@@ -0,0 +1,29 @@
+package fedbox
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedirectStdinFiles(t *testing.T) {
+	ktx := parseSSHCommand(t, "accounts", "import", "-")
+	closeStdin, err := redirectStdinFiles(ktx, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("redirectStdinFiles() error = %s", err)
+	}
+	defer closeStdin()
+
+	files := ktx.Selected().Positional[0].Target.Interface().([]*os.File)
+	if len(files) != 1 || files[0] == os.Stdin {
+		t.Fatalf("redirectStdinFiles() the standard input of the process was not replaced")
+	}
+	raw, err := io.ReadAll(files[0])
+	if err != nil {
+		t.Fatalf("unable to read the redirected input: %s", err)
+	}
+	if string(raw) != "{}" {
+		t.Errorf("redirected input = %q, want %q", raw, "{}")
+	}
+}
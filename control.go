@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"strings"
 	"sync/atomic"
 
 	"git.sr.ht/~mariusor/lw"
@@ -24,6 +25,12 @@ type Storage struct {
 	Bootstrap      BootstrapCmd   `cmd:"" help:"Bootstrap the storage"`
 	Reset          ResetCmd       `cmd:"" help:"Reset an existing storage."`
 	FixCollections FixCollections `cmd:"" help:"Fix storage collections."`
+	HostKeys       HostKeys       `cmd:"" name:"host-keys" help:"Manage the SSH host keys."`
+	Backup         BackupCmd      `cmd:"" help:"Save the storage to an archive."`
+	Restore        RestoreCmd     `cmd:"" help:"Restore the storage from an archive."`
+	Migrate        MigrateCmd     `cmd:"" help:"Copy the storage to a different backend."`
+	Rehome         RehomeCmd      `cmd:"" help:"Change the base URL of the instance, rewriting the IRIs of all items."`
+	Check          CheckCmd       `cmd:"" help:"Check the integrity of the storage."`
 }
 
 type SSH struct {
@@ -35,6 +42,8 @@ type SSH struct {
 	Maintenance Maintenance `cmd:"" help:"Toggle maintenance mode for the running FedBOX server."`
 	Reload      Reload      `cmd:"" help:"Reload the running FedBOX server configuration."`
 	Stop        Stop        `cmd:"" help:"Stops the running FedBOX server configuration."`
+	Status      StatusCmd   `cmd:"" help:"Show the state of the running FedBOX server."`
+	Audit       Audit       `cmd:"" help:"Audit log of the administrative commands."`
 }
 
 type CTL struct {
@@ -160,9 +169,11 @@ func setup(ct *Base, conf config.Options) error {
 	if err != nil {
 		return err
 	}
-	if ct.Storage, err = storage.New(initFn...); err != nil {
+	db, err := storage.New(initFn...)
+	if err != nil {
 		return err
 	}
+	ct.Storage = withTokenIndex(db, ap.DefaultServiceIRI(conf.BaseURL))
 	return nil
 }
 
@@ -200,11 +211,14 @@ func loadPwFromStdin(rw io.ReadWriter, prompt string) ([]byte, error) {
 	return []byte(pw1), nil
 }
 
-func loadFromStdin(s string, params ...any) ([]byte, error) {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf(s+": ", params...)
-	input, _ := reader.ReadBytes('\n')
-	return input[:len(input)-1], nil
+// readLine prints the prompt and returns the line read from the input, without the line ending.
+func readLine(in io.Reader, out io.Writer, prompt string) (string, error) {
+	_, _ = fmt.Fprint(out, prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Annotatef(err, "unable to read answer")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
 var _l atomic.Value
@@ -14,6 +14,7 @@ import (
 	"github.com/go-ap/cache"
 	"github.com/go-ap/client"
 	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/filters"
 	"github.com/go-ap/processing"
 )
@@ -73,7 +74,13 @@ func (f *FedBOX) actorFromRequestWithClient(r *http.Request, cl *client.C, recei
 	initFns := []auth.InitFn{
 		auth.WithClient(cl),
 		auth.WithLogger(l),
-		auth.WithStorage(f.Storage),
+	}
+	var keyStorage *verificationKeyStorage
+	if keyID := signatureKeyID(r); f.Storage != nil && keyID != "" {
+		keyStorage = &verificationKeyStorage{FullStorage: f.Storage, keyID: keyID}
+		initFns = append(initFns, auth.WithStorage(keyStorage))
+	} else {
+		initFns = append(initFns, auth.WithStorage(f.Storage))
 	}
 
 	var ar actorVerifier
@@ -92,6 +99,13 @@ func (f *FedBOX) actorFromRequestWithClient(r *http.Request, cl *client.C, recei
 	if err != nil {
 		f.Logger.WithContext(lw.Ctx{"err": err.Error()}).Errorf("unable to load an authorized Actor from request")
 	}
+	if keyStorage != nil {
+		keyStorage.restore(&actor)
+	}
+	if f.IsSuspended(actor.ID) {
+		f.Logger.WithContext(lw.Ctx{"actor": actor.ID}).Warnf("refusing request authorized by a suspended Actor")
+		return auth.AnonymousActor
+	}
 	return actor
 }
 
@@ -124,6 +138,9 @@ func HandleCollection(fb *FedBOX) processing.CollectionHandlerFn {
 
 		colUrl := reqURL(*r, fb.Conf.Secure)
 		iri := vocab.IRI(colUrl)
+		if col := fb.suspendedCollection(iri); col != nil {
+			return col, nil
+		}
 		authorized := fb.actorFromRequestWithClient(r, FedBOXClient(fb), iri)
 		cacheKey := CacheKey(fb, authorized, *r)
 
@@ -251,46 +268,18 @@ func HandleActivity(fb *FedBOX) processing.ActivityHandlerFn {
 
 		l := fb.Logger.WithContext(lw.Ctx{"log": "processing"})
 
-		authorized := fb.actorFromRequestWithClient(r, ActorClient(fb.Base, vocab.PublicNS), receivedIn)
-		if authorized.ID.Equal(vocab.PublicNS) {
-			fb.errFn("invalid Anonymous actor request: %s", receivedIn)
-			return it, http.StatusUnauthorized, errors.Unauthorizedf("authorized Actor is invalid")
-		}
-
-		repo := fb.Storage
-
-		baseIRI := vocab.IRI(fb.Conf.BaseURL)
-		initFns := make([]processing.OptionFn, 0)
-		initFns = append(initFns,
-			processing.WithIRI(baseIRI, InternalIRI),
-			processing.WithClient(ActorClient(fb.Base, receivedIn)),
-			processing.WithStorage(repo),
-			processing.WithLogger(l),
-			processing.WithIDGenerator(GenerateID(baseIRI)),
-		)
-		if fb.keyGenerator != nil {
-			initFns = append(initFns, processing.WithActorKeyGenerator(fb.keyGenerator))
-		}
-		if !fb.Conf.Env.IsTest() {
-			initFns = append(initFns, processing.Async)
-		}
-		processor := processing.New(initFns...)
+		cl := ActorClient(fb.Base, vocab.PublicNS)
+		authorized, err := fb.authorizeActivity(body, it, fb.actorFromRequestWithClient(r, cl, receivedIn), receivedIn, cl, l)
 		if err != nil {
-			fb.errFn("failed initializing the Activity processor: %+s", err)
-			return it, http.StatusInternalServerError, errors.Annotatef(err, "unable to initialize processor")
+			fb.errFn("unauthorized activity request to %s: %+s", receivedIn, err)
+			return it, http.StatusUnauthorized, err
 		}
 
 		typ := it.GetType()
-		if it, err = processor.ProcessActivity(it, authorized, receivedIn); err != nil {
+		if it, err = fb.processActivity(it, authorized, receivedIn, l); err != nil {
 			fb.errFn("failed processing activity: %+s", err)
 			return it, errors.HttpStatus(err), errors.Annotatef(err, "Unable to save activity %s to %s", typ, receivedIn)
 		}
-		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
-			if err := cache.ActivityPurge(fb.caches, act, receivedIn); err != nil {
-				fb.errFn("unable to purge cache: %+s", err)
-			}
-			return nil
-		})
 
 		status := http.StatusCreated
 		if vocab.DeleteType.Match(it.GetType()) {
@@ -302,6 +291,71 @@ func HandleActivity(fb *FedBOX) processing.ActivityHandlerFn {
 	}
 }
 
+// authorizeActivity returns the actor on whose behalf the activity received in receivedIn gets processed.
+// This is the signer of the request, unless the activity delivered to an inbox has a valid integrity proof,
+// in which case it's the actor that created the proof.
+// It's used both for the live requests, and for the ones replayed from the spool after a maintenance.
+func (fb *FedBOX) authorizeActivity(body []byte, it vocab.Item, signer vocab.Actor, receivedIn vocab.IRI, cl *client.C, l lw.Logger) (vocab.Actor, error) {
+	authorized := signer
+	if processing.IsInbox(receivedIn) {
+		// An activity with a valid integrity proof is authenticated by the proof itself,
+		// which allows us to accept activities relayed by actors other than their authors.
+		proofActor, err := fb.actorFromIntegrityProof(body, it, cl)
+		if err != nil && !ap.UnverifiableProof(err) {
+			return auth.AnonymousActor, errors.NewUnauthorized(err, "invalid integrity proof")
+		}
+		if err != nil {
+			// A proof that we can't verify doesn't invalidate the HTTP signature of the request
+			l.WithContext(lw.Ctx{"err": err.Error()}).Debugf("ignoring unverifiable integrity proof")
+		}
+		if proofActor != nil && !proofActor.ID.Equal(authorized.ID) {
+			l.WithContext(lw.Ctx{"signer": authorized.ID, "actor": proofActor.ID}).Debugf("accepting activity with valid integrity proof")
+			authorized = *proofActor
+		}
+	}
+	if fb.IsSuspended(authorized.ID) {
+		l.WithContext(lw.Ctx{"actor": authorized.ID}).Warnf("refusing activity of a suspended Actor")
+		return auth.AnonymousActor, errors.Unauthorizedf("authorized Actor is suspended")
+	}
+	if authorized.ID.Equal(vocab.PublicNS) {
+		return auth.AnonymousActor, errors.Unauthorizedf("authorized Actor is invalid")
+	}
+	return authorized, nil
+}
+
+// processActivity runs the activity received in the receivedIn collection, from the authorized actor,
+// through the ActivityPub processor, and purges the cache entries it invalidates.
+func (fb *FedBOX) processActivity(it vocab.Item, authorized vocab.Actor, receivedIn vocab.IRI, l lw.Logger) (vocab.Item, error) {
+	baseIRI := vocab.IRI(fb.Conf.BaseURL)
+	initFns := make([]processing.OptionFn, 0)
+	initFns = append(initFns,
+		processing.WithIRI(baseIRI, InternalIRI),
+		processing.WithClient(ActorClient(fb.Base, receivedIn)),
+		processing.WithStorage(fb.writeStorage),
+		processing.WithLogger(l),
+		processing.WithIDGenerator(GenerateID(baseIRI)),
+	)
+	if fb.keyGenerator != nil {
+		initFns = append(initFns, processing.WithActorKeyGenerator(fb.keyGenerator))
+	}
+	if !fb.Conf.Env.IsTest() {
+		initFns = append(initFns, processing.Async)
+	}
+	processor := processing.New(initFns...)
+
+	it, err := processor.ProcessActivity(it, authorized, receivedIn)
+	if err != nil {
+		return it, err
+	}
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		if err := cache.ActivityPurge(fb.caches, act, receivedIn); err != nil {
+			fb.errFn("unable to purge cache: %+s", err)
+		}
+		return nil
+	})
+	return it, nil
+}
+
 // HandleItem serves content from the following, followers, liked, and likes end-points
 // that returns a single ActivityPub object
 func HandleItem(fb *FedBOX) processing.ItemHandlerFn {
@@ -344,6 +398,9 @@ func HandleItem(fb *FedBOX) processing.ItemHandlerFn {
 			}
 		}
 
+		if vocab.IsNil(it) {
+			return nil, errors.NotFoundf("%s was not found", iri)
+		}
 		if !fromCache {
 			fb.caches.Store(cacheKey, it)
 		} else {
@@ -352,6 +409,13 @@ func HandleItem(fb *FedBOX) processing.ItemHandlerFn {
 			err = processing.NotModified
 		}
 
+		if !vocab.IsNil(it) && vocab.ActorTypes.Match(it.GetType()) && fb.IsSuspended(it.GetLink()) {
+			_ = vocab.OnActor(it, func(act *vocab.Actor) error {
+				it = suspendedPlaceholder(act)
+				return nil
+			})
+		}
+
 		// Remove bcc and bto
 		return vocab.CleanRecipients(it), err
 	}
@@ -6,13 +6,19 @@ import (
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"git.sr.ht/~mariusor/lw"
+	"git.sr.ht/~mariusor/storage-all"
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
 	"github.com/go-ap/processing"
 	"github.com/go-fed/httpsig"
 )
@@ -98,3 +104,71 @@ func s2sSignFn(actorID vocab.IRI, keyLoader processing.KeyLoader, l lw.Logger) f
 		return s.SignRequest(key, keyId, r, bodyBuf.Bytes())
 	}
 }
+
+// signatureKeyID returns the keyId of the HTTP signature of the request, for both the RFC9421 and
+// the draft-cavage signatures.
+func signatureKeyID(r *http.Request) vocab.IRI {
+	if input := r.Header.Get("Signature-Input"); input != "" {
+		_, after, ok := strings.Cut(input, `keyid="`)
+		if !ok {
+			return ""
+		}
+		keyID, _, _ := strings.Cut(after, `"`)
+		return vocab.IRI(keyID)
+	}
+	v, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return ""
+	}
+	return vocab.IRI(v.KeyId())
+}
+
+// verificationKeyStorage is used by the HTTP signature verifiers for loading the keys of local actors.
+// The verifiers only look at the publicKey of the actor, so when the request is signed with one of the
+// additional keys, or with a retired key that hasn't expired yet, we replace it with the one from the metadata.
+type verificationKeyStorage struct {
+	storage.FullStorage
+	keyID vocab.IRI
+	// replaced holds the actual publicKey of the actor, if we replaced it.
+	replaced *vocab.PublicKey
+}
+
+func (s *verificationKeyStorage) Load(iri vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	it, err := s.FullStorage.Load(iri, ff...)
+	if err != nil || vocab.IsNil(it) || !vocab.ActorTypes.Match(it.GetType()) {
+		return it, err
+	}
+	act, err := vocab.ToActor(it)
+	if err != nil || act.PublicKey.ID.Equal(s.keyID) || !act.ID.Equal(ap.KeyControllerIRI(s.keyID)) {
+		return it, nil
+	}
+
+	m := new(ap.Metadata)
+	if err = s.FullStorage.LoadMetadata(act.ID, m); err != nil {
+		return it, nil
+	}
+	pub, err := m.VerificationKey(s.keyID)
+	if err != nil {
+		return it, nil
+	}
+	pubEnc, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return it, nil
+	}
+
+	withKey := *act
+	withKey.PublicKey = vocab.PublicKey{
+		ID:           s.keyID,
+		Owner:        act.ID,
+		PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubEnc})),
+	}
+	s.replaced = &act.PublicKey
+	return &withKey, nil
+}
+
+// restore puts back the actual publicKey of the actor, if it has been replaced for verifying the signature.
+func (s *verificationKeyStorage) restore(act *vocab.Actor) {
+	if s.replaced != nil && act.ID.Equal(s.replaced.Owner) {
+		act.PublicKey = *s.replaced
+	}
+}
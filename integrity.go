@@ -0,0 +1,191 @@
+package fedbox
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"io"
+	"net/http"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// withIntegrityProof wraps the signFn request signing function, and before signing it appends
+// a FEP-8b32 integrity proof to the body of the POST requests that contain activities authored by "act".
+func withIntegrityProof(act *vocab.Actor, key ed25519.PrivateKey, keyID vocab.IRI, l lw.Logger, signFn func(*http.Request) error) func(*http.Request) error {
+	return func(r *http.Request) error {
+		if r.Method != http.MethodPost || r.Body == nil {
+			return signFn(r)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if it, err := vocab.UnmarshalJSON(body); err == nil && isAuthoredBy(it, act) {
+			if withProof, err := ap.AddProof(body, key, keyID); err == nil {
+				body = withProof
+			} else if err != ap.ErrExistingProof {
+				l.WithContext(lw.Ctx{"err": err.Error()}).Warnf("unable to add integrity proof")
+			}
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		return signFn(r)
+	}
+}
+
+// isAuthoredBy checks if the "it" activity has the "act" actor as its actor.
+// We don't want to add our proofs to activities we're only forwarding.
+func isAuthoredBy(it vocab.Item, act *vocab.Actor) bool {
+	if act == nil || vocab.IsNil(it) {
+		return false
+	}
+	authored := false
+	_ = vocab.OnIntransitiveActivity(it, func(a *vocab.IntransitiveActivity) error {
+		authored = !vocab.IsNil(a.Actor) && a.Actor.GetLink().Equal(act.ID)
+		return nil
+	})
+	return authored
+}
+
+// verificationMethod dereferences the verification method of an integrity proof to the public key it represents,
+// and returns it together with the IRI of the actor that controls it. It looks for the controller first
+// in the local storage, and then remotely.
+// The verification method can be either the actor's publicKey, or one of its FEP-521a assertionMethod keys.
+func (f *FedBOX) verificationMethod(cl *client.C, keyIRI vocab.IRI) (crypto.PublicKey, vocab.IRI, error) {
+	controller, err := keyControllerIRI(cl, keyIRI)
+	if err != nil {
+		return nil, "", err
+	}
+
+	it, err := f.Storage.Load(controller)
+	if err == nil && !vocab.IsNil(it) {
+		var pub crypto.PublicKey
+		err = vocab.OnActor(it, func(act *vocab.Actor) error {
+			if act.PublicKey.ID == keyIRI {
+				pub, err = ap.PublicKeyFromPEM(act.PublicKey.PublicKeyPem)
+				return err
+			}
+			m := new(ap.Metadata)
+			if err = f.Storage.LoadMetadata(act.ID, m); err != nil {
+				return errors.NotFoundf("verification method %s not found in actor %s", keyIRI, act.ID)
+			}
+			pub, err = m.VerificationKey(keyIRI)
+			return err
+		})
+		return pub, controller, err
+	}
+
+	if cl == nil {
+		return nil, "", errors.NotFoundf("unable to load verification method controller %s", controller)
+	}
+	pub, err := loadRemoteVerificationMethod(cl, controller, keyIRI)
+	return pub, controller, err
+}
+
+// keyControllerIRI returns the IRI of the actor that controls the key. For keys identified by a fragment, it's
+// the document that contains them, otherwise we dereference the key, and use its controller.
+// The controller we return is not trusted, the caller needs to check that it lists the key.
+func keyControllerIRI(cl *client.C, keyIRI vocab.IRI) (vocab.IRI, error) {
+	u, err := keyIRI.URL()
+	if err != nil {
+		return "", errors.NotFoundf("invalid verification method %s", keyIRI)
+	}
+	if u.Fragment != "" || cl == nil {
+		return ap.KeyControllerIRI(keyIRI), nil
+	}
+
+	resp, err := cl.CtxGet(context.Background(), keyIRI.String())
+	if err != nil {
+		return "", errors.NewNotFound(err, "unable to load verification method %s", keyIRI)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.NotFoundf("unable to load verification method %s", keyIRI)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return ap.KeyController(body)
+}
+
+// loadRemoteVerificationMethod dereferences the controller actor and looks for the keyIRI
+// in its publicKey and its assertionMethod properties.
+func loadRemoteVerificationMethod(cl *client.C, controller, keyIRI vocab.IRI) (crypto.PublicKey, error) {
+	resp, err := cl.CtxGet(context.Background(), controller.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NotFoundf("unable to load verification method controller %s", controller)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := vocab.UnmarshalJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	var pub crypto.PublicKey
+	err = vocab.OnActor(it, func(act *vocab.Actor) error {
+		if act.PublicKey.ID == keyIRI {
+			pub, err = ap.PublicKeyFromPEM(act.PublicKey.PublicKeyPem)
+			return err
+		}
+		pub, err = ap.FindAssertionMethod(body, keyIRI)
+		return err
+	})
+	return pub, err
+}
+
+// actorFromIntegrityProof verifies the integrity proof of the received activity, and if valid, it returns the actor
+// controlling the key that generated it.
+// If the activity does not contain a proof, it returns a nil actor and no error.
+func (f *FedBOX) actorFromIntegrityProof(body []byte, it vocab.Item, cl *client.C) (*vocab.Actor, error) {
+	var controller vocab.IRI
+	keyFn := func(keyIRI vocab.IRI) (crypto.PublicKey, error) {
+		pub, c, err := f.verificationMethod(cl, keyIRI)
+		controller = c
+		return pub, err
+	}
+	_, err := ap.VerifyProof(body, keyFn)
+	if err == ap.ErrMissingProof {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var actor *vocab.Actor
+	err = vocab.OnIntransitiveActivity(it, func(a *vocab.IntransitiveActivity) error {
+		if vocab.IsNil(a.Actor) || !a.Actor.GetLink().Equal(controller) {
+			return errors.Unauthorizedf("integrity proof was not created by the activity's actor")
+		}
+		act, err := f.Storage.Load(a.Actor.GetLink())
+		if err != nil || vocab.IsNil(act) {
+			if act, err = cl.LoadIRI(a.Actor.GetLink()); err != nil {
+				return err
+			}
+		}
+		actor, err = vocab.ToActor(act)
+		return err
+	})
+	return actor, err
+}
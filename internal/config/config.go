@@ -45,6 +45,7 @@ type Options struct {
 	Hostname           string
 	ListenHost         string
 	SSHPort            int
+	SSHActorKeys       bool
 	HTTPPort           int
 	SocketPath         string
 	BaseURL            string
@@ -56,6 +57,8 @@ type Options struct {
 	Profile            bool
 	MastodonCompatible bool
 	ShuttingDown       bool
+	KeyRotationAge     time.Duration
+	KeyRotationOverlap time.Duration
 }
 
 func (o Options) StorageInitFns(l lw.Logger) ([]storage.InitFn, error) {
@@ -88,6 +91,7 @@ const (
 	KeyLogOutput                    = "LOG_OUTPUT"
 	KeyHostname                     = "HOSTNAME"
 	KeySSHPort                      = "SSH_PORT"
+	KeySSHActorKeys                 = "SSH_ACTOR_KEYS"
 	KeyHTTPPort                     = "HTTP_PORT"
 	KeyHTTPS                        = "HTTPS"
 	KeyCertPath                     = "CERT_PATH"
@@ -106,6 +110,8 @@ const (
 	KeyRequestCacheDisable          = "DISABLE_REQUEST_CACHE"
 	KeyStorageIndexDisable          = "DISABLE_STORAGE_INDEX"
 	KeyMastodonCompatibilityDisable = "DISABLE_MASTODON_COMPATIBILITY"
+	KeyKeyRotationAge               = "KEY_ROTATION_AGE"
+	KeyKeyRotationOverlap           = "KEY_ROTATION_OVERLAP"
 
 	varEnv     = "%env%"
 	varStorage = "%storage%"
@@ -120,6 +126,9 @@ const (
 
 const defaultDirPerm = os.ModeDir | os.ModePerm | 0700
 
+// DefaultKeyRotationOverlap is the time during which a rotated key can still be used to verify signatures.
+const DefaultKeyRotationOverlap = 7 * 24 * time.Hour
+
 func normalizeConfigPath(p string, o Options) string {
 	if len(p) == 0 {
 		return p
@@ -311,6 +320,7 @@ func LoadFromEnv(conf *Options) {
 		sshPort, _ := strconv.ParseUint(v, 10, 32)
 		conf.SSHPort = int(sshPort)
 	}
+	conf.SSHActorKeys, _ = strconv.ParseBool(Getval(KeySSHActorKeys, "false"))
 	if v := Getval(KeyHTTPPort, strconv.Itoa(RandPort())); v != "" {
 		httpPort, _ := strconv.ParseUint(v, 10, 32)
 		conf.HTTPPort = int(httpPort)
@@ -319,6 +329,13 @@ func LoadFromEnv(conf *Options) {
 	disableMastodonCompatibility, _ := strconv.ParseBool(Getval(KeyMastodonCompatibilityDisable, "false"))
 	conf.MastodonCompatible = !disableMastodonCompatibility
 
+	// A zero key rotation age disables the automatic key rotation
+	conf.KeyRotationAge, _ = time.ParseDuration(Getval(KeyKeyRotationAge, "0"))
+	conf.KeyRotationOverlap = DefaultKeyRotationOverlap
+	if overlap, err := time.ParseDuration(Getval(KeyKeyRotationOverlap, "")); err == nil && overlap >= 0 {
+		conf.KeyRotationOverlap = overlap
+	}
+
 	conf.KeyPath = normalizeConfigPath(Getval(KeyKeyPath, ""), *conf)
 	conf.CertPath = normalizeConfigPath(Getval(KeyCertPath, ""), *conf)
 }
@@ -380,6 +397,12 @@ func (o Options) PidPath() string {
 	return filepath.Join(o.RuntimePath(), name+".pid")
 }
 
+// LockPath is the path of the file used for ensuring that only one process has the storage open.
+func (o Options) LockPath() string {
+	name := strings.ToLower(o.pathInstanceName())
+	return filepath.Join(o.RuntimePath(), name+".lock")
+}
+
 func (o Options) WritePid() error {
 	pid := os.Getpid()
 	raw := make([]byte, 0)
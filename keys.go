@@ -0,0 +1,114 @@
+package fedbox
+
+import (
+	"context"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// RotateActorKeys replaces the keys of the actor with the received pairs, keeping the current ones verifiable
+// for the overlap duration, and disseminates the new keys through an Update activity.
+func (ctl *Base) RotateActorKeys(actor *vocab.Actor, overlap time.Duration, pairs ...ap.KeyPair) error {
+	// NOTE(marius): we initialize the client that we're going to use for Update
+	// dissemination with an HTTP-Signature based on the current private key.
+	saver := ctl.Saver(actor, false)
+	if err := ap.RotateKeys(ctl.Storage, overlap, pairs...)(actor); err != nil {
+		return errors.Annotatef(err, "unable to rotate keys for actor: %s", actor.ID)
+	}
+
+	outbox := vocab.Outbox.IRI(actor)
+	update := ap.WrapObjectInUpdate(actor, actor)
+	if _, err := saver.ProcessClientActivity(update, *actor, outbox); err != nil {
+		return errors.Annotatef(err, "unable to disseminate new keys for actor: %s", actor.ID)
+	}
+	return nil
+}
+
+// keysCreated returns the time when the current keys of the actor have been created.
+// For actors that predate us storing this information, we use the time the actor was published.
+func (ctl *Base) keysCreated(act *vocab.Actor) time.Time {
+	m := new(ap.Metadata)
+	if err := ctl.Storage.LoadMetadata(act.ID, m); err == nil && !m.KeyCreated.IsZero() {
+		return m.KeyCreated
+	}
+	return act.Published
+}
+
+// RotateStaleKeys rotates the keys of all the local actors that have keys older than maxAge.
+func (ctl *Base) RotateStaleKeys(maxAge, overlap time.Duration) (int, error) {
+	iri := ap.SearchActorsIRI(vocab.IRI(ctl.Conf.BaseURL), ap.ByType(vocab.ActorTypes...))
+	now := time.Now().UTC()
+	actors := make([]*vocab.Actor, 0)
+	err := streamCollection(ctl, iri, func(it vocab.Item) error {
+		return vocab.OnActor(it, func(act *vocab.Actor) error {
+			if act.PublicKey.PublicKeyPem == "" || !act.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+				return nil
+			}
+			if now.Sub(ctl.keysCreated(act)) > maxAge {
+				actors = append(actors, act)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	errs := make([]error, 0)
+	for _, act := range actors {
+		pairs, err := ap.GenerateKeyPairs(actorKeyTypes(ctl.Conf)...)
+		if err != nil {
+			return rotated, err
+		}
+		if err = ctl.RotateActorKeys(act, overlap, pairs...); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rotated++
+	}
+	return rotated, errors.Join(errs...)
+}
+
+// keyRotationInterval is how often we check for actors that need their keys rotated.
+var keyRotationInterval = 24 * time.Hour
+
+// scheduleKeyRotation periodically rotates the stale keys of local actors, until the context is canceled.
+func (f *FedBOX) scheduleKeyRotation(ctx context.Context) {
+	if f.Conf.KeyRotationAge <= 0 {
+		return
+	}
+
+	l := f.Logger.WithContext(lw.Ctx{"age": f.Conf.KeyRotationAge, "overlap": f.Conf.KeyRotationOverlap})
+	rotate := func() {
+		if f.maintenanceMode.Load() || f.readOnlyMode.Load() || f.shuttingDown.Load() {
+			return
+		}
+		count, err := f.RotateStaleKeys(f.Conf.KeyRotationAge, f.Conf.KeyRotationOverlap)
+		if err != nil {
+			l.WithContext(lw.Ctx{"err": err.Error()}).Warnf("Unable to rotate actor keys")
+		}
+		if count > 0 {
+			l.WithContext(lw.Ctx{"count": count}).Infof("Rotated actor keys")
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(keyRotationInterval)
+		defer ticker.Stop()
+
+		rotate()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rotate()
+			}
+		}
+	}()
+}
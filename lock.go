@@ -0,0 +1,50 @@
+package fedbox
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+// storageLock is an exclusive lock on the storage of an instance, held by the process that has it open:
+// the server, while it's not in maintenance mode, or a CLI command when there's no server running.
+type storageLock struct {
+	f *os.File
+}
+
+// lockStorage acquires the exclusive lock for the storage of the instance. It doesn't wait
+// for the lock to be released if another process holds it, and returns an error instead.
+func lockStorage(conf config.Options) (*storageLock, error) {
+	path := conf.LockPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to open lock file %s", path)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errors.Conflictf("the storage is in use by another %s process", AppName)
+		}
+		return nil, errors.Annotatef(err, "unable to lock %s", path)
+	}
+	return &storageLock{f: f}, nil
+}
+
+// Unlock releases the lock. It's safe to call it on a nil lock.
+func (l *storageLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	defer func() { l.f = nil }()
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		_ = l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
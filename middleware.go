@@ -1,12 +1,18 @@
 package fedbox
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -42,9 +48,13 @@ func CleanRequestPath(next http.Handler) http.Handler {
 	})
 }
 
+// retryAfter is the delay we recommend to clients that are refused while the server is in maintenance.
+var retryAfter = 5 * time.Minute
+
 var (
 	errShuttingDown = errors.ServiceUnavailablef("server is shutting down")
 	errOutOfOrder   = errors.ServiceUnavailablef("temporarily out of order")
+	errReadOnly     = errors.ServiceUnavailablef("temporarily read-only")
 
 	outOfOrderCollectionHandler = func(path vocab.CollectionPath, request *http.Request) (vocab.CollectionInterface, error) {
 		return nil, errOutOfOrder
@@ -63,10 +73,159 @@ func OutOfOrderMw(f *FedBOX) func(next http.Handler) http.Handler {
 			maybeOoOHandler := next
 			if f.shuttingDown.Load() {
 				maybeOoOHandler = errors.HandleError(errShuttingDown)
-			} else if f.maintenanceMode.Load() {
-				maybeOoOHandler = errors.HandleError(errOutOfOrder)
+			} else if f.maintenanceMode.Load() || (f.readOnlyMode.Load() && !isSafeMethod(r.Method)) {
+				if f.trySpool(w, r) {
+					return
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+				maybeOoOHandler = errors.HandleError(errReadOnly)
+				if f.maintenanceMode.Load() {
+					maybeOoOHandler = errors.HandleError(errOutOfOrder)
+				}
 			}
 			maybeOoOHandler.ServeHTTP(w, r)
 		})
 	}
 }
+
+// isSafeMethod checks if the HTTP method is one that doesn't modify anything on the server.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// bufferedResponse is a http.ResponseWriter that holds the response in memory,
+// so it can be modified before being sent to the client.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+// LocalActorMw adds to the representation of local actors the properties that the vocab.Actor type doesn't have
+// a place for: the FEP-521a assertionMethod, the archive endpoint, manuallyApprovesFollowers, and the alsoKnownAs
+// and movedTo properties of the actors that have been moved.
+func LocalActorMw(f *FedBOX) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res := bufferedResponse{header: w.Header(), status: http.StatusOK}
+			next.ServeHTTP(&res, r)
+
+			body := res.body.Bytes()
+			if res.status == http.StatusOK {
+				if withProperties, err := f.addLocalActorProperties(body, vocab.IRI(reqURL(*r, f.Conf.Secure))); err == nil {
+					body = withProperties
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			w.WriteHeader(res.status)
+			_, _ = w.Write(body)
+		})
+	}
+}
+
+// addLocalActorProperties adds to the local actor found in the raw JSON body the properties that LocalActorMw
+// publishes. It returns an error if the body doesn't represent a local actor.
+func (ctl *Base) addLocalActorProperties(body []byte, reqIRI vocab.IRI) ([]byte, error) {
+	document, err := ap.DecodeActorDocument(body)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctl.setLocalActorProperties(document, reqIRI); err != nil {
+		return nil, err
+	}
+	return document.Encode()
+}
+
+// setLocalActorProperties sets on the document of a local actor the assertion methods for its additional or
+// retired keys, the archive endpoint, manuallyApprovesFollowers if it has been enabled, and its alsoKnownAs
+// and movedTo properties. It returns an error if the document doesn't represent a local actor.
+func (ctl *Base) setLocalActorProperties(document ap.ActorDocument, reqIRI vocab.IRI) error {
+	act := document.Actor()
+	if act == nil {
+		return errors.Newf("not an actor")
+	}
+	local := act.ID.Contains(vocab.IRI(ctl.Conf.BaseURL), false)
+	// The actors that have been moved by rehoming the instance keep being served at their old IRI,
+	// as long as the old hostname points to us, so we need to publish their movedTo property.
+	if !local && !sameHost(act.ID, reqIRI) {
+		return errors.Newf("not a local actor")
+	}
+
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(act.ID, m)
+	if local {
+		if methods := ap.AssertionMethods(act, m); len(methods) > 0 {
+			document.SetAssertionMethods(methods)
+		}
+		document.SetEndpoint(ap.ArchiveEndpoint, ap.ArchiveIRI(act))
+		if m.ManuallyApprovesFollowers {
+			document.SetManuallyApprovesFollowers(true)
+		}
+	}
+	if len(m.AlsoKnownAs) > 0 {
+		document.SetAlsoKnownAs(m.AlsoKnownAs)
+	}
+	if m.MovedTo != "" {
+		document.SetMovedTo(m.MovedTo)
+	}
+	return nil
+}
+
+// withLocalActorProperties wraps the signFn request signing function, and before signing it adds the properties
+// published by LocalActorMw to the local actors that are embedded in the activities of the POST requests,
+// as the remote servers use the embedded actor, eg: for updating their copy after an Update activity.
+func withLocalActorProperties(ctl *Base, signFn func(*http.Request) error) func(*http.Request) error {
+	return func(r *http.Request) error {
+		if r.Method != http.MethodPost || r.Body == nil {
+			return signFn(r)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return err
+		}
+		withProperties, err := ap.EditActivityObject(body, func(object ap.ActorDocument) error {
+			return ctl.setLocalActorProperties(object, "")
+		})
+		if err == nil {
+			body = withProperties
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		return signFn(r)
+	}
+}
+
+func sameHost(a, b vocab.IRI) bool {
+	ua, err := a.URL()
+	if err != nil {
+		return false
+	}
+	ub, err := b.URL()
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
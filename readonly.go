@@ -0,0 +1,140 @@
+package fedbox
+
+import (
+	"sync/atomic"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/openshift/osin"
+)
+
+// readOnlyStorage wraps the storage used by the server for its own writes, and refuses them while
+// the read-only mode is set.
+// The storage backends can't be reopened read-only without closing them, which would stop us
+// from serving GET requests, so we refuse the writes here instead. This covers the activities that are still
+// being processed asynchronously when the read-only mode gets set, which the middleware can't stop anymore.
+type readOnlyStorage struct {
+	storage.FullStorage
+	readOnly *atomic.Bool
+}
+
+var _ storage.FullStorage = readOnlyStorage{}
+
+func (s readOnlyStorage) writable() error {
+	if s.readOnly != nil && s.readOnly.Load() {
+		return errReadOnly
+	}
+	return nil
+}
+
+func (s readOnlyStorage) Save(it vocab.Item) (vocab.Item, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	return s.FullStorage.Save(it)
+}
+
+func (s readOnlyStorage) Delete(it vocab.Item) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.Delete(it)
+}
+
+func (s readOnlyStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	return s.FullStorage.Create(col)
+}
+
+func (s readOnlyStorage) AddTo(col vocab.IRI, items ...vocab.Item) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.AddTo(col, items...)
+}
+
+func (s readOnlyStorage) RemoveFrom(col vocab.IRI, items ...vocab.Item) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.RemoveFrom(col, items...)
+}
+
+func (s readOnlyStorage) SaveMetadata(iri vocab.IRI, m any) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.SaveMetadata(iri, m)
+}
+
+func (s readOnlyStorage) PasswordSet(iri vocab.IRI, pw []byte) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.PasswordSet(iri, pw)
+}
+
+func (s readOnlyStorage) SaveClient(c osin.Client) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.SaveClient(c)
+}
+
+func (s readOnlyStorage) CreateClient(c osin.Client) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.CreateClient(c)
+}
+
+func (s readOnlyStorage) UpdateClient(c osin.Client) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.UpdateClient(c)
+}
+
+func (s readOnlyStorage) RemoveClient(id string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.RemoveClient(id)
+}
+
+func (s readOnlyStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.SaveAuthorize(data)
+}
+
+func (s readOnlyStorage) RemoveAuthorize(code string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.RemoveAuthorize(code)
+}
+
+func (s readOnlyStorage) SaveAccess(data *osin.AccessData) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.SaveAccess(data)
+}
+
+func (s readOnlyStorage) RemoveAccess(token string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.RemoveAccess(token)
+}
+
+func (s readOnlyStorage) RemoveRefresh(token string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	return s.FullStorage.RemoveRefresh(token)
+}
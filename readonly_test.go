@@ -0,0 +1,28 @@
+package fedbox
+
+import (
+	"sync/atomic"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestReadOnlyStorage(t *testing.T) {
+	readOnly := atomic.Bool{}
+	readOnly.Store(true)
+
+	// The wrapped storage is nil, so any write that isn't refused panics
+	s := readOnlyStorage{readOnly: &readOnly}
+	if _, err := s.Save(&vocab.Object{ID: "https://example.com/1"}); err != errReadOnly {
+		t.Errorf("Save() error = %v, expected %v", err, errReadOnly)
+	}
+	if err := s.AddTo("https://example.com/inbox", vocab.IRI("https://example.com/1")); err != errReadOnly {
+		t.Errorf("AddTo() error = %v, expected %v", err, errReadOnly)
+	}
+	if err := s.SaveMetadata("https://example.com/actor", nil); err != errReadOnly {
+		t.Errorf("SaveMetadata() error = %v, expected %v", err, errReadOnly)
+	}
+	if err := s.RemoveAccess("token"); err != errReadOnly {
+		t.Errorf("RemoveAccess() error = %v, expected %v", err, errReadOnly)
+	}
+}
@@ -0,0 +1,264 @@
+package fedbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-ap/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// remoteDialTimeout is how long we wait for the running server to accept a connection on its internal socket.
+var remoteDialTimeout = time.Second
+
+// remoteClient returns an HTTP client for the admin API of the running server,
+// or nil if there's no server listening on the internal socket.
+func (ctl *Base) remoteClient() *http.Client {
+	sockPath := ctl.Conf.InternalSocketPath()
+	conn, err := net.DialTimeout("unix", sockPath, remoteDialTimeout)
+	if err != nil {
+		return nil
+	}
+	_ = conn.Close()
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: remoteDialTimeout}
+				return d.DialContext(ctx, "unix", sockPath)
+			},
+		},
+	}
+}
+
+// errRemoteUnavailable is returned when the running server can't execute commands, and they need to be run
+// directly against the storage.
+var errRemoteUnavailable = errors.ServiceUnavailablef("server is not able to run commands")
+
+// runRemote executes the command selected in the kong context through the admin API of the running server.
+// The standard input is forwarded to the command while its output is shown, so the commands can be interactive.
+func (ctl *Base) runRemote(cl *http.Client, ktx *kong.Context) error {
+	stdin, err := commandStdin(ktx, ctl.in, ctl.out)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(adminCommandRequest{Args: remoteArgs(ktx)})
+	if err != nil {
+		return err
+	}
+	body := io.MultiReader(bytes.NewReader(raw), stdin)
+	// The host part of the URL is not relevant, as the connection goes through the socket
+	resp, err := cl.Post("http://fedbox/command", "application/json", body)
+	if err != nil {
+		return errors.Annotatef(err, "unable to send command to the running server")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return errRemoteUnavailable
+	}
+	if resp.StatusCode != http.StatusOK {
+		raw, _ = io.ReadAll(resp.Body)
+		if errs, _ := errors.UnmarshalJSON(raw); len(errs) > 0 {
+			return errors.AnnotateFromStatus(errors.Join(errs...), resp.StatusCode, "the running server refused the command")
+		}
+		return errors.NewFromStatus(resp.StatusCode, "the running server refused the command")
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		frame := adminCommandFrame{}
+		if err = dec.Decode(&frame); err != nil {
+			return errors.Annotatef(err, "invalid response from the running server")
+		}
+		_, _ = io.WriteString(ctl.out, frame.Stdout)
+		_, _ = io.WriteString(ctl.err, frame.Stderr)
+		if !frame.Done {
+			continue
+		}
+		if frame.Error != "" {
+			return errors.NewFromStatus(frame.Status, "%s", frame.Error)
+		}
+		return nil
+	}
+}
+
+// remoteArgs returns the arguments of the command, without the global flags, which are only valid for the CLI.
+func remoteArgs(ktx *kong.Context) []string {
+	global := make(map[string]*kong.Flag)
+	for _, fl := range ktx.Model.Flags {
+		global["--"+fl.Name] = fl
+		if fl.Short != 0 {
+			global["-"+string(fl.Short)] = fl
+		}
+	}
+
+	paths := resolvedPaths(ktx)
+	args := make([]string, 0, len(ktx.Args))
+	for i := 0; i < len(ktx.Args); i++ {
+		arg := ktx.Args[i]
+		if arg == "--" {
+			args = append(args, ktx.Args[i:]...)
+			break
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		fl, ok := global[name]
+		if !ok {
+			if isShortGlobal(arg, global) {
+				continue
+			}
+			args = append(args, absPath(arg, paths))
+			continue
+		}
+		if !hasValue && !fl.IsBool() && !fl.IsCounter() {
+			i++
+		}
+	}
+	return args
+}
+
+// resolvedPaths returns the absolute paths received as arguments by the selected command: the values of the path
+// arguments, which kong has made absolute, and the names of the files it opened.
+func resolvedPaths(ktx *kong.Context) map[string]struct{} {
+	paths := make(map[string]struct{})
+	node := ktx.Selected()
+	if node == nil {
+		return paths
+	}
+	values := make([]*kong.Value, 0)
+	values = append(values, node.Positional...)
+	for _, fl := range node.Flags {
+		values = append(values, fl.Value)
+	}
+	for _, v := range values {
+		if v.Tag == nil || !v.Set || !v.Target.IsValid() {
+			continue
+		}
+		switch v.Tag.Type {
+		case "path", "existingfile", "existingdir":
+		default:
+			continue
+		}
+		switch p := v.Target.Interface().(type) {
+		case string:
+			paths[p] = struct{}{}
+		case []string:
+			for _, pp := range p {
+				paths[pp] = struct{}{}
+			}
+		}
+	}
+	// Kong opens the files received as arguments, but it doesn't make their paths absolute
+	for _, v := range values {
+		if !v.Set || !v.Target.IsValid() {
+			continue
+		}
+		files := make([]*os.File, 0)
+		switch f := v.Target.Interface().(type) {
+		case *os.File:
+			files = append(files, f)
+		case []*os.File:
+			files = append(files, f...)
+		}
+		for _, f := range files {
+			if f == nil || f == os.Stdin {
+				continue
+			}
+			if abs, err := filepath.Abs(f.Name()); err == nil {
+				paths[abs] = struct{}{}
+			}
+		}
+	}
+	return paths
+}
+
+// absPath returns the absolute path for arg, if it's one of the paths received by the command.
+// The running server has a different working directory, so we can't send it relative paths.
+func absPath(arg string, paths map[string]struct{}) string {
+	prefix, val, isFlag := strings.Cut(arg, "=")
+	if !isFlag {
+		prefix, val = "", arg
+	} else {
+		prefix += "="
+	}
+	if val == "" || filepath.IsAbs(val) {
+		return arg
+	}
+	abs, err := filepath.Abs(val)
+	if err != nil {
+		return arg
+	}
+	if _, ok := paths[abs]; !ok {
+		return arg
+	}
+	return prefix + abs
+}
+
+// isShortGlobal checks if arg is a group of short global flags, like "-vv".
+func isShortGlobal(arg string, global map[string]*kong.Flag) bool {
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return false
+	}
+	for _, c := range arg[1:] {
+		fl, ok := global["-"+string(c)]
+		if !ok || !(fl.IsBool() || fl.IsCounter()) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandStdin returns the input we forward to the command. When we're connected to a terminal the passwords
+// are read locally, so they don't get echoed, and they are sent before the rest of the standard input.
+func commandStdin(ktx *kong.Context, in io.Reader, out io.Writer) (io.Reader, error) {
+	prompts := passwordPrompts(ktx)
+	if f, ok := in.(*os.File); len(prompts) == 0 || !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return in, nil
+	}
+
+	rw := muxReadWriter{Reader: in, Writer: out}
+	pws := strings.Builder{}
+	for _, prompt := range prompts {
+		pw, err := loadPwFromStdin(rw, prompt)
+		if err != nil {
+			return nil, err
+		}
+		// The command on the server side asks for the password, and for its confirmation
+		pws.Write(pw)
+		pws.WriteString("\n")
+		pws.Write(pw)
+		pws.WriteString("\n")
+	}
+	return io.MultiReader(strings.NewReader(pws.String()), in), nil
+}
+
+// passwordPrompts returns the prompts for the passwords the selected command reads from its input.
+func passwordPrompts(ktx *kong.Context) []string {
+	node := ktx.Selected()
+	if node == nil || !node.Target.IsValid() {
+		return nil
+	}
+	switch cmd := node.Target.Interface().(type) {
+	case AddActorCmd:
+		prompts := make([]string, 0, len(cmd.Names))
+		for _, name := range cmd.Names {
+			prompts = append(prompts, name+"'s password: ")
+		}
+		return prompts
+	case AddClient:
+		return []string{"client's password: "}
+	case ChangePassword:
+		return []string{cmd.IRI.String() + ": "}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package fedbox
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// commandRoles contains the minimum role an actor needs for running a command.
+// The commands that are not present here can be run only by admins.
+var commandRoles = map[string]ap.Role{
+	"pub add":                  ap.RoleModerator,
+	"pub list":                 ap.RoleModerator,
+	"pub info":                 ap.RoleModerator,
+	"pub delete":               ap.RoleModerator,
+	"pub move":                 ap.RoleModerator,
+	"pub copy":                 ap.RoleModerator,
+	"pub actor list":           ap.RoleModerator,
+	"pub actor show":           ap.RoleModerator,
+	"pub actor suspend":        ap.RoleModerator,
+	"pub actor unsuspend":      ap.RoleModerator,
+	"oauth client list":        ap.RoleModerator,
+	"accounts pass":            ap.RoleUser,
+	"accounts rotate-keys":     ap.RoleUser,
+	"accounts ssh-keys add":    ap.RoleUser,
+	"accounts ssh-keys list":   ap.RoleUser,
+	"accounts ssh-keys remove": ap.RoleUser,
+}
+
+// selfCommands contains the commands that actors that are not admins can run only on their own actor.
+var selfCommands = []string{
+	"accounts pass",
+	"accounts rotate-keys",
+	"accounts ssh-keys add",
+	"accounts ssh-keys list",
+	"accounts ssh-keys remove",
+}
+
+// adminFlags contains the flags of the commands that can be used only by admins, as they allow acting
+// on behalf of other actors.
+var adminFlags = map[string][]string{
+	"pub add": {"attributed-to"},
+}
+
+// RoleOf returns the role of the actor. The service actor is always an admin,
+// and the actors that don't have one set are regular users.
+func (ctl *Base) RoleOf(actor vocab.IRI) ap.Role {
+	if ctl.Service.ID.Equal(actor) {
+		return ap.RoleAdmin
+	}
+	m := new(ap.Metadata)
+	if err := ctl.Storage.LoadMetadata(actor, m); err != nil || ap.ValidRole(m.Role) != nil {
+		return ap.RoleUser
+	}
+	return m.Role
+}
+
+// canModerate checks that the actor running the command has a higher role than the target actor,
+// so moderators can act neither on each other, nor on the admins.
+// The commands that are not run through SSH sessions are run by the operator of the instance.
+func (ctl *Base) canModerate(target vocab.IRI) error {
+	if ctl.actor == nil {
+		return nil
+	}
+	role := ctl.RoleOf(ctl.actor.ID)
+	if other := ctl.RoleOf(target); other.Includes(role) {
+		return errors.Forbiddenf("%s role is not allowed to moderate the %s actor %s", role, other, target)
+	}
+	return nil
+}
+
+// canModerateItem checks that the actor running the command can act on the item, similarly to canModerate,
+// but the admins can act on all items, and the other actors can act on their own items.
+func (ctl *Base) canModerateItem(it vocab.Item) error {
+	if ctl.actor == nil || ctl.RoleOf(ctl.actor.ID) == ap.RoleAdmin {
+		return nil
+	}
+	for _, owner := range ctl.itemOwners(it) {
+		if owner.Equal(ctl.actor.ID) {
+			continue
+		}
+		if err := ctl.canModerate(owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemOwners returns the actors that own the item: the actor itself, the actor of an activity,
+// the owner of a collection, or the actors to which an object is attributed.
+// The items that don't have an owner belong to the service.
+func (ctl *Base) itemOwners(it vocab.Item) vocab.IRIs {
+	owners := make(vocab.IRIs, 0)
+	switch {
+	case vocab.IsNil(it):
+	case vocab.ActorTypes.Match(it.GetType()):
+		owners = append(owners, it.GetLink())
+	case vocab.IsIRI(it):
+		if owner, typ := vocab.Split(it.GetLink()); vocab.ValidCollection(typ) {
+			owners = append(owners, owner)
+		}
+	case vocab.ActivityTypes.Match(it.GetType()), vocab.IntransitiveActivityTypes.Match(it.GetType()):
+		_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+			if !vocab.IsNil(act.Actor) {
+				owners = append(owners, act.Actor.GetLink())
+			}
+			return nil
+		})
+	default:
+		_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+			if vocab.IsNil(ob.AttributedTo) {
+				return nil
+			}
+			if vocab.IsItemCollection(ob.AttributedTo) {
+				return vocab.OnCollectionIntf(ob.AttributedTo, func(col vocab.CollectionInterface) error {
+					owners = append(owners, col.Collection().IRIs()...)
+					return nil
+				})
+			}
+			owners = append(owners, ob.AttributedTo.GetLink())
+			return nil
+		})
+	}
+	if len(owners) == 0 {
+		owners = append(owners, ctl.Service.ID)
+	}
+	return owners
+}
+
+// Author returns the actor that is used as the author of the activities created by the commands:
+// the authenticated actor for SSH sessions, and the service actor otherwise.
+func (ctl *Base) Author() vocab.Actor {
+	if ctl.actor != nil {
+		return *ctl.actor
+	}
+	return ctl.Service
+}
+
+// commandPath returns the names of the commands selected in the kong context, eg: "accounts ssh-keys add".
+func commandPath(ktx *kong.Context) string {
+	names := make([]string, 0, len(ktx.Path))
+	for _, p := range ktx.Path {
+		if p.Command != nil {
+			names = append(names, p.Command.Name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// commandIRIs returns the IRIs received as positional arguments by the selected command.
+func commandIRIs(ktx *kong.Context) vocab.IRIs {
+	iris := make(vocab.IRIs, 0)
+	node := ktx.Selected()
+	if node == nil {
+		return iris
+	}
+	for _, pos := range node.Positional {
+		if !pos.Target.IsValid() {
+			continue
+		}
+		switch v := pos.Target.Interface().(type) {
+		case vocab.IRI:
+			iris = append(iris, v)
+		case []vocab.IRI:
+			iris = append(iris, v...)
+		}
+	}
+	return iris
+}
+
+// authorizeCommand checks if the actor has the role needed for running the command selected in the kong context.
+func authorizeCommand(ctl *Base, actor *vocab.Actor, ktx *kong.Context) error {
+	if actor == nil {
+		return errors.Unauthorizedf("unable to run commands without an authenticated actor")
+	}
+
+	cmd := commandPath(ktx)
+	role := ctl.RoleOf(actor.ID)
+	needed, ok := commandRoles[cmd]
+	if !ok {
+		needed = ap.RoleAdmin
+	}
+	if !role.Includes(needed) {
+		return errors.Forbiddenf("%s role is not allowed to run %q", role, cmd)
+	}
+	if role == ap.RoleAdmin {
+		return nil
+	}
+	for _, flag := range ktx.Flags() {
+		if slices.Contains(adminFlags[cmd], flag.Name) && flag.Target.IsValid() && !flag.Target.IsZero() {
+			return errors.Forbiddenf("%s role is not allowed to use --%s for %q", role, flag.Name, cmd)
+		}
+	}
+	if !slices.Contains(selfCommands, cmd) {
+		return nil
+	}
+
+	iris := commandIRIs(ktx)
+	if len(iris) == 0 {
+		return errors.Forbiddenf("%s role can run %q only for its own actor", role, cmd)
+	}
+	for _, iri := range iris {
+		if !iri.Equal(actor.ID) {
+			return errors.Forbiddenf("%s role can run %q only for its own actor", role, cmd)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+func TestBase_canModerate(t *testing.T) {
+	ctl, db := checkTestBase(t)
+
+	actors := map[ap.Role]vocab.IRI{
+		ap.RoleAdmin:     checkBaseURL + "/actors/admin",
+		ap.RoleModerator: checkBaseURL + "/actors/moderator",
+		ap.RoleUser:      checkBaseURL + "/actors/user",
+	}
+	for role, iri := range actors {
+		_ = db.SaveMetadata(iri, &ap.Metadata{Role: role})
+	}
+	other := vocab.IRI(checkBaseURL + "/actors/other-moderator")
+	_ = db.SaveMetadata(other, &ap.Metadata{Role: ap.RoleModerator})
+
+	tests := []struct {
+		caller ap.Role
+		target vocab.IRI
+		wantOK bool
+	}{
+		{caller: ap.RoleModerator, target: actors[ap.RoleUser], wantOK: true},
+		{caller: ap.RoleModerator, target: other, wantOK: false},
+		{caller: ap.RoleModerator, target: actors[ap.RoleAdmin], wantOK: false},
+		{caller: ap.RoleAdmin, target: actors[ap.RoleModerator], wantOK: true},
+		{caller: ap.RoleAdmin, target: actors[ap.RoleUser], wantOK: true},
+	}
+	for _, tt := range tests {
+		ctl.actor = &vocab.Actor{ID: actors[tt.caller]}
+		if err := ctl.canModerate(tt.target); (err == nil) != tt.wantOK {
+			t.Errorf("%s canModerate(%s) error = %v, want allowed %t", tt.caller, tt.target, err, tt.wantOK)
+		}
+	}
+
+	ctl.actor = nil
+	if err := ctl.canModerate(actors[ap.RoleAdmin]); err != nil {
+		t.Errorf("the local commands should be allowed to moderate admins: %s", err)
+	}
+}
+
+func TestBase_canModerateItem(t *testing.T) {
+	ctl, db := checkTestBase(t)
+
+	admin := vocab.IRI(checkBaseURL + "/actors/admin")
+	moderator := vocab.IRI(checkBaseURL + "/actors/moderator")
+	user := vocab.IRI(checkBaseURL + "/actors/user")
+	_ = db.SaveMetadata(admin, &ap.Metadata{Role: ap.RoleAdmin})
+	_ = db.SaveMetadata(moderator, &ap.Metadata{Role: ap.RoleModerator})
+
+	note := func(owner vocab.IRI) vocab.Item {
+		return &vocab.Object{ID: checkBaseURL + "/objects/1", Type: vocab.NoteType, AttributedTo: owner}
+	}
+	tests := []struct {
+		name   string
+		caller vocab.IRI
+		it     vocab.Item
+		wantOK bool
+	}{
+		{name: "user object", caller: moderator, it: note(user), wantOK: true},
+		{name: "own object", caller: moderator, it: note(moderator), wantOK: true},
+		{name: "admin object", caller: moderator, it: note(admin), wantOK: false},
+		{name: "object without owner", caller: moderator, it: &vocab.Object{ID: checkBaseURL + "/objects/2"}, wantOK: false},
+		{name: "admin actor", caller: moderator, it: &vocab.Actor{ID: admin, Type: vocab.PersonType}, wantOK: false},
+		{name: "user collection", caller: moderator, it: vocab.Outbox.IRI(user), wantOK: true},
+		{name: "admin collection", caller: moderator, it: vocab.Outbox.IRI(admin), wantOK: false},
+		{name: "admin on admin object", caller: admin, it: note(admin), wantOK: true},
+	}
+	for _, tt := range tests {
+		ctl.actor = &vocab.Actor{ID: tt.caller}
+		if err := ctl.canModerateItem(tt.it); (err == nil) != tt.wantOK {
+			t.Errorf("%s: canModerateItem() error = %v, want allowed %t", tt.name, err, tt.wantOK)
+		}
+	}
+}
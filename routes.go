@@ -7,6 +7,7 @@ import (
 
 	"git.sr.ht/~mariusor/lw"
 	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/processing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -43,7 +44,7 @@ func (f *FedBOX) Routes() func(chi.Router) {
 		r.Use(lw.Middlewares(f.Logger)...)
 		r.Use(middleware.RequestID, c.Handler, CleanRequestPath, SetRequestHost(f), OutOfOrderMw(f))
 
-		r.Method(http.MethodGet, "/", HandleItem(f))
+		r.With(LocalActorMw(f)).Method(http.MethodGet, "/", HandleItem(f))
 		r.Method(http.MethodHead, "/", HandleItem(f))
 		r.Method(http.MethodPost, "/proxyUrl", ProxyURL(f))
 		// TODO(marius): we can separate here the FedBOX specific collections from the ActivityPub spec ones
@@ -73,9 +74,10 @@ func (f *FedBOX) CollectionRoutes(descend bool) func(chi.Router) {
 			r.Method(http.MethodHead, "/", HandleCollection(f))
 
 			r.Route("/{id}", func(r chi.Router) {
-				r.Method(http.MethodGet, "/", HandleItem(f))
+				r.With(LocalActorMw(f)).Method(http.MethodGet, "/", HandleItem(f))
 				r.Method(http.MethodHead, "/", HandleItem(f))
 				if descend {
+					r.Route("/"+ap.ArchiveEndpoint, f.ArchiveRoutes)
 					r.Route("/{collection}", f.CollectionRoutes(false))
 				}
 			})
@@ -3,7 +3,6 @@ package fedbox
 import (
 	"fmt"
 	"io"
-	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -23,7 +22,10 @@ var kongDefaultVars = kong.Vars{
 	"storageTypes":        fmt.Sprintf("%s, %s, %s, %s", config.StorageFS, config.StorageSqlite, config.StorageBoltDB, config.StorageBadger),
 	"defaultKeyType":      string(ap.KeyTypeRSA),
 	"defaultWaitDuration": defaultWaitDuration.String(),
+	"keyRotationOverlap":  config.DefaultKeyRotationOverlap.String(),
+	"roles":               fmt.Sprintf("%s, %s, %s", ap.RoleAdmin, ap.RoleModerator, ap.RoleUser),
 	"defaultObjectTypes":  fmt.Sprintf("%v", ValidGenericTypes),
+	"maxMediaSize":        fmt.Sprintf("%d", defaultMastodonMediaSize),
 }
 
 var CTLRun = new(CTL)
@@ -49,26 +51,44 @@ func Run(args ...string) error {
 	if err != nil {
 		return err
 	}
-	cmd := ctx.Command()
+	cmd := commandPath(ctx)
 	switch cmd {
-	case "maintenance", "stop", "reload", "run":
+	case "maintenance", "debug", "stop", "reload", "status", "run":
 		// NOTE(marius): these don't interact with the storage, and additionally,
-		// they involve sending their own signals, so we skip pausing.
-	default:
-		pauseFn := ctl.SendSignalToServer(syscall.SIGUSR1)
-		if err = pauseFn(); err == nil {
-			defer func() { _ = pauseFn() }()
+		// they involve sending their own signals, so they are run locally.
+		return ctx.Run(ctl)
+	case "storage restore":
+		if restore, ok := ctx.Selected().Target.Interface().(RestoreCmd); ok && restore.VerifyOnly {
+			return ctx.Run(ctl)
 		}
-		if cmd != "storage bootstrap" {
-			if err = ctl.Storage.Open(); err != nil {
-				return err
-			}
-			if err = ctl.LoadServiceActor(); err != nil {
-				return err
-			}
-			defer ctl.Storage.Close()
+	}
+
+	// The commands that replace the storage files can't be executed by the running server
+	withoutStorage := cmd == "storage bootstrap" || cmd == "storage restore"
+
+	// If there's a server running, we execute the command through it,
+	// as it already has the storage open.
+	if cl := ctl.remoteClient(); cl != nil && !withoutStorage {
+		if err = ctl.runRemote(cl, ctx); err != errRemoteUnavailable {
+			return err
 		}
 	}
 
-	return ctx.Run(ctl)
+	lock, err := lockStorage(ctl.Conf)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	if withoutStorage {
+		return ctx.Run(ctl)
+	}
+	if err = ctl.Storage.Open(); err != nil {
+		return err
+	}
+	defer ctl.Storage.Close()
+	if err = ctl.LoadServiceActor(); err != nil {
+		return err
+	}
+	return ctl.runAudited(ctx)
 }
@@ -0,0 +1,226 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+	"github.com/pborman/uuid"
+)
+
+// spooledActivity is an activity received in an inbox while the server was in maintenance,
+// which gets processed once the maintenance ends.
+type spooledActivity struct {
+	ReceivedIn vocab.IRI       `json:"receivedIn"`
+	Received   time.Time       `json:"received"`
+	Actor      json.RawMessage `json:"actor"`
+	Activity   json.RawMessage `json:"activity"`
+}
+
+const (
+	spoolDir       = ".spool"
+	spoolExt       = ".json"
+	spoolFailedExt = ".failed"
+)
+
+// spoolPath returns the folder where we save the activities received during maintenance.
+func (f *FedBOX) spoolPath() (string, error) {
+	base, err := f.Conf.BaseStoragePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, spoolDir), nil
+}
+
+// isSpoolable checks if the request is an activity delivered to an inbox, which we can save for later.
+func (f *FedBOX) isSpoolable(r *http.Request) (vocab.IRI, bool) {
+	if ok, _ := ValidateActivityRequest(r); !ok {
+		return "", false
+	}
+	receivedIn := vocab.IRI(reqURL(*r, f.Conf.Secure))
+	return receivedIn, processing.IsInbox(receivedIn)
+}
+
+// spoolActivity verifies the HTTP signature of an activity delivered to an inbox while the server is in maintenance,
+// and saves it to disk, to be processed when the maintenance ends.
+// The storage can be closed during maintenance, so the actor that signed the request
+// is loaded only from its origin server.
+func (f *FedBOX) spoolActivity(receivedIn vocab.IRI, r *http.Request) error {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errors.NewBadRequest(err, "unable to read request body")
+	}
+	it, err := vocab.UnmarshalJSON(body)
+	if err != nil || vocab.IsNil(it) {
+		return errors.NewBadRequest(err, "unable to unmarshal JSON request")
+	}
+
+	// The signature verification consumes the body, so we need to put it back
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	l := f.Logger.WithContext(lw.Ctx{"log": "spool"})
+	verifier := auth.HTTPSignature(
+		auth.WithClient(ActorClient(f.Base, vocab.PublicNS)),
+		auth.WithLogger(l),
+	)
+	actor, err := verifier.Verify(r)
+	if err != nil || actor.ID.Equal(vocab.PublicNS) {
+		return errors.Unauthorizedf("unable to verify the HTTP signature of the request")
+	}
+
+	rawActor, err := vocab.MarshalJSON(actor)
+	if err != nil {
+		return err
+	}
+	entry := spooledActivity{
+		ReceivedIn: receivedIn,
+		Received:   time.Now().UTC(),
+		Actor:      rawActor,
+		Activity:   body,
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path, err := f.spoolPath()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path, 0o700); err != nil {
+		return err
+	}
+	// The names of the files keep the order in which the activities have been received
+	name := fmt.Sprintf("%020d-%s%s", entry.Received.UnixNano(), uuid.New(), spoolExt)
+	if err = os.WriteFile(filepath.Join(path, name), raw, 0o600); err != nil {
+		return errors.Annotatef(err, "unable to save activity to spool")
+	}
+	l.WithContext(lw.Ctx{"actor": actor.ID, "in": receivedIn, "file": name}).Debugf("Saved activity to spool")
+	return nil
+}
+
+// trySpool saves the activities delivered to inboxes while the server is in maintenance, and answers with 202.
+// It returns false if the request hasn't been handled.
+func (f *FedBOX) trySpool(w http.ResponseWriter, r *http.Request) bool {
+	receivedIn, ok := f.isSpoolable(r)
+	if !ok {
+		return false
+	}
+	if err := f.spoolActivity(receivedIn, r); err != nil {
+		f.Logger.WithContext(lw.Ctx{"in": receivedIn, "err": err.Error()}).Warnf("Unable to spool activity")
+		if errors.IsUnauthorized(err) || errors.IsBadRequest(err) {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return true
+		}
+		// We let the sender retry later, as we'd do if we had no spool
+		return false
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return true
+}
+
+// spooledCount returns the number of activities waiting in the spool to be processed.
+func (f *FedBOX) spooledCount() int {
+	path, err := f.spoolPath()
+	if err != nil {
+		return 0
+	}
+	files, _ := os.ReadDir(path)
+	count := 0
+	for _, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), spoolExt) {
+			count++
+		}
+	}
+	return count
+}
+
+var replayMu sync.Mutex
+
+// replaySpool processes the activities that have been saved while the server was in maintenance.
+// The ones that fail processing are kept with a different extension, so they can be inspected.
+func (f *FedBOX) replaySpool() {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	path, err := f.spoolPath()
+	if err != nil {
+		return
+	}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), spoolExt) {
+			names = append(names, fi.Name())
+		}
+	}
+	slices.Sort(names)
+
+	l := f.Logger.WithContext(lw.Ctx{"log": "spool"})
+	processed := 0
+	for _, name := range names {
+		if f.maintenanceMode.Load() || f.readOnlyMode.Load() || f.shuttingDown.Load() {
+			// We'll continue when the server gets out of maintenance again
+			break
+		}
+		file := filepath.Join(path, name)
+		if err = f.replaySpooled(file, l); err != nil {
+			l.WithContext(lw.Ctx{"file": name, "err": err.Error()}).Warnf("Unable to process spooled activity")
+			_ = os.Rename(file, strings.TrimSuffix(file, spoolExt)+spoolFailedExt)
+			continue
+		}
+		_ = os.Remove(file)
+		processed++
+	}
+	if processed > 0 {
+		l.WithContext(lw.Ctx{"count": processed}).Infof("Processed spooled activities")
+	}
+}
+
+func (f *FedBOX) replaySpooled(file string, l lw.Logger) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	entry := spooledActivity{}
+	if err = json.Unmarshal(raw, &entry); err != nil {
+		return err
+	}
+	it, err := vocab.UnmarshalJSON(entry.Activity)
+	if err != nil {
+		return err
+	}
+	actIt, err := vocab.UnmarshalJSON(entry.Actor)
+	if err != nil {
+		return err
+	}
+	actor, err := vocab.ToActor(actIt)
+	if err != nil {
+		return err
+	}
+	// The activity goes through the same checks as the live ones, as the signer or the author
+	// could have been suspended, or the integrity proof could have been unverifiable, while in maintenance
+	authorized, err := f.authorizeActivity(entry.Activity, it, *actor, entry.ReceivedIn, ActorClient(f.Base, vocab.PublicNS), l)
+	if err != nil {
+		return err
+	}
+	_, err = f.processActivity(it, authorized, entry.ReceivedIn, l)
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"charm.land/ssh"
@@ -18,8 +19,8 @@ import (
 	"git.sr.ht/~mariusor/lw"
 	"git.sr.ht/~mariusor/mask"
 	m "git.sr.ht/~mariusor/servermux"
-	"github.com/alecthomas/kong"
 	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
 	"golang.org/x/crypto/ed25519"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -39,7 +40,12 @@ func SSHAuthPw(f *FedBOX) ssh.PasswordHandler {
 
 func SSHAuthPublicKey(f *FedBOX) ssh.PublicKeyHandler {
 	return func(ctx ssh.Context, key ssh.PublicKey) bool {
-		acc, ok := publicKeyCheck(f, ctx.User(), key)
+		acc, hasKeys, ok := authorizedKeysCheck(f, ctx.User(), key)
+		if !hasKeys && f.Conf.SSHActorKeys {
+			// If it has been enabled in the configuration, the actors that haven't registered
+			// any SSH keys can still log in using the key pair of their ActivityPub actor
+			acc, ok = publicKeyCheck(f, ctx.User(), key)
+		}
 		if !ok {
 			f.Logger.WithContext(lw.Ctx{"iri": ctx.User()}).Warnf("failed public key authentication")
 			return false
@@ -56,44 +62,9 @@ func runSSHCommand(f *FedBOX, s ssh.Session) error {
 	if len(args) == 0 {
 		return fmt.Errorf("PTY is not interactive and no command was sent")
 	}
-	ctl := new(Base)
-	ctl.Conf = f.Conf
-	ctl.Logger = f.Logger
-	ctl.Service = f.Service
-	ctl.ServicePrivateKey = f.ServicePrivateKey
-	ctl.Storage = f.Storage
-	ctl.out = s
-	ctl.in = s
-	ctl.err = s.Stderr()
-
-	cmd := new(SSH)
-	kongDefaultVars["name"] = "FedBOX SSH"
-	kongDefaultVars["URL"] = string(f.Service.ID)
-	k, err := kong.New(
-		cmd,
-		kong.UsageOnError(),
-		kong.Name(kongDefaultVars["name"]),
-		kong.Description("${name} (version ${version}) ${URL}"),
-		kong.Writers(s, s.Stderr()),
-		kong.Exit(func(_ int) {}),
-		kongDefaultVars,
-	)
-	if err != nil {
-		return err
-	}
-
-	ktx, err := k.Parse(args)
-	if err != nil {
-		_ = k.Errorf("%s\n", err)
-		return err
-	}
-
-	if err = ktx.Run(ctl); err != nil {
-		_ = k.Errorf("%s\n", err)
-		return err
-	}
-	_ = k.Printf("OK\n")
-	return nil
+	actor, _ := s.Context().Value("actor").(*vocab.Actor)
+	ctl := f.commandBase(actor, s, s, s.Stderr())
+	return f.execCommand(ctl, "FedBOX SSH", args)
 }
 
 func MainTui(f *FedBOX) wish.Middleware {
@@ -141,14 +112,38 @@ func pwCheck(f *FedBOX, id string, pw []byte) (*vocab.Actor, bool) {
 		return nil, false
 	}
 	err = f.Storage.PasswordCheck(actor.ID, pw)
-	if err != nil {
+	if err != nil || f.IsSuspended(actor.ID) {
 		return nil, false
 	}
 	return actor, true
 }
 
+// authorizedKeysCheck verifies the session key against the list of SSH public keys registered by the actor.
+// The second returned value is false if the actor doesn't have any registered keys.
+func authorizedKeysCheck(f *FedBOX, id string, sessKey ssh.PublicKey) (*vocab.Actor, bool, bool) {
+	maybeActor, err := f.Storage.Load(vocab.IRI(id))
+	if err != nil {
+		return nil, false, false
+	}
+	actor, err := vocab.ToActor(maybeActor)
+	if err != nil {
+		return nil, false, false
+	}
+	m := new(ap.Metadata)
+	if err = f.Storage.LoadMetadata(actor.ID, m); err != nil || len(m.AuthorizedKeys) == 0 {
+		return actor, false, false
+	}
+	if m.IsSuspended(time.Now().UTC()) {
+		return nil, true, false
+	}
+	return actor, true, m.AuthorizedKeys.Authorizes(sessKey)
+}
+
 func publicKeyCheck(f *FedBOX, id string, sessKey ssh.PublicKey) (*vocab.Actor, bool) {
 	actorIRI := vocab.IRI(id)
+	if f.IsSuspended(actorIRI) {
+		return nil, false
+	}
 	maybeActor, err := f.Storage.Load(actorIRI)
 	if err != nil {
 		if f.Service.ID.Equals(actorIRI, false) {
@@ -225,12 +220,35 @@ func initSSHServer(app *FedBOX) (m.Server, error) {
 	}
 	initFns = append(initFns, wish.WithAddress(listen[0]))
 	app.Logger.WithContext(lw.Ctx{"host": app.Conf.ListenHost, "port": app.Conf.SSHPort}).Debugf("Accepting SSH requests")
-	if app.ServicePrivateKey != nil {
-		// NOTE(marius): use the service private key as a host key
-		if prvEnc, err := x509.MarshalPKCS8PrivateKey(app.ServicePrivateKey); err == nil {
-			r := pem.Block{Type: "PRIVATE KEY", Bytes: prvEnc}
-			initFns = append(initFns, wish.WithHostKeyPEM(pem.EncodeToMemory(&r)))
+
+	hostKeys, err := loadHostKeys(app)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range hostKeys {
+		_, prv, err := ap.EncodeKeyPair(pair)
+		if err != nil {
+			return nil, err
 		}
+		app.Logger.WithContext(lw.Ctx{"type": pair.Type, "fingerprint": ap.Fingerprint(pair.Public)}).Infof("SSH host key")
+		initFns = append(initFns, wish.WithHostKeyPEM(pem.EncodeToMemory(&prv)))
 	}
 	return m.SSHServer(initFns...)
 }
+
+// loadHostKeys loads the SSH host keys from the service actor's metadata.
+// If the service was bootstrapped before we had separate host keys, it generates and saves them.
+func loadHostKeys(app *FedBOX) ([]ap.KeyPair, error) {
+	hostKeys, err := ap.LoadHostKeys(app.Storage, app.Service.ID)
+	if err == nil && len(hostKeys) > 0 {
+		return hostKeys, nil
+	}
+
+	if hostKeys, err = ap.GenerateKeyPairs(ap.HostKeyTypes...); err != nil {
+		return nil, err
+	}
+	if err = ap.SaveHostKeys(app.Storage, app.Service.ID, hostKeys...); err != nil {
+		app.Logger.WithContext(lw.Ctx{"err": err.Error()}).Warnf("Unable to save generated SSH host keys")
+	}
+	return hostKeys, nil
+}
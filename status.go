@@ -0,0 +1,149 @@
+package fedbox
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/env"
+)
+
+// Status is the state of the running server, as reported by the admin API.
+type Status struct {
+	Name               string        `json:"name"`
+	Version            string        `json:"version"`
+	URL                string        `json:"url"`
+	Env                env.Type      `json:"env"`
+	Started            time.Time     `json:"started"`
+	Uptime             string        `json:"uptime"`
+	Storage            StorageStatus `json:"storage"`
+	Maintenance        bool          `json:"maintenance"`
+	ReadOnly           bool          `json:"readOnly"`
+	Debug              bool          `json:"debug"`
+	ShuttingDown       bool          `json:"shuttingDown"`
+	Listeners          []string      `json:"listeners"`
+	Caches             []CacheStatus `json:"caches"`
+	InFlightDeliveries int64         `json:"inFlightDeliveries"`
+	SpooledActivities  int           `json:"spooledActivities"`
+}
+
+type StorageStatus struct {
+	Type storage.Type `json:"type"`
+	Path string       `json:"path"`
+}
+
+type CacheStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Entries int    `json:"entries"`
+}
+
+// Status returns the current state of the server.
+func (f *FedBOX) Status() Status {
+	listeners := make([]string, 0)
+	listeners = append(listeners, f.Conf.HTTPListen()...)
+	listeners = append(listeners, f.Conf.SSHListen()...)
+	if !f.Conf.Env.IsTest() {
+		listeners = append(listeners, f.Conf.InternalSocketPath())
+	}
+
+	requests := CacheStatus{Name: "requests"}
+	if c, ok := f.caches.(*countingCache); ok {
+		requests.Enabled = true
+		requests.Entries = c.Len()
+	}
+
+	return Status{
+		Name:               AppName,
+		Version:            AppVersion,
+		URL:                f.Conf.BaseURL,
+		Env:                f.Conf.Env,
+		Started:            f.started,
+		Uptime:             time.Since(f.started).Truncate(time.Second).String(),
+		Storage:            StorageStatus{Type: f.Conf.Storage, Path: f.Conf.StoragePath},
+		Maintenance:        f.maintenanceMode.Load(),
+		ReadOnly:           f.readOnlyMode.Load(),
+		Debug:              f.debugMode.Load(),
+		ShuttingDown:       f.shuttingDown.Load(),
+		Listeners:          listeners,
+		Caches:             []CacheStatus{requests},
+		InFlightDeliveries: inFlightDeliveries.Load(),
+		SpooledActivities:  f.spooledCount(),
+	}
+}
+
+// countingCache keeps track of the keys stored in the wrapped cache, so we can report its size.
+// The wrapped cache can drop entries on its own, so we periodically forget the keys
+// that it doesn't hold anymore.
+type countingCache struct {
+	canStore
+	keys   sync.Map
+	stored atomic.Int64
+}
+
+// countingCachePruneInterval is the number of stores after which we check which keys are still in the cache.
+const countingCachePruneInterval = 1024
+
+func withCounting(c canStore) *countingCache {
+	return &countingCache{canStore: c}
+}
+
+func (c *countingCache) Store(key vocab.IRI, it vocab.Item) {
+	c.canStore.Store(key, it)
+	c.keys.Store(key, struct{}{})
+	if c.stored.Add(1)%countingCachePruneInterval == 0 {
+		c.prune()
+	}
+}
+
+func (c *countingCache) Delete(keys ...vocab.IRI) bool {
+	if len(keys) == 0 {
+		c.keys.Clear()
+	}
+	for _, key := range keys {
+		c.keys.Delete(key)
+	}
+	return c.canStore.Delete(keys...)
+}
+
+// prune forgets the keys that are not in the wrapped cache anymore.
+func (c *countingCache) prune() {
+	c.keys.Range(func(k, _ any) bool {
+		if key, ok := k.(vocab.IRI); ok && vocab.IsNil(c.canStore.Load(key)) {
+			c.keys.Delete(key)
+		}
+		return true
+	})
+}
+
+// Len returns the number of entries in the cache.
+func (c *countingCache) Len() int {
+	c.prune()
+	count := 0
+	c.keys.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// inFlightDeliveries is the number of outgoing activity deliveries for which we are waiting for a response.
+// The deliveries that are queued, but haven't been sent yet, are not counted.
+var inFlightDeliveries atomic.Int64
+
+// deliveryCounter is a [http.RoundTripper] that counts the outgoing POST requests that haven't finished yet.
+type deliveryCounter struct {
+	http.RoundTripper
+}
+
+func (d deliveryCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost {
+		return d.RoundTripper.RoundTrip(req)
+	}
+	inFlightDeliveries.Add(1)
+	defer inFlightDeliveries.Add(-1)
+	return d.RoundTripper.RoundTrip(req)
+}
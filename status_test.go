@@ -0,0 +1,36 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// evictingCache holds only the last stored item.
+type evictingCache struct {
+	key vocab.IRI
+	it  vocab.Item
+}
+
+func (e *evictingCache) Store(key vocab.IRI, it vocab.Item) { e.key, e.it = key, it }
+func (e *evictingCache) Load(key vocab.IRI) vocab.Item {
+	if key != e.key {
+		return nil
+	}
+	return e.it
+}
+func (e *evictingCache) Delete(_ ...vocab.IRI) bool { e.key, e.it = "", nil; return true }
+
+func TestCountingCache_Len(t *testing.T) {
+	c := withCounting(new(evictingCache))
+	for _, iri := range []vocab.IRI{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+		c.Store(iri, iri)
+	}
+	if got := c.Len(); got != 1 {
+		t.Errorf("Len() = %d, expected the evicted entries to not be counted", got)
+	}
+	c.Delete()
+	if got := c.Len(); got != 0 {
+		t.Errorf("Len() = %d after clearing the cache", got)
+	}
+}
@@ -0,0 +1,174 @@
+package fedbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+// IsSuspended returns true if the actor is a local one that has been suspended by a moderator,
+// and the suspension hasn't expired.
+func (ctl *Base) IsSuspended(actor vocab.IRI) bool {
+	if ctl.Storage == nil || actor == "" || actor.Equal(vocab.PublicNS) || ctl.Service.ID.Equal(actor) {
+		return false
+	}
+	if !actor.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+		return false
+	}
+	now := time.Now().UTC()
+	if m, ok := ctl.suspensions.load(actor, now); ok {
+		return m.IsSuspended(now)
+	}
+	m := new(ap.Metadata)
+	_ = ctl.Storage.LoadMetadata(actor, m)
+	ctl.suspensions.store(actor, *m, now)
+	return m.IsSuspended(now)
+}
+
+// suspensionCacheTTL is how long we keep the suspension state of an actor before loading its metadata again.
+// The commands that run inside the server clear the state of the actors they change, this is only
+// for the ones that run in a separate process.
+var suspensionCacheTTL = time.Minute
+
+// suspensionCache holds the suspension state of the local actors, so we don't need to load their metadata
+// for every request they make, and every time they get loaded. A nil cache is valid, and doesn't store anything.
+type suspensionCache struct {
+	m sync.Map
+}
+
+type suspensionState struct {
+	Suspended, SuspendedUntil time.Time
+	loaded                    time.Time
+}
+
+func (c *suspensionCache) load(actor vocab.IRI, now time.Time) (ap.Metadata, bool) {
+	if c == nil {
+		return ap.Metadata{}, false
+	}
+	v, ok := c.m.Load(actor)
+	if !ok {
+		return ap.Metadata{}, false
+	}
+	st := v.(suspensionState)
+	if now.Sub(st.loaded) > suspensionCacheTTL {
+		c.m.Delete(actor)
+		return ap.Metadata{}, false
+	}
+	return ap.Metadata{Suspended: st.Suspended, SuspendedUntil: st.SuspendedUntil}, true
+}
+
+func (c *suspensionCache) store(actor vocab.IRI, m ap.Metadata, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.m.Store(actor, suspensionState{Suspended: m.Suspended, SuspendedUntil: m.SuspendedUntil, loaded: now})
+}
+
+func (c *suspensionCache) forget(actor vocab.IRI) {
+	if c == nil {
+		return
+	}
+	c.m.Delete(actor)
+}
+
+// suspendedCollection returns an empty collection in place of the collections of the suspended actors.
+func (ctl *Base) suspendedCollection(iri vocab.IRI) vocab.CollectionInterface {
+	u, err := iri.URL()
+	if err != nil {
+		return nil
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	owner, typ := vocab.Split(vocab.IRI(u.String()))
+	if !vocab.ValidCollection(typ) || !ctl.IsSuspended(owner) {
+		return nil
+	}
+	return emptyCollection(ctl, vocab.IRI(u.String()))
+}
+
+// suspendedPlaceholder returns the representation we serve for suspended actors: enough for other servers
+// to identify them, without any of their profile information.
+func suspendedPlaceholder(act *vocab.Actor) *vocab.Actor {
+	return &vocab.Actor{
+		ID:                act.ID,
+		Type:              act.Type,
+		PreferredUsername: act.PreferredUsername,
+		Inbox:             act.Inbox,
+		Outbox:            act.Outbox,
+		Published:         act.Published,
+	}
+}
+
+// ExpireSuspensions lifts the temporary suspensions of the local actors that have expired.
+func (ctl *Base) ExpireSuspensions() (int, error) {
+	iri := ap.SearchActorsIRI(vocab.IRI(ctl.Conf.BaseURL), ap.ByType(vocab.ActorTypes...))
+
+	now := time.Now().UTC()
+	lifted := 0
+	errs := make([]error, 0)
+	err := streamCollection(ctl, iri, func(it vocab.Item) error {
+		actor := it.GetLink()
+		if !actor.Contains(vocab.IRI(ctl.Conf.BaseURL), false) {
+			return nil
+		}
+		m := new(ap.Metadata)
+		if err := ctl.Storage.LoadMetadata(actor, m); err != nil {
+			return nil
+		}
+		if m.Suspended.IsZero() || m.IsSuspended(now) {
+			return nil
+		}
+		m.LiftSuspension()
+		if err := ctl.Storage.SaveMetadata(actor, m); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to lift the suspension of %s", actor))
+			return nil
+		}
+		ctl.suspensions.forget(actor)
+		lifted++
+		return nil
+	})
+	if err != nil {
+		return lifted, err
+	}
+	return lifted, errors.Join(errs...)
+}
+
+// suspensionExpiryInterval is how often we check for temporary suspensions that have expired.
+var suspensionExpiryInterval = time.Hour
+
+// scheduleSuspensionExpiry periodically lifts the expired suspensions, until the context is canceled.
+// The expired suspensions are not enforced even before this runs, it only cleans up the metadata.
+func (f *FedBOX) scheduleSuspensionExpiry(ctx context.Context) {
+	expire := func() {
+		if f.maintenanceMode.Load() || f.readOnlyMode.Load() || f.shuttingDown.Load() {
+			return
+		}
+		count, err := f.ExpireSuspensions()
+		if err != nil {
+			f.Logger.WithContext(lw.Ctx{"err": err.Error()}).Warnf("Unable to lift expired suspensions")
+		}
+		if count > 0 {
+			f.Logger.WithContext(lw.Ctx{"count": count}).Infof("Lifted expired suspensions")
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(suspensionExpiryInterval)
+		defer ticker.Stop()
+
+		expire()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expire()
+			}
+		}
+	}()
+}
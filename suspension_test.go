@@ -0,0 +1,38 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+)
+
+func TestBase_IsSuspended(t *testing.T) {
+	ctl, db := checkTestBase(t)
+	ctl.suspensions = new(suspensionCache)
+
+	actor := vocab.IRI(checkBaseURL + "/actors/1")
+	_ = db.SaveMetadata(actor, &ap.Metadata{Suspended: time.Now().UTC()})
+	if !ctl.IsSuspended(actor) {
+		t.Fatalf("expected %s to be suspended", actor)
+	}
+
+	_ = db.SaveMetadata(actor, &ap.Metadata{})
+	if !ctl.IsSuspended(actor) {
+		t.Errorf("expected the suspension state of %s to be loaded from the cache", actor)
+	}
+	ctl.suspensions.forget(actor)
+	if ctl.IsSuspended(actor) {
+		t.Errorf("expected %s not to be suspended after the cache has been cleared", actor)
+	}
+
+	_ = db.SaveMetadata(actor, &ap.Metadata{Suspended: time.Now().UTC()})
+	ctl.suspensions.forget(actor)
+	if col := ctl.suspendedCollection(vocab.Outbox.IRI(actor) + "?maxItems=10"); col == nil || col.Count() != 0 {
+		t.Errorf("expected an empty outbox for the suspended actor, got %v", col)
+	}
+	if col := ctl.suspendedCollection(vocab.Outbox.IRI(vocab.IRI(checkBaseURL + "/actors/2"))); col != nil {
+		t.Errorf("expected the outbox of an active actor to be served, got %v", col)
+	}
+}
@@ -0,0 +1,170 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"git.sr.ht/~mariusor/storage-all"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/openshift/osin"
+)
+
+// tokenIndexStorage wraps the storage, and keeps the codes of the OAuth2 authorizations and access tokens
+// in the metadata of the actors they have been issued to.
+// Osin.Storage can load these records only by their code, and none of the storage backends can list them,
+// so this is how we find the tokens of an actor when revoking them, or all of them when migrating the storage.
+// The records that don't belong to an actor are kept in the metadata of the service.
+// Only the tokens saved through our storage are indexed, the ones saved by other services that share it,
+// or before we started indexing them, are not.
+type tokenIndexStorage struct {
+	storage.FullStorage
+	service vocab.IRI
+	mu      *sync.Mutex
+}
+
+var _ storage.FullStorage = tokenIndexStorage{}
+
+func withTokenIndex(db storage.FullStorage, service vocab.IRI) storage.FullStorage {
+	if db == nil {
+		return nil
+	}
+	return tokenIndexStorage{FullStorage: db, service: service, mu: new(sync.Mutex)}
+}
+
+// tokenOwner returns the IRI of the actor an OAuth2 record has been issued to, from its user data.
+func tokenOwner(data any) vocab.IRI {
+	switch d := data.(type) {
+	case nil:
+		return ""
+	case string:
+		return vocab.IRI(d)
+	case []byte:
+		return vocab.IRI(d)
+	case json.RawMessage:
+		var s string
+		if err := json.Unmarshal(d, &s); err == nil {
+			return vocab.IRI(s)
+		}
+		return vocab.IRI(d)
+	case fmt.Stringer:
+		return vocab.IRI(d.String())
+	}
+	return ""
+}
+
+// owner returns the actor in whose metadata we index a record with the data user data.
+func (s tokenIndexStorage) owner(data any) vocab.IRI {
+	if owner := tokenOwner(data); owner != "" {
+		return owner
+	}
+	return s.service
+}
+
+// index runs fn on the metadata of the owner, and saves it.
+func (s tokenIndexStorage) index(owner vocab.IRI, fn func(m *ap.Metadata)) error {
+	if owner == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := new(ap.Metadata)
+	_ = s.FullStorage.LoadMetadata(owner, m)
+	fn(m)
+	return s.FullStorage.SaveMetadata(owner, m)
+}
+
+// indexCode appends code to the codes, and it removes the ones for which the records can't be loaded anymore.
+func indexCode(codes []string, code string, load func(string) error) []string {
+	codes = slices.DeleteFunc(codes, func(c string) bool {
+		return c == code || load(c) != nil
+	})
+	return append(codes, code)
+}
+
+func (s tokenIndexStorage) Clone() osin.Storage {
+	if db, ok := s.FullStorage.Clone().(storage.FullStorage); ok {
+		return tokenIndexStorage{FullStorage: db, service: s.service, mu: s.mu}
+	}
+	return s
+}
+
+func (s tokenIndexStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	if err := s.FullStorage.SaveAuthorize(data); err != nil {
+		return err
+	}
+	return s.index(s.owner(data.UserData), func(m *ap.Metadata) {
+		m.Authorizations = indexCode(m.Authorizations, data.Code, func(code string) error {
+			_, err := s.FullStorage.LoadAuthorize(code)
+			return err
+		})
+	})
+}
+
+func (s tokenIndexStorage) RemoveAuthorize(code string) error {
+	data, _ := s.FullStorage.LoadAuthorize(code)
+	if err := s.FullStorage.RemoveAuthorize(code); err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+	return s.index(s.owner(data.UserData), func(m *ap.Metadata) {
+		m.Authorizations = slices.DeleteFunc(m.Authorizations, func(c string) bool { return c == code })
+	})
+}
+
+func (s tokenIndexStorage) SaveAccess(data *osin.AccessData) error {
+	if err := s.FullStorage.SaveAccess(data); err != nil {
+		return err
+	}
+	return s.index(s.owner(data.UserData), func(m *ap.Metadata) {
+		m.AccessTokens = indexCode(m.AccessTokens, data.AccessToken, func(token string) error {
+			_, err := s.FullStorage.LoadAccess(token)
+			return err
+		})
+	})
+}
+
+func (s tokenIndexStorage) RemoveAccess(token string) error {
+	data, _ := s.FullStorage.LoadAccess(token)
+	if err := s.FullStorage.RemoveAccess(token); err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+	return s.index(s.owner(data.UserData), func(m *ap.Metadata) {
+		m.AccessTokens = slices.DeleteFunc(m.AccessTokens, func(c string) bool { return c == token })
+	})
+}
+
+// Reindex passes through to the storage, so wrapping it doesn't hide its support for reindexing.
+func (s tokenIndexStorage) Reindex() error {
+	if indexer, ok := s.FullStorage.(reindexer); ok {
+		return indexer.Reindex()
+	}
+	return errors.NotImplementedf("current storage engine %T does not support reindexing", s.FullStorage)
+}
+
+// actorTokens loads the OAuth2 authorizations and access tokens indexed in the metadata of the actor,
+// skipping the ones that can't be loaded anymore.
+func actorTokens(db storage.FullStorage, m *ap.Metadata) ([]*osin.AuthorizeData, []*osin.AccessData) {
+	authorizations := make([]*osin.AuthorizeData, 0, len(m.Authorizations))
+	for _, code := range m.Authorizations {
+		if a, err := db.LoadAuthorize(code); err == nil && a != nil {
+			authorizations = append(authorizations, a)
+		}
+	}
+	accesses := make([]*osin.AccessData, 0, len(m.AccessTokens))
+	for _, token := range m.AccessTokens {
+		if a, err := db.LoadAccess(token); err == nil && a != nil {
+			accesses = append(accesses, a)
+		}
+	}
+	return authorizations, accesses
+}
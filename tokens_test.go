@@ -0,0 +1,41 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/openshift/osin"
+)
+
+func TestTokenIndexStorage(t *testing.T) {
+	db := newMemStorage()
+	service := vocab.IRI(checkBaseURL)
+	actor := vocab.IRI(checkBaseURL + "/actors/jdoe")
+	idx := withTokenIndex(db, service)
+
+	_ = idx.SaveAccess(&osin.AccessData{AccessToken: "token-1", UserData: actor})
+	_ = idx.SaveAccess(&osin.AccessData{AccessToken: "token-2", UserData: []byte(actor)})
+	_ = idx.SaveAuthorize(&osin.AuthorizeData{Code: "code"})
+
+	m := new(ap.Metadata)
+	_ = db.LoadMetadata(actor, m)
+	if len(m.AccessTokens) != 2 {
+		t.Errorf("indexed access tokens = %v, want 2", m.AccessTokens)
+	}
+	m = new(ap.Metadata)
+	_ = db.LoadMetadata(service, m)
+	if len(m.Authorizations) != 1 {
+		t.Errorf("the authorizations without an actor should be indexed for the service, got %v", m.Authorizations)
+	}
+
+	// The records removed without going through the index are dropped from it when a new one gets indexed
+	delete(db.access, "token-1")
+	_ = idx.SaveAccess(&osin.AccessData{AccessToken: "token-3", UserData: actor.String()})
+	_ = idx.RemoveAccess("token-2")
+	m = new(ap.Metadata)
+	_ = db.LoadMetadata(actor, m)
+	if len(m.AccessTokens) != 1 || m.AccessTokens[0] != "token-3" {
+		t.Errorf("indexed access tokens = %v, want [token-3]", m.AccessTokens)
+	}
+}